@@ -0,0 +1,485 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// anonymizedSurname replaces the name of anonymized participants.
+const anonymizedSurname = "Anonymized"
+
+// participantPaperHistory is a paper of a participant with all its uploads
+// and status changes.
+type participantPaperHistory struct {
+	Type        string
+	Label       string
+	Code        string
+	Status      paperStatus
+	Submissions []Submission
+}
+
+func participantURL(token, message, errorMessage string) string {
+	v := url.Values{}
+	if message != "" {
+		v.Set("message", message)
+	}
+	if errorMessage != "" {
+		v.Set("error", errorMessage)
+	}
+	if len(v) > 0 {
+		return "/admin/participants/" + url.PathEscape(token) + "?" + v.Encode()
+	}
+	return "/admin/participants/" + url.PathEscape(token)
+}
+
+// withCurrent adds the current value to the options when it's not one of
+// them, so editing doesn't lose values from older registration forms.
+func withCurrent(options []string, current string) []string {
+	if current == "" || contains(options, current) {
+		return options
+	}
+	return append(append([]string{}, options...), current)
+}
+
+// participantOr404 loads the participant of the route or renders not found.
+func (a *App) participantOr404(c *fiber.Ctx) (Participant, bool) {
+	participant, err := a.participantByToken(c.Params("token"))
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			a.log.Error(err)
+		}
+		c.Status(fiber.StatusNotFound)
+		c.Bind(fiber.Map{
+			"Title":   "Participant not found",
+			"Content": "The participant was deleted or their access token was regenerated.",
+		})
+		c.Render("basic", fiber.Map{})
+		return participant, false
+	}
+	return participant, true
+}
+
+func (a *App) participantPapers(token string) ([]participantPaperHistory, error) {
+	var submissions []Submission
+	if err := a.db.Where("token = ?", token).Order("type, artifact, version").Find(&submissions).Error; err != nil {
+		return nil, err
+	}
+	statuses, err := a.paperStatuses(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var papers []participantPaperHistory
+	for _, t := range uploadTypes {
+		key := paperKey{token, t.Type}
+		paper := participantPaperHistory{Type: t.Type, Label: t.Label, Code: key.Code(), Status: statuses[key]}
+		if paper.Status.Status == "" {
+			paper.Status.Status = StatusReceived
+		}
+		for _, s := range submissions {
+			if s.Type == t.Type {
+				paper.Submissions = append(paper.Submissions, s)
+			}
+		}
+		if len(paper.Submissions) > 0 || len(paper.Status.History) > 0 {
+			papers = append(papers, paper)
+		}
+	}
+
+	return papers, nil
+}
+
+func (a *App) participantAdminView(c *fiber.Ctx) error {
+	participant, ok := a.participantOr404(c)
+	if !ok {
+		return nil
+	}
+
+	papers, err := a.participantPapers(participant.Token)
+	if err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	var emails []EmailLog
+	if participant.Email != "" {
+		if err := a.db.Where("`to` = ?", participant.Email).Order("id DESC").Find(&emails).Error; err != nil {
+			a.log.Error(err)
+			return err
+		}
+	}
+
+	var notes []ParticipantNote
+	if err := a.db.Where("participant_token = ?", participant.Token).Order("id DESC").Find(&notes).Error; err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	return c.Render("admin-participant", fiber.Map{
+		"Title":        participant.Surname + " " + participant.Name,
+		"Participant":  participant,
+		"Papers":       papers,
+		"Emails":       emails,
+		"Notes":        notes,
+		"Forms":        withCurrent(RegistrationPageContent["ParticipationForm"].([]string), participant.PresentationForm),
+		"Sections":     withCurrent(PresentationSections, participant.PresentationSection),
+		"StatusLabels": StatusLabels,
+		"Domain":       a.config.Domain,
+		"Message":      c.Query("message"),
+		"Error":        c.Query("error"),
+	})
+}
+
+func (a *App) updateParticipant(c *fiber.Ctx) error {
+	participant, ok := a.participantOr404(c)
+	if !ok {
+		return nil
+	}
+
+	edited := participant
+	edited.Surname = strings.TrimSpace(c.FormValue("surname"))
+	edited.Name = strings.TrimSpace(c.FormValue("name"))
+	edited.Organization = strings.TrimSpace(c.FormValue("organization"))
+	edited.Position = strings.TrimSpace(c.FormValue("position"))
+	edited.Phone = strings.TrimSpace(c.FormValue("phone"))
+	edited.Email = strings.TrimSpace(c.FormValue("email"))
+	edited.PresentationForm = c.FormValue("presentation-form")
+	edited.PresentationSection = c.FormValue("presentation-section")
+	edited.PresentationTitle = strings.TrimSpace(c.FormValue("presentation-title"))
+
+	if edited.Surname == "" || edited.Name == "" {
+		return c.Redirect(participantURL(participant.Token, "", "Surname and name are required."))
+	}
+	if _, err := mail.ParseAddress(edited.Email); err != nil {
+		return c.Redirect(participantURL(participant.Token, "", "Wrong email format. Example: mail@example.com"))
+	}
+
+	// Select saves cleared fields too.
+	if err := a.db.Model(&Participant{}).Where("token = ?", participant.Token).
+		Select("surname", "name", "organization", "position", "phone", "email", "presentation_form", "presentation_section", "presentation_title").
+		Updates(edited).Error; err != nil {
+		a.log.Error(err)
+		return c.Redirect(participantURL(participant.Token, "", "Can't save the participant."))
+	}
+
+	a.audit(c, "participant.update", participant.auditTarget(), participant, edited)
+	return c.Redirect(participantURL(participant.Token, "Saved.", ""))
+}
+
+func (a *App) createParticipantNote(c *fiber.Ctx) error {
+	participant, ok := a.participantOr404(c)
+	if !ok {
+		return nil
+	}
+
+	text := strings.TrimSpace(c.FormValue("text"))
+	if text == "" {
+		return c.Redirect(participantURL(participant.Token, "", ""))
+	}
+
+	note := ParticipantNote{ParticipantToken: participant.Token, Author: adminActor(c), Text: text}
+	if err := a.db.Create(&note).Error; err != nil {
+		a.log.Error(err)
+		return c.Redirect(participantURL(participant.Token, "", "Can't save the note."))
+	}
+	a.audit(c, "participant.note", participant.auditTarget(), nil, nil)
+
+	return c.Redirect(participantURL(participant.Token, "", ""))
+}
+
+// retoken moves everything of a participant to a new access token, the old
+// link stops working.
+func retoken(tx *gorm.DB, token string) (string, error) {
+	newToken := uuid.New().String()
+
+	for _, update := range []struct {
+		model  interface{}
+		column string
+	}{
+		{&Participant{}, "token"},
+		{&Submission{}, "token"},
+		{&Assignment{}, "participant_token"},
+		{&StatusChange{}, "participant_token"},
+		{&Slot{}, "participant_token"},
+		{&ParticipantNote{}, "participant_token"},
+	} {
+		if err := tx.Model(update.model).Where(update.column+" = ?", token).Update(update.column, newToken).Error; err != nil {
+			return "", err
+		}
+	}
+
+	return newToken, nil
+}
+
+func (a *App) regenerateParticipantToken(c *fiber.Ctx) error {
+	participant, ok := a.participantOr404(c)
+	if !ok {
+		return nil
+	}
+
+	var token string
+	err := a.db.Transaction(func(tx *gorm.DB) (err error) {
+		token, err = retoken(tx, participant.Token)
+		return err
+	})
+	if err != nil {
+		a.log.Error(err)
+		return c.Redirect(participantURL(participant.Token, "", "Can't regenerate the token."))
+	}
+
+	a.audit(c, "participant.token", participant.auditTarget(), map[string]string{"Code": participant.Code()}, map[string]string{"Code": Participant{Token: token}.Code()})
+	return c.Redirect(participantURL(token, "The access token is regenerated, send the participant the new link.", ""))
+}
+
+func (a *App) resendParticipantLink(c *fiber.Ctx) error {
+	participant, ok := a.participantOr404(c)
+	if !ok {
+		return nil
+	}
+
+	if err := a.sendEmail(
+		To{strings.Join([]string{participant.Name, participant.Surname}, " "), participant.Email},
+		Message{ParticipantLinkEmail.Subject, fmt.Sprintf(ParticipantLinkEmail.Text, participant.Name, a.config.Domain, participant.Token)},
+	); err != nil {
+		a.log.Errorf("Can't send email to %s: %v", participant.Email, err)
+		return c.Redirect(participantURL(participant.Token, "", "Can't send the email: "+err.Error()))
+	}
+	a.audit(c, "mail.participant-link", participant.auditTarget(), nil, nil)
+
+	return c.Redirect(participantURL(participant.Token, "The link is sent to "+participant.Email+".", ""))
+}
+
+// Code is an opaque identifier of the participant for the audit log. It
+// changes with the access token.
+func (p Participant) Code() string {
+	return "U-" + paperKey{Token: p.Token}.Code()[len("P-"):]
+}
+
+// auditTarget names the participant in the audit log. Deletions and
+// anonymizations keep only it, not the erased data.
+func (p Participant) auditTarget() string {
+	return "participant " + p.Code()
+}
+
+// auditPersonalFields are written to the audit log only as changed, the log
+// outlives deletions and anonymizations.
+func (Participant) auditPersonalFields() []string {
+	return []string{"Surname", "Name", "Position", "Phone", "Email"}
+}
+
+// ConfirmationText is typed to confirm deleting or anonymizing, the email
+// or "delete" for participants without one.
+func (p Participant) ConfirmationText() string {
+	if p.Email == "" {
+		return "delete"
+	}
+	return p.Email
+}
+
+func confirmed(c *fiber.Ctx, participant Participant) bool {
+	return strings.EqualFold(strings.TrimSpace(c.FormValue("confirm")), participant.ConfirmationText())
+}
+
+func (a *App) deleteParticipant(c *fiber.Ctx) error {
+	participant, ok := a.participantOr404(c)
+	if !ok {
+		return nil
+	}
+	if !confirmed(c, participant) {
+		return c.Redirect(participantURL(participant.Token, "", "Type "+participant.ConfirmationText()+" to confirm."))
+	}
+
+	var paths []string
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Submission{}).Where("token = ?", participant.Token).Pluck("path", &paths).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("assignment_id IN (?)", tx.Model(&Assignment{}).Select("id").Where("participant_token = ?", participant.Token)).
+			Delete(&Review{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&Assignment{}, &StatusChange{}, &Slot{}, &ParticipantNote{}} {
+			if err := tx.Where("participant_token = ?", participant.Token).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("token = ?", participant.Token).Delete(&Submission{}).Error; err != nil {
+			return err
+		}
+		if participant.Email != "" {
+			if err := tx.Where("`to` = ?", participant.Email).Delete(&EmailLog{}).Error; err != nil {
+				return err
+			}
+			if err := forgetImports(tx, participant.Email); err != nil {
+				return err
+			}
+			var quarantined []string
+			if err := tx.Model(&QuarantinedFile{}).Where("email = ?", participant.Email).Pluck("path", &quarantined).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("email = ?", participant.Email).Delete(&QuarantinedFile{}).Error; err != nil {
+				return err
+			}
+			paths = append(paths, quarantined...)
+		}
+		return tx.Where("token = ?", participant.Token).Delete(&Participant{}).Error
+	})
+	if err != nil {
+		a.log.Error(err)
+		return c.Redirect(participantURL(participant.Token, "", "Can't delete the participant."))
+	}
+
+	for _, path := range paths {
+		if err := a.disk.Delete(path); err != nil {
+			a.log.Errorf("Can't delete file '%s': %v", path, err)
+		}
+	}
+
+	a.audit(c, "participant.delete", participant.auditTarget(), nil, nil)
+	return c.Redirect("/admin")
+}
+
+// renamedFile is a stored file of a record moved to a new name.
+type renamedFile struct {
+	ID       uint
+	From, To string
+}
+
+// anonymizedPath names a file in the folder of p after its record instead
+// of the participant, stored file names carry the name and the email.
+func anonymizedPath(p, kind string, id uint) string {
+	return path.Join(path.Dir(p), fmt.Sprintf("%s_%s_%d%s", anonymizedSurname, kind, id, strings.ToLower(path.Ext(p))))
+}
+
+// copyFiles copies files to their new names, removing the copies made so far
+// when one fails.
+func (a *App) copyFiles(files []renamedFile) error {
+	for i, f := range files {
+		err := func() error {
+			content, err := a.disk.Open(f.From)
+			if err != nil {
+				return err
+			}
+			defer content.Close()
+			return a.disk.Save(content, f.To)
+		}()
+		if err != nil {
+			a.deleteFiles(files[:i], false)
+			return fmt.Errorf("copy '%s': %w", f.From, err)
+		}
+	}
+	return nil
+}
+
+// deleteFiles deletes the old or the new names of files.
+func (a *App) deleteFiles(files []renamedFile, old bool) {
+	for _, f := range files {
+		name := f.To
+		if old {
+			name = f.From
+		}
+		if err := a.disk.Delete(name); err != nil {
+			a.log.Errorf("Can't delete file '%s': %v", name, err)
+		}
+	}
+}
+
+// anonymizeParticipant removes personal data but keeps uploads, statuses and
+// the schedule for statistics and the proceedings. Stored files are renamed,
+// their names are built from the name and the email.
+func (a *App) anonymizeParticipant(c *fiber.Ctx) error {
+	participant, ok := a.participantOr404(c)
+	if !ok {
+		return nil
+	}
+	if !confirmed(c, participant) {
+		return c.Redirect(participantURL(participant.Token, "", "Type "+participant.ConfirmationText()+" to confirm."))
+	}
+
+	var submissions []Submission
+	if err := a.db.Where("token = ?", participant.Token).Find(&submissions).Error; err != nil {
+		a.log.Error(err)
+		return c.Redirect(participantURL(participant.Token, "", "Can't anonymize the participant."))
+	}
+	var quarantined []QuarantinedFile
+	if participant.Email != "" {
+		if err := a.db.Where("email = ?", participant.Email).Find(&quarantined).Error; err != nil {
+			a.log.Error(err)
+			return c.Redirect(participantURL(participant.Token, "", "Can't anonymize the participant."))
+		}
+	}
+
+	var files, quarantinedFiles []renamedFile
+	for _, s := range submissions {
+		files = append(files, renamedFile{s.ID, s.Path, anonymizedPath(s.Path, s.Type, s.ID)})
+	}
+	for _, q := range quarantined {
+		quarantinedFiles = append(quarantinedFiles, renamedFile{q.ID, q.Path, anonymizedPath(q.Path, "quarantined", q.ID)})
+	}
+	if err := a.copyFiles(append(files, quarantinedFiles...)); err != nil {
+		a.log.Error(err)
+		return c.Redirect(participantURL(participant.Token, "", "Can't anonymize the participant."))
+	}
+
+	var token string
+	err := a.db.Transaction(func(tx *gorm.DB) (err error) {
+		if err := tx.Model(&Participant{}).Where("token = ?", participant.Token).Updates(map[string]interface{}{
+			"surname":  anonymizedSurname,
+			"name":     "",
+			"position": "",
+			"phone":    "",
+			"email":    "",
+		}).Error; err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := tx.Model(&Submission{}).Where("id = ?", f.ID).
+				Updates(map[string]interface{}{"path": f.To, "original_name": path.Base(f.To)}).Error; err != nil {
+				return err
+			}
+		}
+		for _, f := range quarantinedFiles {
+			if err := tx.Model(&QuarantinedFile{}).Where("id = ?", f.ID).
+				Updates(map[string]interface{}{"path": f.To, "original_name": path.Base(f.To), "email": ""}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("participant_token = ?", participant.Token).Delete(&ParticipantNote{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Slot{}).Where("participant_token = ?", participant.Token).Update("speaker", "").Error; err != nil {
+			return err
+		}
+		if participant.Email != "" {
+			if err := tx.Where("`to` = ?", participant.Email).Delete(&EmailLog{}).Error; err != nil {
+				return err
+			}
+			if err := forgetImports(tx, participant.Email); err != nil {
+				return err
+			}
+			if err := tx.Model(&StatusChange{}).Where("participant_token = ? AND actor = ?", participant.Token, participant.Email).
+				Update("actor", "participant").Error; err != nil {
+				return err
+			}
+		}
+		token, err = retoken(tx, participant.Token)
+		return err
+	})
+	if err != nil {
+		a.log.Error(err)
+		a.deleteFiles(append(files, quarantinedFiles...), false)
+		return c.Redirect(participantURL(participant.Token, "", "Can't anonymize the participant."))
+	}
+	a.deleteFiles(append(files, quarantinedFiles...), true)
+
+	a.audit(c, "participant.anonymize", participant.auditTarget(), map[string]string{"Code": participant.Code()}, map[string]string{"Code": Participant{Token: token}.Code()})
+	return c.Redirect(participantURL(token, "The participant is anonymized.", ""))
+}
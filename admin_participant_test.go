@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestDeleteParticipantQuarantine(t *testing.T) {
+	a := newTestApp(t)
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("admin", AdminUser{Username: "olga", Role: RoleOrganizer})
+		return c.Next()
+	})
+	app.Post("/admin/participants/:token/delete", a.deleteParticipant)
+
+	if err := a.db.Create(&Participant{Token: "anna", Email: "anna@example.com"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []QuarantinedFile{
+		{Path: "quarantine/anna.docx", Email: "anna@example.com"},
+		{Path: "quarantine/boris.docx", Email: "boris@example.com"},
+	} {
+		if err := a.disk.Save(strings.NewReader("infected"), q.Path); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.db.Create(&q).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	form := url.Values{"confirm": {"anna@example.com"}}
+	req := httptest.NewRequest(http.MethodPost, "/admin/participants/anna/delete", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+
+	var quarantined []QuarantinedFile
+	if err := a.db.Find(&quarantined).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 1 || quarantined[0].Email != "boris@example.com" {
+		t.Errorf("quarantine after delete is %+v, want only the file of boris", quarantined)
+	}
+	if _, err := a.disk.Stat("quarantine/anna.docx"); err == nil {
+		t.Error("quarantined file of the deleted participant is still stored")
+	}
+	if _, err := a.disk.Stat("quarantine/boris.docx"); err != nil {
+		t.Errorf("quarantined file of another participant is gone: %v", err)
+	}
+}
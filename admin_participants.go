@@ -0,0 +1,394 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// participantColumn is a column of the admin participant table. Order is
+// the ORDER BY of sortable columns.
+type participantColumn struct {
+	Key   string
+	Label string
+	Order []string
+}
+
+func (c participantColumn) Sortable() bool {
+	return len(c.Order) > 0
+}
+
+var participantColumns = []participantColumn{
+	{"name", "Name", []string{"surname", "name"}},
+	{"email", "Email", []string{"email"}},
+	{"organization", "Organization", []string{"organization"}},
+	{"position", "Position", []string{"position"}},
+	{"phone", "Phone", nil},
+	{"form", "Participation form", []string{"presentation_form"}},
+	{"section", "Section", []string{"presentation_section"}},
+	{"title", "Title", []string{"presentation_title"}},
+	{"uploads", "Uploads", nil},
+	{"status", "Status", nil},
+}
+
+var defaultParticipantColumns = []string{"name", "email", "form", "section", "uploads", "status"}
+
+var participantPageSizes = []int{25, 50, 100}
+
+// Upload filters besides upload types.
+const (
+	UploadAny  = "any"
+	UploadNone = "none"
+)
+
+func participantColumnByKey(key string) (participantColumn, bool) {
+	for _, c := range participantColumns {
+		if c.Key == key {
+			return c, true
+		}
+	}
+	return participantColumn{}, false
+}
+
+// ParticipantFilter is the state of the admin participant table, all of it
+// is kept in the URL.
+type ParticipantFilter struct {
+	Query   string
+	Form    string
+	Section string
+	Status  string
+	Upload  string
+	Sort    string
+	Desc    bool
+	Page    int
+	PerPage int
+	Columns []string
+}
+
+func parseParticipantFilter(c *fiber.Ctx) ParticipantFilter {
+	f := ParticipantFilter{
+		Query:   strings.TrimSpace(c.Query("q")),
+		Form:    c.Query("form"),
+		Section: c.Query("section"),
+		Status:  c.Query("status"),
+		Upload:  c.Query("upload"),
+		Sort:    c.Query("sort"),
+		Desc:    c.Query("dir") == "desc",
+		PerPage: participantPageSizes[0],
+	}
+
+	if len(f.Query) > 200 {
+		f.Query = f.Query[:200]
+	}
+	if !contains(Statuses, f.Status) {
+		f.Status = ""
+	}
+	// Unknown upload types have no label.
+	if f.Upload != UploadAny && f.Upload != UploadNone && uploadTypeLabel(f.Upload) == f.Upload {
+		f.Upload = ""
+	}
+	if column, ok := participantColumnByKey(f.Sort); !ok || !column.Sortable() {
+		f.Sort = "name"
+	}
+
+	if per, err := strconv.Atoi(c.Query("per")); err == nil {
+		for _, size := range participantPageSizes {
+			if per == size {
+				f.PerPage = per
+			}
+		}
+	}
+	f.Page, _ = strconv.Atoi(c.Query("page"))
+	if f.Page < 1 {
+		f.Page = 1
+	}
+
+	for _, key := range c.Context().QueryArgs().PeekMulti("col") {
+		if _, ok := participantColumnByKey(string(key)); ok && !contains(f.Columns, string(key)) {
+			f.Columns = append(f.Columns, string(key))
+		}
+	}
+	if len(f.Columns) == 0 {
+		f.Columns = defaultParticipantColumns
+	}
+
+	return f
+}
+
+func (f ParticipantFilter) values() url.Values {
+	v := url.Values{}
+	for name, value := range map[string]string{"q": f.Query, "form": f.Form, "section": f.Section, "status": f.Status, "upload": f.Upload} {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+	if f.Sort != "name" {
+		v.Set("sort", f.Sort)
+	}
+	if f.Desc {
+		v.Set("dir", "desc")
+	}
+	if f.PerPage != participantPageSizes[0] {
+		v.Set("per", strconv.Itoa(f.PerPage))
+	}
+	if f.Page > 1 {
+		v.Set("page", strconv.Itoa(f.Page))
+	}
+	if strings.Join(f.Columns, ",") != strings.Join(defaultParticipantColumns, ",") {
+		v["col"] = f.Columns
+	}
+	return v
+}
+
+func (f ParticipantFilter) URL() string {
+	if v := f.values(); len(v) > 0 {
+		return "/admin?" + v.Encode()
+	}
+	return "/admin"
+}
+
+func (f ParticipantFilter) PageURL(page int) string {
+	f.Page = page
+	return f.URL()
+}
+
+// SortURL sorts by the column, the other way round when already sorted by it.
+func (f ParticipantFilter) SortURL(key string) string {
+	f.Desc = f.Sort == key && !f.Desc
+	f.Sort = key
+	f.Page = 1
+	return f.URL()
+}
+
+func (f ParticipantFilter) Shows(key string) bool {
+	return contains(f.Columns, key)
+}
+
+// Filtered is true when anything narrows the list down.
+func (f ParticipantFilter) Filtered() bool {
+	return f.Query != "" || f.Form != "" || f.Section != "" || f.Status != "" || f.Upload != ""
+}
+
+// escapeLike escapes LIKE wildcards with "!", which needs no quoting in
+// SQLite and MySQL.
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// latestStatusTokens selects participants with a paper whose last status
+// change is to a status.
+const latestStatusTokens = "SELECT sc.participant_token FROM status_changes sc WHERE sc.`to` = ? AND sc.id = " +
+	"(SELECT MAX(id) FROM status_changes WHERE participant_token = sc.participant_token AND type = sc.type)"
+
+// unchangedPaperTokens selects participants with a paper without status
+// changes, which is received.
+const unchangedPaperTokens = "SELECT s.token FROM submissions s WHERE s.artifact = '' AND NOT EXISTS " +
+	"(SELECT 1 FROM status_changes WHERE participant_token = s.token AND type = s.type)"
+
+func (a *App) participantQuery(f ParticipantFilter) *gorm.DB {
+	query := a.db.Model(&Participant{})
+
+	// Every word matches one of the fields.
+	for _, word := range strings.Fields(strings.ToLower(f.Query)) {
+		like := "%" + escapeLike(word) + "%"
+		query = query.Where("(LOWER(name) LIKE ? ESCAPE '!' OR LOWER(surname) LIKE ? ESCAPE '!' OR LOWER(email) LIKE ? ESCAPE '!' OR LOWER(organization) LIKE ? ESCAPE '!' OR LOWER(presentation_title) LIKE ? ESCAPE '!')",
+			like, like, like, like, like)
+	}
+
+	if f.Form != "" {
+		query = query.Where("presentation_form = ?", f.Form)
+	}
+	if f.Section != "" {
+		query = query.Where("presentation_section = ?", f.Section)
+	}
+
+	switch f.Upload {
+	case "":
+	case UploadAny:
+		query = query.Where("token IN (SELECT token FROM submissions WHERE artifact = '')")
+	case UploadNone:
+		query = query.Where("token NOT IN (SELECT token FROM submissions WHERE artifact = '')")
+	default:
+		query = query.Where("token IN (SELECT token FROM submissions WHERE artifact = '' AND type = ?)", f.Upload)
+	}
+
+	if f.Status == StatusReceived {
+		query = query.Where("(token IN ("+latestStatusTokens+") OR token IN ("+unchangedPaperTokens+"))", f.Status)
+	} else if f.Status != "" {
+		query = query.Where("token IN ("+latestStatusTokens+")", f.Status)
+	}
+
+	return query
+}
+
+// orderedParticipantQuery selects the participants of the filter in the
+// order of the table.
+func (a *App) orderedParticipantQuery(f ParticipantFilter) *gorm.DB {
+	column, _ := participantColumnByKey(f.Sort)
+	query := a.participantQuery(f)
+	for _, order := range column.Order {
+		if f.Desc {
+			order += " DESC"
+		}
+		query = query.Order(order)
+	}
+	return query.Order("token")
+}
+
+// participantPaper is an upload of a participant in the table.
+type participantPaper struct {
+	Type     string
+	Label    string
+	Versions int
+	Status   string
+}
+
+func (p participantPaper) StatusLabel() string {
+	return StatusLabels[p.Status]
+}
+
+type participantRow struct {
+	Participant
+	Papers []participantPaper
+}
+
+// participantRows adds uploads and statuses to a page of participants.
+func (a *App) participantRows(participants []Participant) ([]participantRow, error) {
+	rows := make([]participantRow, 0, len(participants))
+	if len(participants) == 0 {
+		return rows, nil
+	}
+
+	tokens := make([]string, 0, len(participants))
+	for _, p := range participants {
+		tokens = append(tokens, p.Token)
+	}
+
+	var submissions []Submission
+	if err := a.db.Where("token IN ? AND artifact = ''", tokens).Find(&submissions).Error; err != nil {
+		return nil, err
+	}
+	var changes []StatusChange
+	if err := a.db.Where("participant_token IN ?", tokens).Order("id").Find(&changes).Error; err != nil {
+		return nil, err
+	}
+
+	versions := make(map[paperKey]int)
+	for _, s := range submissions {
+		key := paperKey{s.Token, s.Type}
+		if s.Version > versions[key] {
+			versions[key] = s.Version
+		}
+	}
+	statuses := make(map[paperKey]string)
+	for _, change := range changes {
+		statuses[paperKey{change.ParticipantToken, change.Type}] = change.To
+	}
+
+	for _, p := range participants {
+		row := participantRow{Participant: p}
+		for _, t := range uploadTypes {
+			key := paperKey{p.Token, t.Type}
+			if versions[key] == 0 {
+				continue
+			}
+			status := statuses[key]
+			if status == "" {
+				status = StatusReceived
+			}
+			row.Papers = append(row.Papers, participantPaper{Type: t.Type, Label: t.Label, Versions: versions[key], Status: status})
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// pagination of a table, Pages are the neighbouring page numbers.
+type pagination struct {
+	Page  int
+	Last  int
+	Total int64
+	From  int
+	To    int
+	Pages []int
+}
+
+func paginate(page, perPage int, total int64) pagination {
+	p := pagination{Page: page, Total: total, Last: int((total + int64(perPage) - 1) / int64(perPage))}
+	if p.Last < 1 {
+		p.Last = 1
+	}
+	if p.Page > p.Last {
+		p.Page = p.Last
+	}
+	if total > 0 {
+		p.From = (p.Page-1)*perPage + 1
+		p.To = p.From + perPage - 1
+		if int64(p.To) > total {
+			p.To = int(total)
+		}
+	}
+	for n := p.Page - 2; n <= p.Page+2; n++ {
+		if n >= 1 && n <= p.Last {
+			p.Pages = append(p.Pages, n)
+		}
+	}
+	return p
+}
+
+// bindParticipantTable binds a page of the participant table of the filter
+// in the URL.
+func (a *App) bindParticipantTable(c *fiber.Ctx) error {
+	f := parseParticipantFilter(c)
+
+	var total int64
+	if err := a.participantQuery(f).Count(&total).Error; err != nil {
+		return err
+	}
+	pages := paginate(f.Page, f.PerPage, total)
+	f.Page = pages.Page
+
+	var participants []Participant
+	if err := a.orderedParticipantQuery(f).Offset((f.Page - 1) * f.PerPage).Limit(f.PerPage).Find(&participants).Error; err != nil {
+		return err
+	}
+	rows, err := a.participantRows(participants)
+	if err != nil {
+		return err
+	}
+
+	columns := make([]participantColumn, 0, len(f.Columns))
+	for _, key := range f.Columns {
+		column, _ := participantColumnByKey(key)
+		columns = append(columns, column)
+	}
+
+	var forms, sections []string
+	if err := a.db.Model(&Participant{}).Where("presentation_form <> ''").Distinct().Order("presentation_form").Pluck("presentation_form", &forms).Error; err != nil {
+		return err
+	}
+	if err := a.db.Model(&Participant{}).Where("presentation_section <> ''").Distinct().Order("presentation_section").Pluck("presentation_section", &sections).Error; err != nil {
+		return err
+	}
+
+	c.Bind(fiber.Map{
+		"Participants":   rows,
+		"Filter":         f,
+		"Pagination":     pages,
+		"Columns":        columns,
+		"AllColumns":     participantColumns,
+		"PageSizes":      participantPageSizes,
+		"Forms":          forms,
+		"FilterSections": sections,
+		"Statuses":       Statuses,
+		"StatusLabels":   StatusLabels,
+		"UploadTypes":    uploadTypes,
+		"ExportColumns":  exportColumns,
+	})
+
+	return nil
+}
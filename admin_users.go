@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	RoleSuperadmin = "superadmin"
+	RoleOrganizer  = "organizer"
+	RoleChair      = "chair"
+)
+
+var Roles = []string{RoleSuperadmin, RoleOrganizer, RoleChair}
+
+var RoleLabels = map[string]string{
+	RoleSuperadmin: "Superadmin",
+	RoleOrganizer:  "Organizer",
+	RoleChair:      "Session chair",
+}
+
+// Permissions of admin routes.
+const (
+	// PermView is reading participants, files, camera-ready and the schedule.
+	PermView = "view"
+	// PermReviews is reading reviews and assignments.
+	PermReviews = "reviews"
+	// PermManage is changing anything but admin accounts.
+	PermManage = "manage"
+	// PermUsers is managing admin accounts.
+	PermUsers = "users"
+)
+
+// rolePermissions are permissions granted to roles. Session chairs read
+// everything but can't change anything. There is no reviewer role: the admin
+// pages show authors next to their papers, reviewers work blind through the
+// link from their invitation.
+var rolePermissions = map[string][]string{
+	RoleSuperadmin: {PermView, PermReviews, PermManage, PermUsers},
+	RoleOrganizer:  {PermView, PermReviews, PermManage},
+	RoleChair:      {PermView, PermReviews},
+}
+
+// cliActor is the actor in the audit log of changes made with the command
+// line.
+const cliActor = "cli"
+
+// legacyAdmin is the shared account of ADMIN_PASSWORD. It only signs in
+// until the first admin account is created.
+const legacyAdmin = "admin"
+
+var ErrAdminUserNotFound = errors.New("admin user not found")
+
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func (u AdminUser) Can(perm string) bool {
+	return !u.Disabled && contains(rolePermissions[u.Role], perm)
+}
+
+func (u AdminUser) RoleLabel() string {
+	return RoleLabels[u.Role]
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < 8 {
+		return "", errors.New("password must be at least 8 characters long")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func (a *App) adminUserCount() int64 {
+	var count int64
+	if err := a.db.Model(&AdminUser{}).Count(&count).Error; err != nil {
+		a.log.Error(err)
+	}
+	return count
+}
+
+// authorizeAdmin checks credentials of a sign in.
+func (a *App) authorizeAdmin(username, password string) bool {
+	var user AdminUser
+	err := a.db.Where("username = ?", username).First(&user).Error
+	if err == nil {
+		return !user.Disabled && bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		a.log.Error(err)
+		return false
+	}
+
+	if username == legacyAdmin && a.config.AdminPassword != "" && subtle.ConstantTimeCompare([]byte(password), []byte(a.config.AdminPassword)) == 1 && a.adminUserCount() == 0 {
+		a.log.Warn("Signed in with ADMIN_PASSWORD, create an admin account with `amtc admin create`")
+		return true
+	}
+
+	return false
+}
+
+// loadAdminUser puts the signed in account to locals and templates.
+func (a *App) loadAdminUser(c *fiber.Ctx) error {
+	username := adminActor(c)
+
+	var user AdminUser
+	if err := a.db.Where("username = ?", username).First(&user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) || username != legacyAdmin || a.adminUserCount() > 0 {
+			a.revokeSessions(username)
+			return c.Redirect("/admin/login")
+		}
+		user = AdminUser{Username: legacyAdmin, Role: RoleSuperadmin}
+	}
+	if user.Disabled {
+		a.revokeSessions(username)
+		return c.Redirect("/admin/login")
+	}
+
+	// Roles required to have a second factor enroll before anything else,
+	// single sign-on too: the identity provider doesn't tell whether it
+	// asked for one.
+	if user.ID != 0 && !user.TOTPEnabled && a.config.TOTPRequired(user.Role) &&
+		!strings.HasPrefix(c.Path(), "/admin/totp") && c.Path() != "/admin/logout" {
+		return c.Redirect("/admin/totp")
+	}
+
+	c.Locals("admin", user)
+	c.Bind(fiber.Map{"Admin": user})
+
+	return c.Next()
+}
+
+// allow restricts a route to admins with the permission.
+func allow(perm string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if user, ok := c.Locals("admin").(AdminUser); ok && user.Can(perm) {
+			return c.Next()
+		}
+
+		c.Status(fiber.StatusForbidden)
+		c.Bind(fiber.Map{
+			"Title":   "Forbidden",
+			"Content": "You don't have permission to open this page.",
+		})
+		return c.Render("basic", fiber.Map{})
+	}
+}
+
+func (a *App) adminUsersView(c *fiber.Ctx) error {
+	var users []AdminUser
+	if err := a.db.Order("username").Find(&users).Error; err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	return c.Render("admin-users", fiber.Map{
+		"Title":      "Admin accounts",
+		"Users":      users,
+		"Roles":      Roles,
+		"RoleLabels": RoleLabels,
+		"Error":      c.Query("error"),
+	})
+}
+
+func (a *App) createAdminUser(c *fiber.Ctx) error {
+	user, err := a.createAdminAccount(strings.TrimSpace(c.FormValue("username")), c.FormValue("password"), c.FormValue("role"))
+	if err != nil {
+		a.log.Infof("Can't create admin user: %v", err)
+		return c.Redirect("/admin/users?error=" + url.QueryEscape(err.Error()))
+	}
+	a.audit(c, "user.create", "user "+user.Username, nil, user)
+
+	return c.Redirect("/admin/users")
+}
+
+func (a *App) updateAdminUser(c *fiber.Ctx) error {
+	var user AdminUser
+	if err := a.db.First(&user, c.Params("id")).Error; err != nil {
+		return c.Redirect("/admin/users")
+	}
+
+	updates := map[string]interface{}{}
+	if role := c.FormValue("role"); validRole(role) {
+		updates["role"] = role
+	}
+	if password := c.FormValue("password"); password != "" {
+		hash, err := hashPassword(password)
+		if err != nil {
+			return c.Redirect("/admin/users?error=" + url.QueryEscape(err.Error()))
+		}
+		updates["password_hash"] = hash
+	}
+	if c.FormValue("toggle") != "" {
+		updates["disabled"] = !user.Disabled
+	}
+	if c.FormValue("reset-totp") != "" {
+		if err := a.resetTOTP(user); err != nil {
+			a.log.Error(err)
+		}
+		a.revokeSessions(user.Username)
+	}
+
+	// Someone has to be able to manage accounts.
+	if user.Username == adminActor(c) && (updates["disabled"] == true || updates["role"] != nil && updates["role"] != RoleSuperadmin) {
+		return c.Redirect("/admin/users?error=" + url.QueryEscape("You can't demote or disable yourself"))
+	}
+
+	before := user
+	if err := a.db.Model(&user).Updates(updates).Error; err != nil {
+		a.log.Error(err)
+	}
+	if updates["password_hash"] != nil || updates["disabled"] == true {
+		a.revokeSessions(user.Username)
+		a.forgetDevices(user)
+	}
+
+	var updated AdminUser
+	if err := a.db.First(&updated, user.ID).Error; err != nil {
+		a.log.Error(err)
+	}
+	a.audit(c, "user.update", "user "+user.Username, before, updated)
+
+	return c.Redirect("/admin/users")
+}
+
+func (a *App) createAdminAccount(username, password, role string) (AdminUser, error) {
+	user := AdminUser{Username: username, Role: role}
+	if username == "" {
+		return user, errors.New("username is required")
+	}
+	if !validRole(role) {
+		return user, fmt.Errorf("role must be one of: %s", strings.Join(Roles, ", "))
+	}
+
+	var err error
+	if user.PasswordHash, err = hashPassword(password); err != nil {
+		return user, err
+	}
+
+	return user, a.db.Create(&user).Error
+}
+
+func (a *App) adminAccount(username string) (AdminUser, error) {
+	var user AdminUser
+	err := a.db.Where("username = ?", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, fmt.Errorf("%w: %s", ErrAdminUserNotFound, username)
+	}
+	return user, err
+}
+
+// readPassword reads the password from the first line of stdin, so it can be
+// piped from a secret store.
+func readPassword(cmd *cobra.Command) (string, error) {
+	fmt.Fprint(cmd.ErrOrStderr(), "Password: ")
+
+	line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("can't read password: %w", err)
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// adminCommand manages admin accounts without the web panel.
+func adminCommand(config *Config, logger *Logger) *cobra.Command {
+	app := new(App)
+
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Manage admin accounts",
+		// Errors are about accounts, not about the usage.
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := logger.Init(); err != nil {
+				return err
+			}
+
+			db, err := openDatabase(config, logger)
+			if err != nil {
+				return err
+			}
+
+			app.db = db
+			app.log = logger
+			app.config = config
+
+			return nil
+		},
+	}
+
+	var role string
+	create := &cobra.Command{
+		Use:   "create <username>",
+		Short: "Create an admin account, the password is read from stdin",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			password, err := readPassword(cmd)
+			if err != nil {
+				return err
+			}
+
+			user, err := app.createAdminAccount(args[0], password, role)
+			if err != nil {
+				return fmt.Errorf("can't create admin %s: %w", args[0], err)
+			}
+
+			app.auditAs(cliActor, "", "user.create", "user "+user.Username, nil, user)
+			fmt.Fprintf(cmd.OutOrStdout(), "Created %s with role %s\n", user.Username, user.Role)
+			return nil
+		},
+	}
+	create.Flags().StringVar(&role, "role", RoleOrganizer, strings.Join(Roles, "|"))
+
+	passwd := &cobra.Command{
+		Use:   "passwd <username>",
+		Short: "Change the password of an admin account, the password is read from stdin",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			user, err := app.adminAccount(args[0])
+			if err != nil {
+				return err
+			}
+
+			password, err := readPassword(cmd)
+			if err != nil {
+				return err
+			}
+
+			hash, err := hashPassword(password)
+			if err != nil {
+				return err
+			}
+
+			if err := app.db.Model(&user).Update("password_hash", hash).Error; err != nil {
+				return err
+			}
+			app.revokeSessions(user.Username)
+			app.forgetDevices(user)
+			app.auditAs(cliActor, "", "user.update", "user "+user.Username, map[string]string{"PasswordHash": user.PasswordHash}, map[string]string{"PasswordHash": hash})
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Changed password of %s\n", user.Username)
+			return nil
+		},
+	}
+
+	var undo bool
+	disable := &cobra.Command{
+		Use:   "disable <username>",
+		Short: "Disable an admin account",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			user, err := app.adminAccount(args[0])
+			if err != nil {
+				return err
+			}
+
+			if err := app.db.Model(&user).Update("disabled", !undo).Error; err != nil {
+				return err
+			}
+			if !undo {
+				app.revokeSessions(user.Username)
+			}
+			app.auditAs(cliActor, "", "user.update", "user "+user.Username, map[string]bool{"Disabled": user.Disabled}, map[string]bool{"Disabled": !undo})
+
+			state := "Disabled"
+			if undo {
+				state = "Enabled"
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s %s\n", state, user.Username)
+			return nil
+		},
+	}
+	disable.Flags().BoolVar(&undo, "undo", false, "enable the account again")
+
+	resetTOTP := &cobra.Command{
+		Use:   "reset-totp <username>",
+		Short: "Turn off two-factor authentication of an admin account, for a lost phone",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			user, err := app.adminAccount(args[0])
+			if err != nil {
+				return err
+			}
+
+			if err := app.resetTOTP(user); err != nil {
+				return err
+			}
+			app.revokeSessions(user.Username)
+			app.auditAs(cliActor, "", "totp.disable", "user "+user.Username, nil, nil)
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Reset two-factor authentication of %s\n", user.Username)
+			return nil
+		},
+	}
+
+	cmd.AddCommand(create, passwd, disable, resetTOTP)
+
+	return cmd
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Code is an opaque identifier of the paper shown to reviewers. It is the
+// same for all reviewers of the paper and doesn't reveal the author.
+func (k paperKey) Code() string {
+	sum := sha256.Sum256([]byte(k.String()))
+	return "P-" + strings.ToUpper(hex.EncodeToString(sum[:4]))
+}
+
+func (a Assignment) Code() string {
+	return paperKey{a.ParticipantToken, a.Type}.Code()
+}
+
+// BlindFileName is the name a submission is served under to reviewers.
+func (a Assignment) BlindFileName(submission Submission) string {
+	return fmt.Sprintf("%s_%s_v%d%s", a.Code(), a.Type, submission.Version, strings.ToLower(path.Ext(submission.Path)))
+}
+
+var (
+	docxPersonRe    = regexp.MustCompile(`(?s)<(dc:creator|cp:lastModifiedBy|Company|Manager)>.*?</(?:dc:creator|cp:lastModifiedBy|Company|Manager)>`)
+	docxAuthorRe    = regexp.MustCompile(`\bw:(author|initials)="[^"]*"`)
+	pdfAuthorRe     = regexp.MustCompile(`/Author\s*(\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>)`)
+	pdfXMPCreatorRe = regexp.MustCompile(`(?s)<(dc:creator|pdf:Author|xmp:Author)>(.*?)</(?:dc:creator|pdf:Author|xmp:Author)>`)
+)
+
+// anonymizeDocument removes author names from document properties of docx and
+// pdf files. Other formats are returned as is.
+func anonymizeDocument(content []byte, ext string) ([]byte, error) {
+	switch strings.ToLower(ext) {
+	case "docx":
+		return anonymizeDocx(content)
+	case "pdf":
+		return anonymizePDF(content)
+	default:
+		return content, nil
+	}
+}
+
+// anonymizeDocx clears the creator, the last editor and the company in the
+// document properties, and authors of comments and tracked changes.
+func anonymizeDocx(content []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("not a docx file: %w", err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	budget := newInflateBudget()
+
+	for _, f := range zr.File {
+		data, err := budget.readZipFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case f.Name == "docProps/core.xml" || f.Name == "docProps/app.xml":
+			data = docxPersonRe.ReplaceAll(data, []byte("<$1></$1>"))
+		case strings.HasPrefix(f.Name, "word/") && strings.HasSuffix(f.Name, ".xml"):
+			data = docxAuthorRe.ReplaceAll(data, []byte(`w:$1=""`))
+		}
+
+		header := f.FileHeader
+		w, err := zw.CreateHeader(&header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// anonymizePDF blanks the author in the document information dictionary and
+// in uncompressed XMP metadata. Values are overwritten with spaces of the same
+// length so the cross-reference table stays valid. Authors in compressed
+// object streams or metadata can't be blanked in place, such files fail.
+func anonymizePDF(content []byte) ([]byte, error) {
+	budget := newInflateBudget()
+	for _, m := range pdfStreamRe.FindAllSubmatch(content, -1) {
+		r, err := zlib.NewReader(bytes.NewReader(m[1]))
+		if err != nil {
+			continue
+		}
+		inflated, err := budget.read(r)
+		if errors.Is(err, ErrDocumentTooLarge) {
+			return nil, err
+		}
+		if pdfAuthorRe.Match(inflated) || pdfXMPCreatorRe.Match(inflated) {
+			return nil, errors.New("the author is in a compressed stream")
+		}
+	}
+
+	out := make([]byte, len(content))
+	copy(out, content)
+
+	blank := func(b []byte) {
+		for i := range b {
+			b[i] = ' '
+		}
+	}
+
+	for _, m := range pdfAuthorRe.FindAllSubmatchIndex(out, -1) {
+		// Keep the delimiters of the string.
+		blank(out[m[2]+1 : m[3]-1])
+	}
+
+	for _, m := range pdfXMPCreatorRe.FindAllSubmatchIndex(out, -1) {
+		blank(out[m[4]:m[5]])
+	}
+
+	return out, nil
+}
@@ -0,0 +1,21 @@
+// Rows of admin tables with data-href open the page on click.
+document.addEventListener('DOMContentLoaded', function () {
+    document.querySelectorAll('tr[data-href]').forEach(function (row) {
+        row.addEventListener('click', function (event) {
+            // Links and controls in the row work as usual.
+            if (event.target.closest('a, button, input, select, label')) {
+                return;
+            }
+            window.location = row.dataset.href;
+        });
+    });
+
+    // Forms with data-confirm ask before submitting.
+    document.querySelectorAll('form[data-confirm]').forEach(function (form) {
+        form.addEventListener('submit', function (event) {
+            if (!window.confirm(form.dataset.confirm)) {
+                event.preventDefault();
+            }
+        });
+    });
+});
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PresentationSections are the sessions of the conference as offered on the
+// registration form.
+var PresentationSections = []string{
+	"Plenary session",
+	"1. Education and professional training for the Arctic shipping industry",
+	"2. Arctic shipping: safety, environment, legal regulation",
+	"3. Innovative technologies for polar shipping: research & development",
+	"4. Development of Sea Ports in the Arctic",
+}
+
+func (r Reviewer) SectionList() []string {
+	list := make([]string, 0)
+	for _, s := range strings.Split(r.Sections, "\n") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func (r Reviewer) HasSection(section string) bool {
+	for _, s := range r.SectionList() {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+func normalize(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// conflictOfInterest returns why the reviewer can't review the paper of the
+// participant or "" when there is no conflict. Co-authors are participants
+// registered with the same presentation title.
+func conflictOfInterest(r Reviewer, p Participant, coAuthors []Participant) string {
+	email := normalize(r.Email)
+
+	if email == normalize(p.Email) {
+		return "author"
+	}
+
+	for _, coAuthor := range coAuthors {
+		if email == normalize(coAuthor.Email) {
+			return "co-author"
+		}
+	}
+
+	if org := normalize(r.Organization); org != "" && org == normalize(p.Organization) {
+		return "same organization"
+	}
+
+	for _, conflict := range splitList(r.Conflicts) {
+		switch normalize(conflict) {
+		case normalize(p.Email), normalize(p.Name + " " + p.Surname), normalize(p.Surname + " " + p.Name), normalize(p.Organization):
+			return "declared conflict"
+		}
+	}
+
+	return ""
+}
+
+// expertise scores how well the reviewer matches the paper: a matching
+// section outweighs keywords found in the title.
+func expertise(r Reviewer, p Participant, title string) int {
+	score := 0
+	if p.PresentationSection != "" && r.HasSection(p.PresentationSection) {
+		score += 3
+	}
+
+	title = normalize(p.PresentationTitle + " " + title)
+	for _, keyword := range splitList(r.Keywords) {
+		if strings.Contains(title, normalize(keyword)) {
+			score++
+		}
+	}
+
+	return score
+}
+
+// coAuthorIndex groups participants by presentation title.
+func coAuthorIndex(participants []Participant) map[string][]Participant {
+	index := make(map[string][]Participant)
+	for _, p := range participants {
+		if title := normalize(p.PresentationTitle); title != "" {
+			index[title] = append(index[title], p)
+		}
+	}
+	return index
+}
+
+// planAssignments proposes reviewers for papers with less than perPaper
+// assignments. Papers with the fewest eligible reviewers are filled first,
+// reviewers below the average load are preferred and among them the best
+// matching ones.
+func planAssignments(papers []paperReviews, reviewers []Reviewer, participants []Participant, perPaper int) []Assignment {
+	if len(reviewers) == 0 {
+		return nil
+	}
+
+	coAuthors := coAuthorIndex(participants)
+
+	load := make(map[uint]int)
+	needed := 0
+	type candidates struct {
+		paper     paperReviews
+		reviewers []Reviewer
+	}
+	queue := make([]candidates, 0, len(papers))
+
+	for _, paper := range papers {
+		assigned := make(map[uint]bool)
+		for _, assignment := range paper.Assignments {
+			assigned[assignment.ReviewerID] = true
+			load[assignment.ReviewerID]++
+		}
+
+		if len(paper.Assignments) >= perPaper {
+			continue
+		}
+		needed += perPaper - len(paper.Assignments)
+
+		eligible := make([]Reviewer, 0)
+		for _, r := range reviewers {
+			if !assigned[r.ID] && conflictOfInterest(r, paper.Participant, coAuthors[normalize(paper.Participant.PresentationTitle)]) == "" {
+				eligible = append(eligible, r)
+			}
+		}
+		queue = append(queue, candidates{paper, eligible})
+	}
+
+	total := needed
+	for _, n := range load {
+		total += n
+	}
+	capacity := (total + len(reviewers) - 1) / len(reviewers)
+
+	sort.SliceStable(queue, func(i, j int) bool { return len(queue[i].reviewers) < len(queue[j].reviewers) })
+
+	drafts := make([]Assignment, 0, needed)
+	for _, q := range queue {
+		scores := make(map[uint]int)
+		for _, r := range q.reviewers {
+			scores[r.ID] = expertise(r, q.paper.Participant, q.paper.Latest.Title)
+		}
+
+		sort.SliceStable(q.reviewers, func(i, j int) bool {
+			ri, rj := q.reviewers[i].ID, q.reviewers[j].ID
+			if full := load[ri] >= capacity; full != (load[rj] >= capacity) {
+				return !full
+			}
+			if scores[ri] != scores[rj] {
+				return scores[ri] > scores[rj]
+			}
+			if load[ri] != load[rj] {
+				return load[ri] < load[rj]
+			}
+			return ri < rj
+		})
+
+		for i := 0; i < len(q.reviewers) && i < perPaper-len(q.paper.Assignments); i++ {
+			r := q.reviewers[i]
+			load[r.ID]++
+			drafts = append(drafts, Assignment{
+				ReviewerID:       r.ID,
+				ParticipantToken: q.paper.Key.Token,
+				Type:             q.paper.Key.Type,
+				Draft:            true,
+			})
+		}
+	}
+
+	return drafts
+}
+
+func (a *App) activeReviewers() ([]Reviewer, error) {
+	var reviewers []Reviewer
+	err := a.db.Where("disabled = ?", false).Order("id").Find(&reviewers).Error
+	return reviewers, err
+}
+
+// markConflicts fills conflicts of every paper with every reviewer.
+func markConflicts(papers []paperReviews, reviewers []Reviewer, participants []Participant) {
+	coAuthors := coAuthorIndex(participants)
+	for i := range papers {
+		papers[i].Conflicts = make(map[uint]string)
+		for _, r := range reviewers {
+			reason := conflictOfInterest(r, papers[i].Participant, coAuthors[normalize(papers[i].Participant.PresentationTitle)])
+			if reason != "" {
+				papers[i].Conflicts[r.ID] = reason
+			}
+		}
+	}
+}
+
+func (a *App) autoAssign(c *fiber.Ctx) error {
+	if err := a.db.Where("draft = ?", true).Delete(&Assignment{}).Error; err != nil {
+		a.log.Error(err)
+		return c.Redirect("/admin/reviews")
+	}
+
+	papers, err := a.papersWithReviews()
+	if err != nil {
+		a.log.Error(err)
+		return c.Redirect("/admin/reviews")
+	}
+
+	reviewers, err := a.activeReviewers()
+	if err != nil {
+		a.log.Error(err)
+		return c.Redirect("/admin/reviews")
+	}
+
+	var participants []Participant
+	if err := a.db.Find(&participants).Error; err != nil {
+		a.log.Error(err)
+		return c.Redirect("/admin/reviews")
+	}
+
+	drafts := planAssignments(papers, reviewers, participants, a.config.ReviewsPerPaper)
+	if len(drafts) > 0 {
+		if err := a.db.Create(&drafts).Error; err != nil {
+			a.log.Errorf("Can't assign reviewers: %v", err)
+		}
+	}
+	a.audit(c, "assignment.auto", "draft assignments", nil, map[string]int{"drafts": len(drafts)})
+
+	return c.Redirect("/admin/reviews")
+}
+
+func (a *App) commitAssignments(c *fiber.Ctx) error {
+	result := a.db.Model(&Assignment{}).Where("draft = ?", true).Update("draft", false)
+	if result.Error != nil {
+		a.log.Error(result.Error)
+	} else {
+		a.audit(c, "assignment.commit", "draft assignments", nil, map[string]int64{"committed": result.RowsAffected})
+	}
+
+	return c.Redirect("/admin/reviews")
+}
+
+func (a *App) discardAssignments(c *fiber.Ctx) error {
+	result := a.db.Where("draft = ?", true).Delete(&Assignment{})
+	if result.Error != nil {
+		a.log.Error(result.Error)
+	} else {
+		a.audit(c, "assignment.discard", "draft assignments", map[string]int64{"drafts": result.RowsAffected}, nil)
+	}
+
+	return c.Redirect("/admin/reviews")
+}
+
+func (a *App) updateReviewer(c *fiber.Ctx) error {
+	var reviewer Reviewer
+	if err := a.db.First(&reviewer, c.Params("id")).Error; err != nil {
+		return c.Redirect("/admin/reviews")
+	}
+
+	sections := make([]string, 0)
+	for _, s := range c.Request().PostArgs().PeekMulti("sections") {
+		sections = append(sections, string(s))
+	}
+
+	updated := reviewer
+	if err := a.db.Model(&updated).Updates(map[string]any{
+		"organization": strings.TrimSpace(c.FormValue("organization")),
+		"sections":     strings.Join(sections, "\n"),
+		"keywords":     strings.TrimSpace(c.FormValue("keywords")),
+		"conflicts":    strings.TrimSpace(c.FormValue("conflicts")),
+	}).Error; err != nil {
+		a.log.Error(err)
+	} else {
+		a.audit(c, "reviewer.update", "reviewer "+reviewer.Email, reviewer, updated)
+	}
+
+	return c.Redirect("/admin/reviews")
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var ErrAuditAppendOnly = errors.New("audit log entries can't be changed or deleted")
+
+const auditPageSize = 50
+
+// auditRedacted replaces values of secret and personal fields in changes.
+const auditRedacted = "[redacted]"
+
+// auditSecretFields are parts of field names whose values are never written
+// to the audit log, only the fact they changed.
+var auditSecretFields = []string{"password", "secret", "token", "hash", "verifier"}
+
+// auditSkippedFields change on every save and say nothing.
+var auditSkippedFields = []string{"UpdatedAt", "LastSeenAt"}
+
+// auditPersonal is a record with personal data, the audit log keeps only
+// which of its personal fields changed.
+type auditPersonal interface {
+	auditPersonalFields() []string
+}
+
+func auditPersonalFieldsOf(values ...interface{}) []string {
+	var fields []string
+	for _, v := range values {
+		if p, ok := v.(auditPersonal); ok {
+			fields = append(fields, p.auditPersonalFields()...)
+		}
+	}
+	return fields
+}
+
+func (AuditEvent) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+func (AuditEvent) BeforeDelete(*gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+// auditChange is a changed field, From is nil for created records and To
+// for deleted ones.
+type auditChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+func (c auditChange) FromText() string {
+	return auditValueText(c.From)
+}
+
+func (c auditChange) ToText() string {
+	return auditValueText(c.To)
+}
+
+func auditValueText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "–"
+	case string:
+		return strconv.Quote(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// auditFields flattens a struct or a map to its JSON fields.
+func auditFields(v interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if v == nil {
+		return fields
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(b, &fields)
+	return fields
+}
+
+func auditSecret(field string) bool {
+	field = strings.ToLower(field)
+	for _, s := range auditSecretFields {
+		if strings.Contains(field, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffFields lists fields which differ between two versions of a record.
+func diffFields(before, after interface{}) []auditChange {
+	from, to := auditFields(before), auditFields(after)
+
+	names := make([]string, 0, len(from)+len(to))
+	for name := range from {
+		names = append(names, name)
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []auditChange
+	for _, name := range names {
+		if contains(auditSkippedFields, name) || reflect.DeepEqual(from[name], to[name]) {
+			continue
+		}
+		changes = append(changes, auditChange{Field: name, From: from[name], To: to[name]})
+	}
+
+	return changes
+}
+
+// auditDiff is diffFields with secrets and personal data left out.
+func auditDiff(before, after interface{}) []auditChange {
+	changes := diffFields(before, after)
+	personal := auditPersonalFieldsOf(before, after)
+
+	for i, change := range changes {
+		if auditSecret(change.Field) || contains(personal, change.Field) {
+			if change.From != nil {
+				changes[i].From = auditRedacted
+			}
+			if change.To != nil {
+				changes[i].To = auditRedacted
+			}
+		}
+	}
+
+	return changes
+}
+
+func (e AuditEvent) ChangeList() []auditChange {
+	var changes []auditChange
+	if e.Changes != "" {
+		json.Unmarshal([]byte(e.Changes), &changes)
+	}
+	return changes
+}
+
+// audit records an action of the signed in admin. Before and after are the
+// record before and after the change, nil when it's created or deleted.
+func (a *App) audit(c *fiber.Ctx, action, target string, before, after interface{}) {
+	a.auditAs(adminActor(c), c.IP(), action, target, before, after)
+}
+
+func (a *App) auditAs(actor, ip, action, target string, before, after interface{}) {
+	event := AuditEvent{Actor: actor, IP: ip, Action: action, Target: target}
+
+	if changes := auditDiff(before, after); len(changes) > 0 {
+		b, err := json.Marshal(changes)
+		if err != nil {
+			a.log.Error(err)
+		}
+		event.Changes = string(b)
+	}
+
+	if err := a.db.Create(&event).Error; err != nil {
+		a.log.Errorf("Can't write audit log of %s %s by %s: %v", action, target, actor, err)
+	}
+}
+
+// AuditFilter narrows the audit log down, Since and Until are dates and
+// both days are included.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  string
+	Until  string
+	Page   int
+}
+
+const auditDateLayout = "2006-01-02"
+
+func (f AuditFilter) query(db *gorm.DB) (*gorm.DB, error) {
+	query := db.Model(&AuditEvent{})
+
+	if f.Actor != "" {
+		query = query.Where("actor = ?", f.Actor)
+	}
+	// "login" matches "login" and "login.failed".
+	if f.Action != "" {
+		query = query.Where("(action = ? OR action LIKE ? ESCAPE '!')", f.Action, escapeLike(f.Action)+".%")
+	}
+	if f.Target != "" {
+		query = query.Where("LOWER(target) LIKE ? ESCAPE '!'", "%"+escapeLike(strings.ToLower(f.Target))+"%")
+	}
+	if f.Since != "" {
+		since, err := time.ParseInLocation(auditDateLayout, f.Since, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("since must be a date like %s", auditDateLayout)
+		}
+		query = query.Where("created_at >= ?", since)
+	}
+	if f.Until != "" {
+		until, err := time.ParseInLocation(auditDateLayout, f.Until, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("until must be a date like %s", auditDateLayout)
+		}
+		query = query.Where("created_at < ?", until.AddDate(0, 0, 1))
+	}
+
+	return query, nil
+}
+
+func (f AuditFilter) PageURL(page int) string {
+	v := url.Values{}
+	for name, value := range map[string]string{"actor": f.Actor, "action": f.Action, "target": f.Target, "since": f.Since, "until": f.Until} {
+		if value != "" {
+			v.Set(name, value)
+		}
+	}
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	if len(v) > 0 {
+		return "/admin/audit?" + v.Encode()
+	}
+	return "/admin/audit"
+}
+
+func (a *App) auditView(c *fiber.Ctx) error {
+	f := AuditFilter{
+		Actor:  c.Query("actor"),
+		Action: c.Query("action"),
+		Target: strings.TrimSpace(c.Query("target")),
+		Since:  c.Query("since"),
+		Until:  c.Query("until"),
+	}
+	f.Page, _ = strconv.Atoi(c.Query("page"))
+	if f.Page < 1 {
+		f.Page = 1
+	}
+
+	data := fiber.Map{"Title": "Audit log", "Filter": f}
+
+	var actors, actions []string
+	if err := a.db.Model(&AuditEvent{}).Distinct().Order("actor").Pluck("actor", &actors).Error; err != nil {
+		a.log.Error(err)
+		return err
+	}
+	if err := a.db.Model(&AuditEvent{}).Distinct().Order("action").Pluck("action", &actions).Error; err != nil {
+		a.log.Error(err)
+		return err
+	}
+	data["Actors"] = actors
+	data["Actions"] = auditActionGroups(actions)
+
+	count, err := f.query(a.db)
+	if err != nil {
+		data["Error"] = err.Error()
+		return c.Render("admin-audit", data)
+	}
+
+	var total int64
+	if err := count.Count(&total).Error; err != nil {
+		a.log.Error(err)
+		return err
+	}
+	pages := paginate(f.Page, auditPageSize, total)
+
+	query, _ := f.query(a.db)
+	var events []AuditEvent
+	if err := query.Order("id DESC").Offset((pages.Page - 1) * auditPageSize).Limit(auditPageSize).Find(&events).Error; err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	data["Events"] = events
+	data["Pagination"] = pages
+
+	return c.Render("admin-audit", data)
+}
+
+// auditActionGroups adds objects of actions, "participant" for
+// "participant.update", to filter by all actions on them.
+func auditActionGroups(actions []string) []string {
+	groups := append([]string{}, actions...)
+	for _, action := range actions {
+		if object, _, ok := strings.Cut(action, "."); ok && !contains(groups, object) {
+			groups = append(groups, object)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// exportAudit writes the filtered audit log oldest first.
+func (a *App) exportAudit(w io.Writer, f AuditFilter, format string) error {
+	query, err := f.query(a.db)
+	if err != nil {
+		return err
+	}
+
+	var (
+		write  func(e AuditEvent) error
+		finish func() error
+	)
+
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"time", "actor", "ip", "action", "target", "changes"}); err != nil {
+			return err
+		}
+		write = func(e AuditEvent) error {
+			return cw.Write([]string{e.CreatedAt.Format(time.RFC3339), e.Actor, e.IP, e.Action, e.Target, e.Changes})
+		}
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case "json":
+		// One object per line, so large logs stream and grep.
+		enc := json.NewEncoder(w)
+		write = func(e AuditEvent) error {
+			return enc.Encode(struct {
+				Time    time.Time     `json:"time"`
+				Actor   string        `json:"actor"`
+				IP      string        `json:"ip"`
+				Action  string        `json:"action"`
+				Target  string        `json:"target"`
+				Changes []auditChange `json:"changes,omitempty"`
+			}{e.CreatedAt, e.Actor, e.IP, e.Action, e.Target, e.ChangeList()})
+		}
+		finish = func() error { return nil }
+	default:
+		return fmt.Errorf("unknown format %q, use csv or json", format)
+	}
+
+	var batch []AuditEvent
+	err = query.Order("id").FindInBatches(&batch, 500, func(tx *gorm.DB, n int) error {
+		for _, e := range batch {
+			if err := write(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	return finish()
+}
+
+// auditCommand exports the audit log without the web panel.
+func auditCommand(config *Config, logger *Logger) *cobra.Command {
+	app := new(App)
+
+	cmd := &cobra.Command{
+		Use:          "audit",
+		Short:        "Read the audit log of the admin panel",
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := logger.Init(); err != nil {
+				return err
+			}
+
+			db, err := openDatabase(config, logger)
+			if err != nil {
+				return err
+			}
+
+			app.db = db
+			app.log = logger
+			app.config = config
+
+			return nil
+		},
+	}
+
+	var (
+		f      AuditFilter
+		format string
+		output string
+	)
+	export := &cobra.Command{
+		Use:   "export",
+		Short: "Export the audit log as CSV or JSON lines, oldest first",
+		Args:  cobra.NoArgs,
+		// Errors are about the log, not about the usage.
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output == "" || output == "-" {
+				return app.exportAudit(cmd.OutOrStdout(), f, format)
+			}
+
+			file, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			if err := app.exportAudit(file, f, format); err != nil {
+				file.Close()
+				return err
+			}
+			return file.Close()
+		},
+	}
+	export.Flags().StringVar(&format, "format", "csv", "csv|json")
+	export.Flags().StringVarP(&output, "output", "o", "", "file to write, stdout by default")
+	export.Flags().StringVar(&f.Since, "since", "", "first day, "+auditDateLayout)
+	export.Flags().StringVar(&f.Until, "until", "", "last day, "+auditDateLayout)
+	export.Flags().StringVar(&f.Actor, "actor", "", "only actions of the admin")
+	export.Flags().StringVar(&f.Action, "action", "", `only the action, or all actions on an object like "participant"`)
+	export.Flags().StringVar(&f.Target, "target", "", "only targets containing the text")
+
+	cmd.AddCommand(export)
+
+	return cmd
+}
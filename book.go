@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jung-kurt/gofpdf"
+)
+
+const (
+	BookConference = "International Conference «Arctic: Marine Transportation Challenges – 2022»"
+	BookTitle      = "Book of abstracts"
+	BookPlace      = "Saint Petersburg, November 24-25, 2022"
+
+	BookPDFPath  = "book/book-of-abstracts.pdf"
+	BookHTMLPath = "book/book-of-abstracts.html"
+
+	// bookAbstractWords limits abstracts taken from full papers.
+	bookAbstractWords = 300
+	// bookUntitled stands for papers without a title in the registration
+	// or the document.
+	bookUntitled = "Untitled paper"
+)
+
+type bookEntry struct {
+	Title         string
+	Authors       []Participant
+	Organizations []string
+	Abstract      string
+	Page          int
+}
+
+func (e *bookEntry) Anchor() string {
+	return fmt.Sprintf("p%d", e.Page)
+}
+
+func (e *bookEntry) AuthorNames() string {
+	names := make([]string, 0, len(e.Authors))
+	for _, author := range e.Authors {
+		names = append(names, strings.TrimSpace(author.Name+" "+author.Surname))
+	}
+	return strings.Join(names, ", ")
+}
+
+type bookSession struct {
+	Name    string
+	Entries []*bookEntry
+}
+
+type bookAuthor struct {
+	Name    string
+	Entries []*bookEntry
+}
+
+type book struct {
+	Sessions []bookSession
+	Authors  []bookAuthor
+}
+
+// sessionIndex orders presentation sections as ConferenceSessions. The
+// registration form lists sections without the «Session» prefix.
+func sessionIndex(sessions []string, section string) int {
+	for i, s := range sessions {
+		if s == section || strings.TrimPrefix(s, "Session ") == section {
+			return i
+		}
+	}
+	return len(sessions)
+}
+
+var abstractRe = regexp.MustCompile(`(?is)\babstract\b[\s.:—-]*(.*?)(?:\n\s*(?:keywords|key words|introduction|1\.?\s+introduction)\b|$)`)
+
+// abstractOf returns the abstract of a document: abstracts as a whole and the
+// «Abstract» section or the beginning of full papers.
+func abstractOf(text, t string) string {
+	text = strings.TrimSpace(text)
+	if t == "tezis" {
+		return text
+	}
+
+	if m := abstractRe.FindStringSubmatch(text); m != nil && strings.TrimSpace(m[1]) != "" {
+		text = strings.TrimSpace(m[1])
+	}
+
+	words := strings.Fields(text)
+	if len(words) > bookAbstractWords {
+		return strings.Join(words[:bookAbstractWords], " ") + " …"
+	}
+	return text
+}
+
+// documentText extracts text of a stored submission.
+func (a *App) documentText(submission Submission) (string, error) {
+	file, err := a.disk.Open(submission.Path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := extractDocumentInfo(content, strings.TrimPrefix(path.Ext(submission.Path), "."))
+	if err != nil {
+		return "", err
+	}
+
+	return info.Text, nil
+}
+
+// buildBook collects accepted papers grouped by session. A paper accepted both
+// as an abstract and as a full paper is included once, so is a paper its
+// co-authors registered with the same title.
+func (a *App) buildBook() (*book, error) {
+	statuses, err := a.paperStatuses("")
+	if err != nil {
+		return nil, err
+	}
+
+	var participants []Participant
+	if err := a.db.Find(&participants).Error; err != nil {
+		return nil, err
+	}
+	byToken := make(map[string]Participant)
+	for _, p := range participants {
+		byToken[p.Token] = p
+	}
+	coAuthors := coAuthorIndex(participants)
+
+	keys := make([]paperKey, 0)
+	for key, status := range statuses {
+		if status.Status == StatusAccepted {
+			keys = append(keys, key)
+		}
+	}
+	// Abstracts go before full papers so they win over duplicates.
+	sort.Slice(keys, func(i, j int) bool {
+		if (keys[i].Type == "tezis") != (keys[j].Type == "tezis") {
+			return keys[i].Type == "tezis"
+		}
+		return keys[i].String() < keys[j].String()
+	})
+
+	sessions := RegistrationPageContent["ConferenceSessions"].([]string)
+	grouped := make([][]*bookEntry, len(sessions)+1)
+	seen := make(map[string]bool)
+
+	for _, key := range keys {
+		participant := byToken[key.Token]
+
+		// The camera-ready version is preferred to the reviewed one.
+		submission, err := a.cameraReadyFinal(key)
+		if err != nil {
+			submission, err = a.latestSubmission(key.Token, key.Type)
+		}
+		if err != nil {
+			a.log.Errorf("Accepted paper %s has no file: %v", key, err)
+			continue
+		}
+
+		title := strings.TrimSpace(participant.PresentationTitle)
+		if title == "" {
+			title = submission.Title
+		}
+		titleKey := "title " + normalize(title)
+		if seen[key.Token] || title != "" && seen[titleKey] {
+			continue
+		}
+		seen[key.Token] = true
+		if title != "" {
+			seen[titleKey] = true
+		} else {
+			title = bookUntitled
+		}
+
+		text, err := a.documentText(submission)
+		if err != nil {
+			a.log.Errorf("Can't read text of submission %d: %v", submission.ID, err)
+		}
+
+		entry := &bookEntry{
+			Title:    title,
+			Authors:  []Participant{participant},
+			Abstract: abstractOf(text, key.Type),
+		}
+		for _, coAuthor := range coAuthors[normalize(participant.PresentationTitle)] {
+			if coAuthor.Token != participant.Token {
+				entry.Authors = append(entry.Authors, coAuthor)
+			}
+		}
+		for _, author := range entry.Authors {
+			if org := strings.TrimSpace(author.Organization); org != "" && !contains(entry.Organizations, org) {
+				entry.Organizations = append(entry.Organizations, org)
+			}
+		}
+
+		i := sessionIndex(sessions, participant.PresentationSection)
+		grouped[i] = append(grouped[i], entry)
+	}
+
+	b := &book{}
+	for i, entries := range grouped {
+		if len(entries) == 0 {
+			continue
+		}
+		name := "Other"
+		if i < len(sessions) {
+			name = sessions[i]
+		}
+		sort.SliceStable(entries, func(i, j int) bool { return entries[i].Title < entries[j].Title })
+		b.Sessions = append(b.Sessions, bookSession{name, entries})
+	}
+
+	authors := make(map[string]*bookAuthor)
+	for _, session := range b.Sessions {
+		for _, entry := range session.Entries {
+			for _, p := range entry.Authors {
+				name := strings.TrimSpace(p.Surname + ", " + p.Name)
+				if authors[name] == nil {
+					authors[name] = &bookAuthor{Name: name}
+				}
+				authors[name].Entries = append(authors[name].Entries, entry)
+			}
+		}
+	}
+	for _, author := range authors {
+		b.Authors = append(b.Authors, *author)
+	}
+	sort.Slice(b.Authors, func(i, j int) bool { return strings.ToLower(b.Authors[i].Name) < strings.ToLower(b.Authors[j].Name) })
+
+	return b, nil
+}
+
+func (a *App) cameraReadyFinal(key paperKey) (Submission, error) {
+	var submission Submission
+	err := a.db.Where("token = ? AND type = ? AND artifact = ?", key.Token, key.Type, "final").Order("version desc").First(&submission).Error
+	return submission, err
+}
+
+const (
+	bookMargin     = 20.0
+	bookLineHeight = 5.5
+	bookTOCLines   = 44
+)
+
+// renderBookPDF lays the book out twice: page numbers of the contents and the
+// author index are known after the first pass. The contents take the same
+// number of pages in both passes.
+func renderBookPDF(b *book, fontPath string) ([]byte, error) {
+	var font []byte
+	if fontPath != "" {
+		var err error
+		if font, err = os.ReadFile(fontPath); err != nil {
+			return nil, fmt.Errorf("can't load font: %w", err)
+		}
+	}
+
+	if _, err := layoutBookPDF(b, font); err != nil {
+		return nil, err
+	}
+
+	pdf, err := layoutBookPDF(b, font)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = pdf.Output(&buf)
+	return buf.Bytes(), err
+}
+
+func layoutBookPDF(b *book, font []byte) (*gofpdf.Fpdf, error) {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(bookMargin, bookMargin, bookMargin)
+	pdf.SetAutoPageBreak(true, bookMargin)
+	pdf.SetTitle(BookTitle, true)
+
+	family := "Helvetica"
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	if font != nil {
+		// A TrueType font covers Cyrillic and other non-Latin names.
+		family = "book"
+		pdf.AddUTF8FontFromBytes(family, "", font)
+		pdf.AddUTF8FontFromBytes(family, "B", font)
+		pdf.AddUTF8FontFromBytes(family, "I", font)
+		tr = func(s string) string { return s }
+	}
+	if err := pdf.Error(); err != nil {
+		return nil, fmt.Errorf("can't load font: %w", err)
+	}
+
+	pdf.SetFooterFunc(func() {
+		if pdf.PageNo() == 1 {
+			return
+		}
+		pdf.SetY(-15)
+		pdf.SetFont(family, "", 9)
+		pdf.CellFormat(0, 10, fmt.Sprint(pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+
+	width, _ := pdf.GetPageSize()
+	width -= 2 * bookMargin
+
+	// Title page.
+	pdf.AddPage()
+	pdf.SetY(90)
+	pdf.SetFont(family, "", 14)
+	pdf.MultiCell(0, 8, tr(BookConference), "", "C", false)
+	pdf.Ln(12)
+	pdf.SetFont(family, "B", 24)
+	pdf.MultiCell(0, 12, tr(BookTitle), "", "C", false)
+	pdf.Ln(12)
+	pdf.SetFont(family, "", 12)
+	pdf.MultiCell(0, 8, tr(BookPlace), "", "C", false)
+
+	// Contents, paginated by hand so it takes a known number of pages.
+	links := make(map[*bookEntry]int)
+	line := bookTOCLines
+	tocLine := func() {
+		if line == bookTOCLines {
+			pdf.AddPage()
+			line = 0
+		}
+		line++
+	}
+
+	tocLine()
+	pdf.SetFont(family, "B", 16)
+	pdf.CellFormat(0, bookLineHeight*2, tr("Contents"), "", 1, "L", false, 0, "")
+	line++
+
+	for _, session := range b.Sessions {
+		tocLine()
+		pdf.SetFont(family, "B", 11)
+		pdf.CellFormat(0, bookLineHeight, fitText(pdf, tr(session.Name), width), "", 1, "L", false, 0, "")
+
+		pdf.SetFont(family, "", 10)
+		for _, entry := range session.Entries {
+			tocLine()
+			link := pdf.AddLink()
+			links[entry] = link
+			page := fmt.Sprint(entry.Page)
+			pageWidth := pdf.GetStringWidth(page) + 2
+			pdf.CellFormat(width-pageWidth, bookLineHeight, fitText(pdf, tr(entry.Title), width-pageWidth-4), "", 0, "L", false, link, "")
+			pdf.CellFormat(pageWidth, bookLineHeight, page, "", 1, "R", false, link, "")
+		}
+	}
+
+	// Abstracts.
+	for _, session := range b.Sessions {
+		for i, entry := range session.Entries {
+			pdf.AddPage()
+			entry.Page = pdf.PageNo()
+			pdf.SetLink(links[entry], 0, -1)
+
+			if i == 0 {
+				pdf.SetFont(family, "B", 12)
+				pdf.MultiCell(0, 7, tr(session.Name), "B", "L", false)
+				pdf.Ln(6)
+			}
+
+			pdf.SetFont(family, "B", 14)
+			pdf.MultiCell(0, 7, tr(entry.Title), "", "C", false)
+			pdf.Ln(2)
+			pdf.SetFont(family, "I", 11)
+			pdf.MultiCell(0, bookLineHeight, tr(entry.AuthorNames()), "", "C", false)
+			if len(entry.Organizations) > 0 {
+				pdf.SetFont(family, "", 10)
+				pdf.MultiCell(0, bookLineHeight, tr(strings.Join(entry.Organizations, "; ")), "", "C", false)
+			}
+			pdf.Ln(6)
+			pdf.SetFont(family, "", 11)
+			for _, paragraph := range strings.Split(entry.Abstract, "\n") {
+				if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+					pdf.MultiCell(0, bookLineHeight, tr(paragraph), "", "J", false)
+					pdf.Ln(1)
+				}
+			}
+		}
+	}
+
+	// Author index.
+	pdf.AddPage()
+	pdf.SetFont(family, "B", 16)
+	pdf.CellFormat(0, bookLineHeight*2, tr("Author index"), "", 1, "L", false, 0, "")
+	pdf.SetFont(family, "", 10)
+	for _, author := range b.Authors {
+		pages := make([]string, 0, len(author.Entries))
+		for _, entry := range author.Entries {
+			pages = append(pages, fmt.Sprint(entry.Page))
+		}
+		list := strings.Join(pages, ", ")
+		listWidth := math.Ceil(pdf.GetStringWidth(list)) + 2
+		pdf.CellFormat(width-listWidth, bookLineHeight, fitText(pdf, tr(author.Name), width-listWidth-4), "", 0, "L", false, 0, "")
+		pdf.CellFormat(listWidth, bookLineHeight, list, "", 1, "R", false, 0, "")
+	}
+
+	return pdf, pdf.Error()
+}
+
+// fitText shortens the text to the width with an ellipsis.
+func fitText(pdf *gofpdf.Fpdf, text string, width float64) string {
+	if pdf.GetStringWidth(text) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && pdf.GetStringWidth(string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimSpace(string(runes)) + "..."
+}
+
+func (a *App) renderBookHTML(b *book) ([]byte, error) {
+	var buf bytes.Buffer
+	err := a.server.Config().Views.Render(&buf, "book", fiber.Map{
+		"Conference": BookConference,
+		"Title":      BookTitle,
+		"Place":      BookPlace,
+		"Book":       b,
+	})
+	return buf.Bytes(), err
+}
+
+// buildBookOfAbstracts stores the PDF and the HTML version of the book.
+func (a *App) buildBookOfAbstracts(c *fiber.Ctx) error {
+	b, err := a.buildBook()
+	if err != nil {
+		a.log.Errorf("Can't collect the book of abstracts: %v", err)
+		return c.Redirect("/admin")
+	}
+
+	pdf, err := renderBookPDF(b, a.config.BookFont)
+	if err != nil {
+		a.log.Errorf("Can't build the book of abstracts: %v", err)
+		return c.Redirect("/admin")
+	}
+
+	// Page numbers are set by the PDF layout.
+	html, err := a.renderBookHTML(b)
+	if err != nil {
+		a.log.Errorf("Can't build the book of abstracts: %v", err)
+		return c.Redirect("/admin")
+	}
+
+	for name, content := range map[string][]byte{BookPDFPath: pdf, BookHTMLPath: html} {
+		if err := a.disk.Save(bytes.NewReader(content), name); err != nil {
+			a.log.Errorf("Can't save %s: %v", name, err)
+		}
+	}
+	a.audit(c, "export.book", BookPDFPath, nil, nil)
+
+	return c.Redirect("/admin")
+}
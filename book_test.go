@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestBuildBookDeduplicates(t *testing.T) {
+	a := newTestApp(t)
+
+	participants := []Participant{
+		{Token: "anna", Name: "Anna", Surname: "Smith", PresentationTitle: "Ice loads"},
+		// Co-author registered with the same title.
+		{Token: "boris", Name: "Boris", Surname: "Ivanov", PresentationTitle: " ice  LOADS"},
+		{Token: "carl", Name: "Carl", Surname: "Berg"},
+		{Token: "dina", Name: "Dina", Surname: "Lee"},
+	}
+	accepted := []paperKey{
+		{"anna", "tezis"}, {"anna", "article"},
+		{"boris", "tezis"},
+		// Untitled open uploads.
+		{"carl", "open-upload"}, {"dina", "open-upload"},
+	}
+	for _, p := range participants {
+		if err := a.db.Create(&p).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range accepted {
+		submission := Submission{Token: key.Token, Type: key.Type, Version: 1, Path: key.Type + "/" + key.Token + ".doc"}
+		if err := a.db.Create(&submission).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := a.db.Create(&StatusChange{ParticipantToken: key.Token, Type: key.Type, To: StatusAccepted}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := a.buildBook()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var titles []string
+	for _, session := range b.Sessions {
+		for _, entry := range session.Entries {
+			titles = append(titles, entry.Title+" by "+entry.AuthorNames())
+		}
+	}
+	want := []string{"Ice loads by Anna Smith, Boris Ivanov", bookUntitled + " by Carl Berg", bookUntitled + " by Dina Lee"}
+	if len(titles) != len(want) {
+		t.Fatalf("book has %q, want %q", titles, want)
+	}
+	for _, w := range want {
+		if !contains(titles, w) {
+			t.Errorf("book has %q, want %q in it", titles, w)
+		}
+	}
+}
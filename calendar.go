@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Conference days used for the registration event until the schedule has
+// days.
+const (
+	DefaultConferenceStart = "2022-11-24"
+	DefaultConferenceEnd   = "2022-11-25"
+)
+
+const icsDateTime = "20060102T150405"
+
+// icsTimezone describes Europe/Moscow, on UTC+3 without daylight saving time
+// since 2014.
+const icsTimezone = `BEGIN:VTIMEZONE
+TZID:Europe/Moscow
+BEGIN:STANDARD
+DTSTART:19700101T000000
+TZOFFSETFROM:+0300
+TZOFFSETTO:+0300
+TZNAME:MSK
+END:STANDARD
+END:VTIMEZONE
+`
+
+// icsEvent is a VEVENT. All-day events span whole days from Start to End
+// inclusive.
+type icsEvent struct {
+	UID         string
+	Start       time.Time
+	End         time.Time
+	AllDay      bool
+	Summary     string
+	Location    string
+	Description string
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// icsLine folds content lines longer than 75 octets without splitting UTF-8
+// characters.
+func icsLine(buf *bytes.Buffer, line string) {
+	for len(line) > 75 {
+		cut := 75
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		buf.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	buf.WriteString(line + "\r\n")
+}
+
+// icsCalendar renders a VCALENDAR with times in the conference time zone.
+func icsCalendar(name string, events []icsEvent) []byte {
+	var buf bytes.Buffer
+
+	for _, line := range []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//AMTC//Programme//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + icsEscaper.Replace(name),
+		"X-WR-TIMEZONE:Europe/Moscow",
+	} {
+		icsLine(&buf, line)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(icsTimezone), "\n") {
+		icsLine(&buf, line)
+	}
+
+	stamp := time.Now().UTC().Format(icsDateTime) + "Z"
+	for _, e := range events {
+		lines := []string{"BEGIN:VEVENT", "UID:" + e.UID, "DTSTAMP:" + stamp}
+		if e.AllDay {
+			lines = append(lines,
+				"DTSTART;VALUE=DATE:"+e.Start.In(ConferenceLocation).Format("20060102"),
+				"DTEND;VALUE=DATE:"+e.End.In(ConferenceLocation).AddDate(0, 0, 1).Format("20060102"),
+			)
+		} else {
+			lines = append(lines,
+				"DTSTART;TZID=Europe/Moscow:"+e.Start.In(ConferenceLocation).Format(icsDateTime),
+				"DTEND;TZID=Europe/Moscow:"+e.End.In(ConferenceLocation).Format(icsDateTime),
+			)
+		}
+		lines = append(lines, "SUMMARY:"+icsEscaper.Replace(e.Summary))
+		if e.Location != "" {
+			lines = append(lines, "LOCATION:"+icsEscaper.Replace(e.Location))
+		}
+		if e.Description != "" {
+			lines = append(lines, "DESCRIPTION:"+icsEscaper.Replace(e.Description))
+		}
+		lines = append(lines, "END:VEVENT")
+
+		for _, line := range lines {
+			icsLine(&buf, line)
+		}
+	}
+
+	icsLine(&buf, "END:VCALENDAR")
+
+	return buf.Bytes()
+}
+
+// icsHost is the host part of event UIDs.
+func (a *App) icsHost() string {
+	if u, err := url.Parse(a.config.Domain); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return "amtc"
+}
+
+func (a *App) sessionEvent(session sessionView) icsEvent {
+	description := make([]string, 0, len(session.Slots)+1)
+	if session.Chair != "" {
+		description = append(description, "Chair: "+session.Chair)
+	}
+	for _, slot := range session.Slots {
+		talk := slot.Time() + " " + slot.Title
+		if slot.Speaker != "" {
+			talk += " — " + slot.Speaker
+		}
+		description = append(description, talk)
+	}
+
+	return icsEvent{
+		UID:         fmt.Sprintf("session-%d@%s", session.ID, a.icsHost()),
+		Start:       session.Start,
+		End:         session.End,
+		Summary:     session.Name,
+		Location:    session.Room.Name,
+		Description: strings.Join(description, "\n"),
+	}
+}
+
+func (a *App) slotEvent(slot Slot, session sessionView) icsEvent {
+	summary := slot.Title
+	if slot.Keynote {
+		summary = "Keynote: " + summary
+	}
+
+	return icsEvent{
+		UID:         fmt.Sprintf("slot-%d@%s", slot.ID, a.icsHost()),
+		Start:       slot.Start,
+		End:         slot.End,
+		Summary:     summary,
+		Location:    session.Room.Name,
+		Description: strings.TrimSpace(slot.Speaker + "\n" + session.Name),
+	}
+}
+
+// conferenceDates are the first and the last days of the schedule.
+func (a *App) conferenceDates() (time.Time, time.Time) {
+	days, err := a.programme()
+	if err != nil {
+		a.log.Error(err)
+	}
+	if len(days) > 0 {
+		return days[0].Date, days[len(days)-1].Date
+	}
+
+	start, _ := time.ParseInLocation("2006-01-02", DefaultConferenceStart, ConferenceLocation)
+	end, _ := time.ParseInLocation("2006-01-02", DefaultConferenceEnd, ConferenceLocation)
+	return start, end
+}
+
+// conferenceCalendar is the all-day event attached to the registration email.
+func (a *App) conferenceCalendar() Attachment {
+	start, end := a.conferenceDates()
+
+	return Attachment{
+		Name:        "amtc-2022.ics",
+		ContentType: "text/calendar; charset=utf-8; method=PUBLISH",
+		Content: icsCalendar("AMTC 2022", []icsEvent{{
+			UID:         "conference@" + a.icsHost(),
+			Start:       start,
+			End:         end,
+			AllDay:      true,
+			Summary:     "Arctic: Marine Transportation Challenges – 2022",
+			Location:    "Saint Petersburg",
+			Description: a.config.Domain + "/programme-overview",
+		}}),
+	}
+}
+
+func sendCalendar(c *fiber.Ctx, name string, content []byte) error {
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("inline; filename=%q", name))
+	return c.Send(content)
+}
+
+func (a *App) programmeCalendar(c *fiber.Ctx) error {
+	days, err := a.programme()
+	if err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	events := make([]icsEvent, 0)
+	for _, day := range days {
+		for _, session := range day.Sessions {
+			events = append(events, a.sessionEvent(session))
+		}
+	}
+
+	return sendCalendar(c, "programme.ics", icsCalendar("AMTC 2022 programme", events))
+}
+
+// participantCalendar is the agenda of a participant: their talks and the
+// sessions of the section they chose on registration.
+func (a *App) participantCalendar(c *fiber.Ctx) error {
+	participant, err := a.participantByToken(c.Query("code"))
+	if err != nil {
+		return c.Redirect("/404")
+	}
+
+	days, err := a.programme()
+	if err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	sessions := RegistrationPageContent["ConferenceSessions"].([]string)
+	chosen := sessionIndex(sessions, participant.PresentationSection)
+
+	events := make([]icsEvent, 0)
+	for _, day := range days {
+		for _, session := range day.Sessions {
+			if chosen < len(sessions) && sessionIndex(sessions, session.Name) == chosen {
+				events = append(events, a.sessionEvent(session))
+			}
+			for _, slot := range session.Slots {
+				if slot.ParticipantToken == participant.Token {
+					events = append(events, a.slotEvent(slot, session))
+				}
+			}
+		}
+	}
+
+	return sendCalendar(c, "agenda.ics", icsCalendar("My AMTC 2022 agenda", events))
+}
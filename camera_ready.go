@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CameraReadyDeadline is the key of the camera-ready deadline in
+// Config.Deadlines.
+const CameraReadyDeadline = "camera-ready"
+
+type cameraReadyArtifact struct {
+	Name       string
+	Label      string
+	Extensions []string
+}
+
+func (a cameraReadyArtifact) Accept() string {
+	accept := make([]string, 0, len(a.Extensions))
+	for _, ext := range a.Extensions {
+		accept = append(accept, "."+ext)
+	}
+	return strings.Join(accept, ", ")
+}
+
+var CameraReadyArtifacts = []cameraReadyArtifact{
+	{"final", "Final version", []string{"docx", "doc", "pdf"}},
+	{"copyright", "Signed copyright form", []string{"pdf", "jpg", "jpeg", "png"}},
+	{"slides", "Presentation slides", []string{"pdf", "ppt", "pptx"}},
+}
+
+func cameraReadyArtifactByName(name string) (cameraReadyArtifact, bool) {
+	for _, artifact := range CameraReadyArtifacts {
+		if artifact.Name == name {
+			return artifact, true
+		}
+	}
+	return cameraReadyArtifact{}, false
+}
+
+// cameraReadyItem is an artifact of an accepted paper with its latest upload.
+type cameraReadyItem struct {
+	cameraReadyArtifact
+	Required bool
+	Latest   *Submission
+}
+
+func (i cameraReadyItem) Missing() bool {
+	return i.Required && i.Latest == nil
+}
+
+// cameraReadyItems picks latest uploads of artifacts of the paper type from
+// submissions of the participant.
+func (a *App) cameraReadyItems(t string, submissions []Submission) []cameraReadyItem {
+	items := make([]cameraReadyItem, 0, len(CameraReadyArtifacts))
+	for _, artifact := range CameraReadyArtifacts {
+		item := cameraReadyItem{cameraReadyArtifact: artifact, Required: a.config.CameraReadyRequired(artifact.Name)}
+		for i, s := range submissions {
+			if s.Type == t && s.Artifact == artifact.Name && (item.Latest == nil || s.Version > item.Latest.Version) {
+				item.Latest = &submissions[i]
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func (a *App) uploadCameraReady(c *fiber.Ctx) error {
+	participant, err := a.participantByToken(c.Query("code"))
+	if err != nil {
+		return c.Redirect("/404")
+	}
+
+	t := c.Params("type")
+	artifact, ok := cameraReadyArtifactByName(c.Params("artifact"))
+	if !ok {
+		return c.Redirect("/404")
+	}
+
+	status, err := a.statusOf(paperKey{participant.Token, t})
+	if err != nil {
+		return err
+	}
+	if status.Status != StatusAccepted {
+		return a.renderParticipant(c, participant, fiber.Map{"Error": "Camera-ready files are collected for accepted papers only."})
+	}
+
+	if !a.config.UploadOpen(CameraReadyDeadline, time.Now()) {
+		return a.renderParticipant(c, participant, fiber.Map{"Error": UploadClosedMessage})
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		a.log.Error(err)
+		return a.renderParticipant(c, participant, fiber.Map{"Error": UploadErrorMessage})
+	}
+
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(file.Filename), "."))
+	if !contains(artifact.Extensions, ext) {
+		return a.renderParticipant(c, participant, fiber.Map{
+			"Error": fmt.Sprintf("%s must be one of: %s.", artifact.Label, artifact.Accept()),
+		})
+	}
+
+	content, err := file.Open()
+	if err != nil {
+		a.log.Error(err)
+		return a.renderParticipant(c, participant, fiber.Map{"Error": UploadErrorMessage})
+	}
+	defer content.Close()
+
+	version, err := a.nextSubmissionVersion(participant.Token, t, artifact.Name)
+	if err != nil {
+		a.log.Error(err)
+		return a.renderParticipant(c, participant, fiber.Map{"Error": UploadErrorMessage})
+	}
+
+	fileName := fmt.Sprintf("%s/%s_%s_%s_%s_%s_v%d.%s", CameraReadyDeadline, participant.Name, participant.Surname, participant.Email, t, artifact.Name, version, ext)
+	nameSurname := strings.Join([]string{participant.Name, participant.Surname}, " ")
+
+	err = a.storeUpload(content, fileName, file.Filename, participant.Email)
+	if errors.Is(err, ErrUploadRejected) {
+		a.sendUploadRejectedEmail(To{nameSurname, participant.Email}, file.Filename)
+		return a.renderParticipant(c, participant, fiber.Map{"Error": UploadRejectedMessage})
+	}
+	if err != nil {
+		a.log.Errorf("Can't save file to disk: %v", err)
+		return a.renderParticipant(c, participant, fiber.Map{"Error": UploadErrorMessage})
+	}
+
+	submission := Submission{
+		Token:        participant.Token,
+		Type:         t,
+		Artifact:     artifact.Name,
+		Version:      version,
+		OriginalName: file.Filename,
+		Path:         fileName,
+		Size:         file.Size,
+	}
+	if submission.SHA256, err = hashUpload(content); err != nil {
+		a.log.Errorf("Can't hash file '%s': %v", fileName, err)
+	}
+	a.checkCompliance(&submission, content)
+
+	if err := a.db.Create(&submission).Error; err != nil {
+		a.log.Errorf("Can't record submission '%s': %v", fileName, err)
+		a.discardUpload(fileName)
+		return a.renderParticipant(c, participant, fiber.Map{"Error": UploadErrorMessage})
+	}
+
+	if err := a.sendEmail(
+		To{nameSurname, participant.Email},
+		Message{AfterCameraReadyUploadEmail.Subject, fmt.Sprintf(AfterCameraReadyUploadEmail.Text, nameSurname, artifact.Label, receiptHTML(submission), a.config.Domain, participant.Token)},
+	); err != nil {
+		a.log.Error(err)
+	}
+
+	return a.renderParticipant(c, participant, fiber.Map{
+		"Success":  fmt.Sprintf("%s successfully uploaded", artifact.Label),
+		"Warnings": submission.Warnings(),
+	})
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// cameraReadyPaper is a row of the camera-ready checklist.
+type cameraReadyPaper struct {
+	Key         paperKey
+	Participant Participant
+	Items       []cameraReadyItem
+	Missing     int
+}
+
+func (a *App) cameraReadyAdminView(c *fiber.Ctx) error {
+	statuses, err := a.paperStatuses("")
+	if err != nil {
+		return err
+	}
+
+	papers := make([]cameraReadyPaper, 0)
+	complete := 0
+	for key, status := range statuses {
+		if status.Status != StatusAccepted {
+			continue
+		}
+
+		paper := cameraReadyPaper{Key: key}
+		if err := a.db.Where("token = ?", key.Token).First(&paper.Participant).Error; err != nil {
+			a.log.Errorf("Accepted paper %s has no participant: %v", key, err)
+		}
+
+		var submissions []Submission
+		if err := a.db.Where("token = ? AND type = ? AND artifact <> ?", key.Token, key.Type, "").Find(&submissions).Error; err != nil {
+			return err
+		}
+
+		paper.Items = a.cameraReadyItems(key.Type, submissions)
+		for _, item := range paper.Items {
+			if item.Missing() {
+				paper.Missing++
+			}
+		}
+		if paper.Missing == 0 {
+			complete++
+		}
+
+		papers = append(papers, paper)
+	}
+
+	sort.Slice(papers, func(i, j int) bool {
+		if papers[i].Missing != papers[j].Missing {
+			return papers[i].Missing > papers[j].Missing
+		}
+		return papers[i].Participant.Surname < papers[j].Participant.Surname
+	})
+
+	return c.Render("admin-camera-ready", fiber.Map{
+		"Title":     "Camera-ready",
+		"Papers":    papers,
+		"Complete":  complete,
+		"Artifacts": CameraReadyArtifacts,
+		"Deadline":  a.config.Deadlines[CameraReadyDeadline],
+	})
+}
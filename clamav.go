@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ClamdScanner checks files with a clamd daemon using the INSTREAM command.
+type ClamdScanner struct {
+	Network string
+	Address string
+	Timeout time.Duration
+	// ChunkSize must stay below StreamMaxLength of clamd.conf.
+	ChunkSize int
+}
+
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+// NewClamdScanner accepts addresses like tcp://127.0.0.1:3310 and
+// unix:///var/run/clamav/clamd.ctl.
+func NewClamdScanner(address string) (*ClamdScanner, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clamd address '%s': %w", address, err)
+	}
+
+	s := &ClamdScanner{
+		Timeout:   2 * time.Minute,
+		ChunkSize: 64 * 1024,
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		s.Network, s.Address = "tcp", u.Host
+	case "unix":
+		s.Network, s.Address = "unix", u.Path
+	default:
+		return nil, fmt.Errorf("invalid clamd address '%s': scheme must be tcp or unix", address)
+	}
+
+	return s, nil
+}
+
+func (s *ClamdScanner) command(command string, body func(conn net.Conn) error) (string, error) {
+	conn, err := net.DialTimeout(s.Network, s.Address, 10*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("can't connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(s.Timeout)); err != nil {
+		return "", err
+	}
+
+	// The z prefix makes clamd use null terminated replies.
+	if _, err := conn.Write([]byte("z" + command + "\x00")); err != nil {
+		return "", fmt.Errorf("can't send %s to clamd: %w", command, err)
+	}
+
+	if body != nil {
+		if err := body(conn); err != nil {
+			return "", err
+		}
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return "", fmt.Errorf("can't read clamd reply: %w", err)
+	}
+
+	return strings.TrimRight(reply, "\x00\n"), nil
+}
+
+func (s *ClamdScanner) Ping() error {
+	reply, err := s.command("PING", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected clamd reply: %s", reply)
+	}
+	return nil
+}
+
+// Scan streams r to clamd. An error means the file couldn't be checked.
+func (s *ClamdScanner) Scan(r io.Reader) (ScanResult, error) {
+	reply, err := s.command("INSTREAM", func(conn net.Conn) error {
+		chunk := make([]byte, s.ChunkSize)
+		size := make([]byte, 4)
+
+		for {
+			n, err := r.Read(chunk)
+			if n > 0 {
+				binary.BigEndian.PutUint32(size, uint32(n))
+				if _, err := conn.Write(append(size, chunk[:n]...)); err != nil {
+					return fmt.Errorf("can't stream file to clamd: %w", err)
+				}
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := conn.Write([]byte{0, 0, 0, 0})
+		return err
+	})
+	if err != nil {
+		return ScanResult{}, err
+	}
+
+	// Replies look like "stream: OK", "stream: Eicar-Signature FOUND" or
+	// "INSTREAM size limit exceeded. ERROR".
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return ScanResult{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+var ErrUploadRejected = errors.New("upload rejected by antivirus")
+
+// scanUpload returns a reason to quarantine the file or an empty string when
+// it is clean. Without a configured scanner every file is clean.
+func (a *App) scanUpload(content io.ReadSeeker) string {
+	if a.scanner == nil {
+		return ""
+	}
+
+	result, err := a.scanner.Scan(content)
+	if _, seekErr := content.Seek(0, io.SeekStart); seekErr != nil && err == nil {
+		err = seekErr
+	}
+
+	if err != nil {
+		a.log.Errorf("Can't scan upload: %v", err)
+		return "Not scanned: " + err.Error()
+	}
+	if result.Infected {
+		return "Infected: " + result.Signature
+	}
+
+	return ""
+}
+
+// quarantine moves a rejected upload into quarantine storage instead of its
+// folder, so it never ends up in archives.
+func (a *App) quarantine(content io.Reader, fileName, originalName, email, reason string) error {
+	fileName = "quarantine/" + fileName
+
+	if err := a.disk.Save(content, fileName); err != nil {
+		return err
+	}
+
+	return a.db.Create(&QuarantinedFile{
+		Path:         fileName,
+		OriginalName: originalName,
+		Email:        email,
+		Reason:       reason,
+	}).Error
+}
+
+// storeUpload checks an upload and saves it as fileName. A rejected file is
+// quarantined and ErrUploadRejected returned.
+func (a *App) storeUpload(content io.ReadSeeker, fileName, originalName, email string) error {
+	if reason := a.scanUpload(content); reason != "" {
+		a.log.Infof("Upload '%s' from %s quarantined: %s", originalName, email, reason)
+
+		if err := a.quarantine(content, fileName, originalName, email, reason); err != nil {
+			a.log.Errorf("Can't quarantine file '%s': %v", fileName, err)
+		}
+
+		return fmt.Errorf("%w: %s", ErrUploadRejected, reason)
+	}
+
+	return a.disk.Save(content, fileName)
+}
+
+// discardUpload deletes a stored upload that couldn't be recorded. It gets
+// no receipt, the participant uploads it again.
+func (a *App) discardUpload(fileName string) {
+	if err := a.disk.Delete(fileName); err != nil {
+		a.log.Errorf("Can't delete file '%s': %v", fileName, err)
+	}
+}
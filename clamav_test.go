@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd answers PING and INSTREAM like clamd does, flagging streams
+// containing the EICAR test string.
+type fakeClamd struct {
+	listener net.Listener
+	// maxLength is StreamMaxLength of clamd.conf.
+	maxLength int
+	// streamed is the content of the last INSTREAM.
+	streamed chan []byte
+}
+
+func newFakeClamd(t *testing.T, network, address string) *fakeClamd {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	f := &fakeClamd{listener: l, maxLength: 1 << 20, streamed: make(chan []byte, 1)}
+	go f.serve()
+	return f
+}
+
+func (f *fakeClamd) address() string {
+	return f.listener.Addr().Network() + "://" + f.listener.Addr().String()
+}
+
+func (f *fakeClamd) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeClamd) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+
+	switch command {
+	case "zPING\x00":
+		conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM\x00":
+		var content []byte
+		size := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(r, size); err != nil {
+				return
+			}
+			n := binary.BigEndian.Uint32(size)
+			if n == 0 {
+				break
+			}
+			chunk := make([]byte, n)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			content = append(content, chunk...)
+			if len(content) > f.maxLength {
+				conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+				return
+			}
+		}
+
+		select {
+		case f.streamed <- content:
+		default:
+		}
+		if bytes.Contains(content, []byte(eicar)) {
+			conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+		} else {
+			conn.Write([]byte("stream: OK\x00"))
+		}
+	default:
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func newTestScanner(t *testing.T, clamd *fakeClamd) *ClamdScanner {
+	s, err := NewClamdScanner(clamd.address())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ChunkSize = 7
+	return s
+}
+
+func TestNewClamdScanner(t *testing.T) {
+	for address, want := range map[string][2]string{
+		"tcp://127.0.0.1:3310":                 {"tcp", "127.0.0.1:3310"},
+		"unix:///var/run/clamav/clamd.ctl":     {"unix", "/var/run/clamav/clamd.ctl"},
+		"http://127.0.0.1:3310":                {},
+		"/var/run/clamav/clamd.ctl":            {},
+		"tcp://[::1]:3310":                     {"tcp", "[::1]:3310"},
+		"unix:///var/run/clamav/clamd.ctl?x=1": {"unix", "/var/run/clamav/clamd.ctl"},
+	} {
+		s, err := NewClamdScanner(address)
+		if want[0] == "" {
+			if err == nil {
+				t.Errorf("NewClamdScanner(%q) accepted an invalid address", address)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewClamdScanner(%q): %v", address, err)
+			continue
+		}
+		if s.Network != want[0] || s.Address != want[1] {
+			t.Errorf("NewClamdScanner(%q) = %s %s, want %s %s", address, s.Network, s.Address, want[0], want[1])
+		}
+	}
+}
+
+func TestClamdScan(t *testing.T) {
+	clamd := newFakeClamd(t, "tcp", "127.0.0.1:0")
+	s := newTestScanner(t, clamd)
+
+	if err := s.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	content := strings.Repeat("clean paper ", 10)
+	result, err := s.Scan(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Infected {
+		t.Errorf("clean file is infected: %+v", result)
+	}
+	if got := string(<-clamd.streamed); got != content {
+		t.Errorf("clamd got %q, want %q", got, content)
+	}
+
+	result, err = s.Scan(strings.NewReader("paper " + eicar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Infected || result.Signature != "Eicar-Signature" {
+		t.Errorf("EICAR result is %+v", result)
+	}
+
+	clamd.maxLength = 10
+	if _, err := s.Scan(strings.NewReader(content)); err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Errorf("oversized file error is %v", err)
+	}
+}
+
+func TestClamdUnixSocket(t *testing.T) {
+	clamd := newFakeClamd(t, "unix", filepath.Join(t.TempDir(), "clamd.ctl"))
+	s, err := NewClamdScanner("unix://" + clamd.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := s.Scan(strings.NewReader(eicar)); err != nil || !result.Infected {
+		t.Errorf("EICAR result is %+v, %v", result, err)
+	}
+}
+
+func TestClamdUnavailable(t *testing.T) {
+	clamd := newFakeClamd(t, "tcp", "127.0.0.1:0")
+	s := newTestScanner(t, clamd)
+	clamd.listener.Close()
+
+	if err := s.Ping(); err == nil {
+		t.Error("Ping of a stopped clamd succeeded")
+	}
+	if _, err := s.Scan(strings.NewReader("paper")); err == nil {
+		t.Error("Scan with a stopped clamd succeeded")
+	}
+}
+
+func TestStoreUpload(t *testing.T) {
+	a := newTestApp(t)
+	clamd := newFakeClamd(t, "tcp", "127.0.0.1:0")
+	a.scanner = newTestScanner(t, clamd)
+
+	if err := a.storeUpload(strings.NewReader("clean paper"), "tezis/a.docx", "a.docx", "j@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	file, err := a.disk.Open("tezis/a.docx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(file)
+	file.Close()
+	if string(content) != "clean paper" {
+		t.Errorf("stored %q after the scan, want the whole file", content)
+	}
+
+	err = a.storeUpload(strings.NewReader(eicar), "tezis/b.docx", "b.docx", "j@example.com")
+	if !errors.Is(err, ErrUploadRejected) {
+		t.Fatalf("infected upload error is %v, want ErrUploadRejected", err)
+	}
+	if _, err := a.disk.Stat("tezis/b.docx"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("infected upload is stored in its folder: %v", err)
+	}
+	if _, err := a.disk.Stat("quarantine/tezis/b.docx"); err != nil {
+		t.Errorf("infected upload isn't quarantined: %v", err)
+	}
+
+	var quarantined []QuarantinedFile
+	if err := a.db.Find(&quarantined).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 1 || quarantined[0].OriginalName != "b.docx" || quarantined[0].Reason != "Infected: Eicar-Signature" {
+		t.Errorf("quarantined %+v", quarantined)
+	}
+
+	// Files that can't be checked are quarantined too.
+	clamd.listener.Close()
+	err = a.storeUpload(strings.NewReader("paper"), "tezis/c.docx", "c.docx", "j@example.com")
+	if !errors.Is(err, ErrUploadRejected) {
+		t.Errorf("unscanned upload error is %v, want ErrUploadRejected", err)
+	}
+}
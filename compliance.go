@@ -0,0 +1,405 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ComplianceRule describes limits of the article template for an upload type.
+// Zero limits are not checked.
+type ComplianceRule struct {
+	MaxPages int
+	MinWords int
+	MaxWords int
+	// Sections are headings which must be present, e.g. "Keywords".
+	Sections []string
+}
+
+var DefaultComplianceRules = map[string]ComplianceRule{
+	"tezis": {
+		MaxPages: 2,
+		MaxWords: 500,
+		Sections: []string{"Keywords"},
+	},
+	"article": {
+		MaxPages: 12,
+		MinWords: 2000,
+		Sections: []string{"Abstract", "Keywords", "Introduction", "Conclusion", "References"},
+	},
+}
+
+// complianceRuleType maps upload types sharing a template.
+var complianceRuleType = map[string]string{
+	"open-upload": "article",
+}
+
+// DocumentInfo is what could be extracted from an uploaded document. Pages is
+// zero when unknown.
+type DocumentInfo struct {
+	Pages int
+	Words int
+	Title string
+	Text  string
+}
+
+// ErrUnsupportedDocument is returned for formats other than docx and pdf.
+var ErrUnsupportedDocument = errors.New("unsupported format")
+
+// documentMaxInflated limits how much the compressed parts of a document are
+// inflated to when it's read, uploads can be decompression bombs.
+const documentMaxInflated = 64 << 20
+
+// ErrDocumentTooLarge is returned for documents inflating to over
+// documentMaxInflated.
+var ErrDocumentTooLarge = errors.New("the document inflates to over 64 MB")
+
+// inflateBudget is what is left of documentMaxInflated for a document.
+type inflateBudget int64
+
+func newInflateBudget() *inflateBudget {
+	b := inflateBudget(documentMaxInflated)
+	return &b
+}
+
+// read reads r to the end or fails with ErrDocumentTooLarge when it's over the
+// budget.
+func (b *inflateBudget) read(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(*b)+1))
+	if int64(len(data)) > int64(*b) {
+		return nil, ErrDocumentTooLarge
+	}
+	*b -= inflateBudget(len(data))
+	return data, err
+}
+
+// readZipFile reads an entry of an archive within the budget.
+func (b *inflateBudget) readZipFile(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(*b) {
+		return nil, ErrDocumentTooLarge
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return b.read(r)
+}
+
+// extractDocumentInfo supports docx and pdf files.
+func extractDocumentInfo(content []byte, ext string) (DocumentInfo, error) {
+	switch strings.ToLower(ext) {
+	case "docx":
+		return extractDocxInfo(content)
+	case "pdf":
+		return extractPDFInfo(content)
+	default:
+		return DocumentInfo{}, fmt.Errorf("%w: .%s", ErrUnsupportedDocument, ext)
+	}
+}
+
+func extractDocxInfo(content []byte) (DocumentInfo, error) {
+	var info DocumentInfo
+	budget := newInflateBudget()
+
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return info, fmt.Errorf("not a docx file: %w", err)
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	document, ok := files["word/document.xml"]
+	if !ok {
+		return info, fmt.Errorf("not a docx file: word/document.xml is missing")
+	}
+
+	info.Text, err = docxText(budget, document)
+	if err != nil {
+		return info, err
+	}
+	info.Words = len(strings.Fields(info.Text))
+
+	// Pages are only known from the statistics saved by Word.
+	if app, ok := files["docProps/app.xml"]; ok {
+		var props struct {
+			Pages int `xml:"Pages"`
+		}
+		if err := readZipXML(budget, app, &props); err == nil {
+			info.Pages = props.Pages
+		}
+	}
+
+	if core, ok := files["docProps/core.xml"]; ok {
+		var props struct {
+			Title string `xml:"title"`
+		}
+		if err := readZipXML(budget, core, &props); err == nil {
+			info.Title = strings.TrimSpace(props.Title)
+		}
+	}
+
+	if info.Title == "" {
+		info.Title = firstLine(info.Text)
+	}
+
+	return info, nil
+}
+
+func readZipXML(budget *inflateBudget, f *zip.File, v any) error {
+	data, err := budget.readZipFile(f)
+	if err != nil {
+		return err
+	}
+
+	return xml.Unmarshal(data, v)
+}
+
+// docxText returns text of the document with a line per paragraph.
+func docxText(budget *inflateBudget, f *zip.File) (string, error) {
+	data, err := budget.readZipFile(f)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		text   strings.Builder
+		inText bool
+	)
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("can't parse document.xml: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				text.WriteString(" ")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(t)
+			}
+		}
+	}
+
+	return text.String(), nil
+}
+
+var (
+	pdfPageRe   = regexp.MustCompile(`/Type\s*/Page[^s]`)
+	pdfTitleRe  = regexp.MustCompile(`/Title\s*\(((?:\\.|[^\\)])*)\)`)
+	pdfStreamRe = regexp.MustCompile(`(?s)stream\r?\n(.*?)\r?\nendstream`)
+	pdfTextRe   = regexp.MustCompile(`(?s)\[(.*?)\]\s*TJ|\(((?:\\.|[^\\)])*)\)\s*(?:Tj|'|")|(T\*|Td|TD|ET)`)
+	pdfStringRe = regexp.MustCompile(`\(((?:\\.|[^\\)])*)\)`)
+)
+
+// extractPDFInfo is a best effort reader of uncompressed and Flate compressed
+// content streams. Text of PDFs with custom font encodings is not extracted.
+func extractPDFInfo(content []byte) (DocumentInfo, error) {
+	var info DocumentInfo
+	budget := newInflateBudget()
+
+	if !bytes.HasPrefix(content, []byte("%PDF-")) {
+		return info, fmt.Errorf("not a pdf file")
+	}
+
+	info.Pages = len(pdfPageRe.FindAll(content, -1))
+
+	if m := pdfTitleRe.FindSubmatch(content); m != nil {
+		info.Title = strings.TrimSpace(unescapePDFString(m[1]))
+	}
+
+	var text strings.Builder
+	for _, m := range pdfStreamRe.FindAllSubmatch(content, -1) {
+		stream := m[1]
+		if r, err := zlib.NewReader(bytes.NewReader(stream)); err == nil {
+			inflated, err := budget.read(r)
+			if errors.Is(err, ErrDocumentTooLarge) {
+				return info, err
+			}
+			if err == nil {
+				stream = inflated
+			}
+		}
+
+		for _, op := range pdfTextRe.FindAllSubmatch(stream, -1) {
+			switch {
+			case op[1] != nil:
+				for _, s := range pdfStringRe.FindAllSubmatch(op[1], -1) {
+					text.WriteString(unescapePDFString(s[1]))
+				}
+			case op[2] != nil:
+				text.WriteString(unescapePDFString(op[2]))
+			default:
+				text.WriteString("\n")
+			}
+		}
+	}
+
+	info.Text = text.String()
+	info.Words = len(strings.Fields(info.Text))
+
+	if info.Title == "" {
+		info.Title = firstLine(info.Text)
+	}
+
+	return info, nil
+}
+
+func unescapePDFString(s []byte) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch c := s[i]; c {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r', 't':
+			b.WriteByte(' ')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i
+			for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+				j++
+			}
+			v, _ := strconv.ParseUint(string(s[i:j]), 8, 8)
+			b.WriteByte(byte(v))
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			if len(line) > 200 {
+				line = line[:200]
+			}
+			return line
+		}
+	}
+	return ""
+}
+
+// hasSection looks for a line starting with the section name, so both
+// "Keywords" and "Keywords: ice, ship" headings are found.
+func hasSection(text, section string) bool {
+	section = strings.ToLower(section)
+	for _, line := range strings.Split(strings.ToLower(text), "\n") {
+		line = strings.TrimLeft(strings.TrimSpace(line), "0123456789. ")
+		if strings.HasPrefix(line, section) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns template violations of the document.
+func (r ComplianceRule) Check(info DocumentInfo) []string {
+	warnings := make([]string, 0)
+
+	if r.MaxPages > 0 && info.Pages > r.MaxPages {
+		warnings = append(warnings, fmt.Sprintf("The document has %d pages, the limit is %d.", info.Pages, r.MaxPages))
+	}
+	if r.MinWords > 0 && info.Words < r.MinWords {
+		warnings = append(warnings, fmt.Sprintf("The document has %d words, at least %d are required.", info.Words, r.MinWords))
+	}
+	if r.MaxWords > 0 && info.Words > r.MaxWords {
+		warnings = append(warnings, fmt.Sprintf("The document has %d words, the limit is %d.", info.Words, r.MaxWords))
+	}
+	if info.Title == "" {
+		warnings = append(warnings, "The document has no title.")
+	}
+	for _, section := range r.Sections {
+		if !hasSection(info.Text, section) {
+			warnings = append(warnings, fmt.Sprintf("Section «%s» is missing.", section))
+		}
+	}
+
+	return warnings
+}
+
+// checkCompliance fills compliance fields of the submission from its content.
+func (a *App) checkCompliance(submission *Submission, content io.ReadSeeker) {
+	// Of camera-ready items only the final version follows the template.
+	if submission.Artifact != "" && submission.Artifact != "final" {
+		return
+	}
+
+	t := submission.Type
+	if shared, ok := complianceRuleType[t]; ok {
+		t = shared
+	}
+
+	rule, ok := a.config.Compliance[t]
+	if !ok {
+		return
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		a.log.Errorf("Can't read submission for compliance check: %v", err)
+		return
+	}
+
+	data, err := io.ReadAll(content)
+	if err != nil {
+		a.log.Errorf("Can't read submission for compliance check: %v", err)
+		return
+	}
+
+	info, err := extractDocumentInfo(data, strings.TrimPrefix(path.Ext(submission.OriginalName), "."))
+	if errors.Is(err, ErrUnsupportedDocument) {
+		// Uploads accept .doc files, they are left unchecked.
+		return
+	}
+	if errors.Is(err, ErrDocumentTooLarge) {
+		a.log.Errorf("Can't check submission %s against the template: %v", submission.OriginalName, err)
+		return
+	}
+	submission.ComplianceChecked = true
+	if err != nil {
+		submission.ComplianceWarnings = "Can't check the document against the template: " + err.Error() + "."
+		return
+	}
+
+	submission.Pages = info.Pages
+	submission.Words = info.Words
+	submission.Title = info.Title
+	submission.ComplianceWarnings = strings.Join(rule.Check(info), "\n")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCheckComplianceFormats(t *testing.T) {
+	a := newTestApp(t)
+	a.config.Compliance = DefaultComplianceRules
+
+	doc := Submission{Type: "article", OriginalName: "paper.doc"}
+	a.checkCompliance(&doc, strings.NewReader("binary word document"))
+	if doc.ComplianceChecked || doc.ComplianceWarnings != "" {
+		t.Errorf(".doc upload is checked: %+v", doc)
+	}
+
+	broken := Submission{Type: "article", OriginalName: "paper.docx"}
+	a.checkCompliance(&broken, strings.NewReader("not a zip"))
+	if !broken.ComplianceChecked || !strings.Contains(broken.ComplianceWarnings, "not a docx file") {
+		t.Errorf("broken .docx upload is %+v", broken)
+	}
+}
+
+// zeros writes n zero bytes to w.
+func zeros(t *testing.T, w io.Writer, n int) {
+	t.Helper()
+	if _, err := io.CopyN(w, zeroReader{}, int64(n)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestDecompressionBombs(t *testing.T) {
+	var docx bytes.Buffer
+	zw := zip.NewWriter(&docx)
+	w, err := zw.Create("word/document.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zeros(t, w, documentMaxInflated+1)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var stream bytes.Buffer
+	zlw := zlib.NewWriter(&stream)
+	zeros(t, zlw, documentMaxInflated+1)
+	zlw.Close()
+	pdf := append([]byte("%PDF-1.4\n1 0 obj\n<< /Filter /FlateDecode >>\nstream\n"), stream.Bytes()...)
+	pdf = append(pdf, "\nendstream\nendobj\n"...)
+
+	for ext, content := range map[string][]byte{"docx": docx.Bytes(), "pdf": pdf} {
+		if _, err := extractDocumentInfo(content, ext); !errors.Is(err, ErrDocumentTooLarge) {
+			t.Errorf("%s bomb of %d bytes: %v", ext, len(content), err)
+		}
+		if ext == "docx" {
+			if _, err := anonymizeDocument(content, ext); !errors.Is(err, ErrDocumentTooLarge) {
+				t.Errorf("docx bomb anonymized: %v", err)
+			}
+		}
+
+		a := newTestApp(t)
+		a.config.Compliance = DefaultComplianceRules
+		submission := Submission{Type: "article", OriginalName: "paper." + ext}
+		a.checkCompliance(&submission, bytes.NewReader(content))
+		if submission.ComplianceChecked {
+			t.Errorf("%s bomb is checked: %+v", ext, submission)
+		}
+	}
+}
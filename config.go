@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -17,7 +19,28 @@ type Config struct {
 	Captcha         HCaptchaConfig
 	SMTP            SMTPConfig
 	DiskPath        string
+	YandexDisk      YandexDiskConfig
+	ClamdAddress    string
 	UploadingDate   string
+	// Deadlines holds the last day of uploads by upload type. Uploads of a
+	// type without a deadline are always open.
+	Deadlines map[string]time.Time
+	// Compliance holds template limits by upload type.
+	Compliance map[string]ComplianceRule
+	// ReviewsPerPaper is how many reviewers the automatic assignment picks.
+	ReviewsPerPaper int
+	// RequiredCameraReady are camera-ready artifacts accepted papers must have.
+	RequiredCameraReady []string
+	// BookFont is a TrueType font for the book of abstracts, needed for
+	// non-Latin names.
+	BookFont string
+	// TOTPRequiredRoles are admin roles that must sign in with a second
+	// factor.
+	TOTPRequiredRoles []string
+	// TrustedProxies are addresses or CIDR ranges of reverse proxies whose
+	// X-Real-IP header gives the client address.
+	TrustedProxies []string
+	OIDC           OIDCConfig
 }
 
 type HCaptchaConfig struct {
@@ -26,6 +49,33 @@ type HCaptchaConfig struct {
 	SiteKey   string
 }
 
+// YandexDiskConfig enables Yandex Disk storage when Token is set. With Mirror
+// files are kept on the local disk and copied to Yandex Disk.
+type YandexDiskConfig struct {
+	Token  string `json:"-"`
+	Root   string
+	Mirror bool
+}
+
+// OIDCConfig enables single sign-on to the admin panel when Issuer is set.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string `json:"-"`
+	RedirectURL  string
+	Scopes       []string
+	// Label is the text of the sign in button.
+	Label string
+	// GroupsClaim names the claim with groups, a dotted path for nested
+	// claims like "realm_access.roles".
+	GroupsClaim string
+	// RoleGroups and RoleDomains map groups and email domains to roles.
+	RoleGroups  map[string]string
+	RoleDomains map[string]string
+	// AutoCreate creates accounts on the first sign in.
+	AutoCreate bool
+}
+
 type SMTPConfig struct {
 	User     string
 	Password string
@@ -90,6 +140,13 @@ func (c *Config) LoadEnv() error {
 		}
 	}
 
+	c.YandexDisk.Token = os.Getenv("YANDEX_OAUTH_TOKEN")
+	c.YandexDisk.Root = os.Getenv("YANDEX_DISK_ROOT")
+	c.YandexDisk.Mirror = os.Getenv("YANDEX_DISK_MIRROR") == "true"
+
+	c.ClamdAddress = os.Getenv("CLAMD_ADDRESS")
+	c.BookFont = os.Getenv("BOOK_FONT")
+
 	if c.DatabaseURL == "" {
 		c.DatabaseURL, ok = os.LookupEnv("DATABASE_URL")
 		if !ok {
@@ -97,9 +154,11 @@ func (c *Config) LoadEnv() error {
 		}
 	}
 
-	c.AdminPassword, ok = os.LookupEnv("ADMIN_PASSWORD")
-	if !ok {
-		return fmt.Errorf("ADMIN_PASSWORD not set")
+	c.AdminPassword = os.Getenv("ADMIN_PASSWORD")
+
+	c.TrustedProxies = []string{"127.0.0.1", "::1"}
+	if v, ok := os.LookupEnv("TRUSTED_PROXIES"); ok {
+		c.TrustedProxies = splitList(v)
 	}
 
 	c.UploadingDate, ok = os.LookupEnv("UPLOADING_DATE")
@@ -107,9 +166,177 @@ func (c *Config) LoadEnv() error {
 		return fmt.Errorf("UPLOADING_DATE not set")
 	}
 
+	c.Deadlines = make(map[string]time.Time)
+	for t, env := range map[string]string{"tezis": "TEZIS_DEADLINE", "article": "ARTICLE_DEADLINE", CameraReadyDeadline: "CAMERA_READY_DEADLINE", ReviewResultsDate: "REVIEW_RESULTS_DATE"} {
+		if v, ok := os.LookupEnv(env); ok {
+			d, err := time.ParseInLocation("2006-01-02", v, ConferenceLocation)
+			if err != nil {
+				return fmt.Errorf("%s must be YYYY-MM-DD: %w", env, err)
+			}
+			c.Deadlines[t] = d
+		}
+	}
+
+	c.Compliance = make(map[string]ComplianceRule)
+	for t, rule := range DefaultComplianceRules {
+		prefix := strings.ToUpper(t) + "_"
+		for env, limit := range map[string]*int{"MAX_PAGES": &rule.MaxPages, "MIN_WORDS": &rule.MinWords, "MAX_WORDS": &rule.MaxWords} {
+			if v, ok := os.LookupEnv(prefix + env); ok {
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					return fmt.Errorf("%s must be a number: %w", prefix+env, err)
+				}
+				*limit = n
+			}
+		}
+		if v, ok := os.LookupEnv(prefix + "REQUIRED_SECTIONS"); ok {
+			rule.Sections = splitList(v)
+		}
+		c.Compliance[t] = rule
+	}
+
+	c.ReviewsPerPaper = 3
+	if v, ok := os.LookupEnv("REVIEWS_PER_PAPER"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("REVIEWS_PER_PAPER must be a positive number")
+		}
+		c.ReviewsPerPaper = n
+	}
+
+	c.RequiredCameraReady = []string{"final", "copyright"}
+	if v, ok := os.LookupEnv("CAMERA_READY_REQUIRED"); ok {
+		c.RequiredCameraReady = splitList(v)
+		for _, name := range c.RequiredCameraReady {
+			if _, ok := cameraReadyArtifactByName(name); !ok {
+				return fmt.Errorf("CAMERA_READY_REQUIRED: unknown item %q", name)
+			}
+		}
+	}
+
+	if v, ok := os.LookupEnv("TOTP_REQUIRED_ROLES"); ok {
+		c.TOTPRequiredRoles = splitList(v)
+		for _, role := range c.TOTPRequiredRoles {
+			if !validRole(role) {
+				return fmt.Errorf("TOTP_REQUIRED_ROLES: unknown role %q", role)
+			}
+		}
+	}
+
+	if v := os.Getenv("OIDC_ISSUER"); v != "" {
+		c.OIDC.Issuer = v
+
+		c.OIDC.ClientID, ok = os.LookupEnv("OIDC_CLIENT_ID")
+		if !ok {
+			return fmt.Errorf("OIDC_CLIENT_ID not set")
+		}
+		c.OIDC.ClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+
+		c.OIDC.RedirectURL = strings.TrimSuffix(c.Domain, "/") + "/admin/login/oidc/callback"
+		if v, ok := os.LookupEnv("OIDC_REDIRECT_URL"); ok {
+			c.OIDC.RedirectURL = v
+		}
+
+		c.OIDC.Scopes = []string{"openid", "email", "profile"}
+		if v, ok := os.LookupEnv("OIDC_SCOPES"); ok {
+			c.OIDC.Scopes = splitList(v)
+			if !contains(c.OIDC.Scopes, "openid") {
+				c.OIDC.Scopes = append([]string{"openid"}, c.OIDC.Scopes...)
+			}
+		}
+
+		c.OIDC.Label = "Sign in with the university account"
+		if v, ok := os.LookupEnv("OIDC_LABEL"); ok {
+			c.OIDC.Label = v
+		}
+
+		c.OIDC.GroupsClaim = "groups"
+		if v, ok := os.LookupEnv("OIDC_GROUPS_CLAIM"); ok {
+			c.OIDC.GroupsClaim = v
+		}
+
+		var err error
+		if c.OIDC.RoleGroups, err = parseRoleMap("OIDC_ROLE_GROUPS"); err != nil {
+			return err
+		}
+		domains, err := parseRoleMap("OIDC_ROLE_DOMAINS")
+		if err != nil {
+			return err
+		}
+		c.OIDC.RoleDomains = make(map[string]string)
+		for domain, role := range domains {
+			c.OIDC.RoleDomains[strings.ToLower(strings.TrimPrefix(domain, "@"))] = role
+		}
+
+		c.OIDC.AutoCreate = os.Getenv("OIDC_AUTO_CREATE") == "true"
+	}
+
 	return nil
 }
 
+// parseRoleMap parses "name=role,..." env values.
+func parseRoleMap(env string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, item := range splitList(os.Getenv(env)) {
+		name, role, ok := strings.Cut(item, "=")
+		name, role = strings.TrimSpace(name), strings.TrimSpace(role)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s: %q must be name=role", env, item)
+		}
+		if !validRole(role) {
+			return nil, fmt.Errorf("%s: unknown role %q", env, role)
+		}
+		roles[name] = role
+	}
+	return roles, nil
+}
+
+// splitList parses comma separated env values.
+func splitList(v string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// CameraReadyRequired reports whether accepted papers must have artifact.
+func (c *Config) CameraReadyRequired(artifact string) bool {
+	for _, name := range c.RequiredCameraReady {
+		if name == artifact {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) TOTPRequired(role string) bool {
+	return contains(c.TOTPRequiredRoles, role)
+}
+
+// UploadOpen reports whether uploads of type t are accepted at now. A deadline
+// lasts until the end of its day in the conference time zone.
+func (c *Config) UploadOpen(t string, now time.Time) bool {
+	d, ok := c.Deadlines[t]
+	if !ok {
+		return true
+	}
+	return now.Before(d.AddDate(0, 0, 1))
+}
+
+// ConferenceLocation is the time zone of Saint Petersburg.
+var ConferenceLocation = loadConferenceLocation()
+
+func loadConferenceLocation() *time.Location {
+	loc, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		return time.FixedZone("MSK", 3*60*60)
+	}
+	return loc
+}
+
 func (c *Config) String() string {
 	b, _ := json.MarshalIndent(c, "", "  ")
 
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RegistrationDateLayout is the format of Participant.CreatedAt.
+const RegistrationDateLayout = "2006-01-02"
+
+// ListenerPresentationForm is the participation form without a paper.
+const ListenerPresentationForm = "Listener"
+
+// dashboardUploadTypes are the uploads compared with their deadlines.
+var dashboardUploadTypes = []string{"tezis", "article"}
+
+// dashboardTopOrganizations are listed, the rest are summed up as other.
+const dashboardTopOrganizations = 10
+
+// dashboardMaxDays limits time series to a year.
+const dashboardMaxDays = 366
+
+// Registered is the day of registration. Early registrations were stored as
+// "01-02-2002", the month, the day and the day of the year without the
+// year, they are taken as the latest such day before now.
+func (p Participant) Registered(now time.Time) (time.Time, bool) {
+	if d, err := time.ParseInLocation(RegistrationDateLayout, p.CreatedAt, ConferenceLocation); err == nil {
+		return d, true
+	}
+
+	parts := strings.SplitN(p.CreatedAt, "-", 3)
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	month, err := strconv.Atoi(parts[0])
+	if err != nil || month < 1 || month > 12 {
+		return time.Time{}, false
+	}
+	day, err := strconv.Atoi(parts[1])
+	if err != nil || day < 1 || day > 31 {
+		return time.Time{}, false
+	}
+
+	now = now.In(ConferenceLocation)
+	d := time.Date(now.Year(), time.Month(month), day, 0, 0, 0, 0, ConferenceLocation)
+	if d.After(now) {
+		d = d.AddDate(-1, 0, 0)
+	}
+	return d, true
+}
+
+type dashboardCount struct {
+	Label   string  `json:"label"`
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"`
+}
+
+// dashboardDay is a day of a time series, Total is the running total.
+type dashboardDay struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+	Total int    `json:"total"`
+}
+
+type dashboardUploads struct {
+	Type  string `json:"type"`
+	Label string `json:"label"`
+	// Expected are participants presenting a paper.
+	Expected int        `json:"expected"`
+	Uploaded int        `json:"uploaded"`
+	Rate     float64    `json:"rate"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+	// DaysLeft is negative after the deadline.
+	DaysLeft int            `json:"days_left"`
+	OnTime   int            `json:"on_time"`
+	Late     int            `json:"late"`
+	Days     []dashboardDay `json:"days"`
+}
+
+func (u dashboardUploads) DeadlineLabel() string {
+	if u.Deadline == nil {
+		return "No deadline"
+	}
+	return u.Deadline.Format("2 January 2006")
+}
+
+type dashboardMailSubject struct {
+	Subject string `json:"subject"`
+	Sent    int    `json:"sent"`
+	Failed  int    `json:"failed"`
+}
+
+type dashboardMail struct {
+	Sent     int                    `json:"sent"`
+	Failed   int                    `json:"failed"`
+	Rate     float64                `json:"delivery_rate"`
+	Subjects []dashboardMailSubject `json:"subjects"`
+}
+
+// dashboard holds registration and submission statistics, it's served as
+// JSON as is.
+type dashboard struct {
+	GeneratedAt   time.Time      `json:"generated_at"`
+	Participants  int            `json:"participants"`
+	Registrations []dashboardDay `json:"registrations"`
+	// UnknownDates are registrations without a readable date.
+	UnknownDates  int                `json:"unknown_dates"`
+	Forms         []dashboardCount   `json:"forms"`
+	Sections      []dashboardCount   `json:"sections"`
+	Organizations []dashboardCount   `json:"organizations"`
+	Uploads       []dashboardUploads `json:"uploads"`
+	Statuses      []dashboardCount   `json:"statuses"`
+	Mail          dashboardMail      `json:"mail"`
+}
+
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return math.Round(float64(part)*1000/float64(whole)) / 10
+}
+
+func day(t time.Time) time.Time {
+	t = t.In(ConferenceLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, ConferenceLocation)
+}
+
+// dailySeries counts dates by day over the days of the dates and of span,
+// days without events included.
+func dailySeries(dates []time.Time, span ...time.Time) []dashboardDay {
+	if len(dates) == 0 {
+		return []dashboardDay{}
+	}
+
+	counts := make(map[time.Time]int)
+	first, last := day(dates[0]), day(dates[0])
+	for _, d := range dates {
+		d = day(d)
+		counts[d]++
+		if d.Before(first) {
+			first = d
+		}
+		if d.After(last) {
+			last = d
+		}
+	}
+	for _, d := range span {
+		if d = day(d); d.Before(first) {
+			first = d
+		} else if d.After(last) {
+			last = d
+		}
+	}
+	if last.Sub(first) > dashboardMaxDays*24*time.Hour {
+		first = last.AddDate(0, 0, -dashboardMaxDays)
+	}
+
+	total := 0
+	for d := range counts {
+		if d.Before(first) {
+			total += counts[d]
+		}
+	}
+
+	var series []dashboardDay
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		total += counts[d]
+		series = append(series, dashboardDay{Date: d.Format(RegistrationDateLayout), Count: counts[d], Total: total})
+	}
+	return series
+}
+
+// breakdown counts labels, largest first, empty ones as "Not set".
+func breakdown(labels []string, top int) []dashboardCount {
+	counts := make(map[string]int)
+	for _, label := range labels {
+		if label = strings.TrimSpace(label); label == "" {
+			label = "Not set"
+		}
+		counts[label]++
+	}
+
+	result := make([]dashboardCount, 0, len(counts))
+	for label, count := range counts {
+		result = append(result, dashboardCount{Label: label, Count: count, Percent: percent(count, len(labels))})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Label < result[j].Label
+	})
+
+	if top > 0 && len(result) > top {
+		other := dashboardCount{Label: "Other"}
+		for _, c := range result[top:] {
+			other.Count += c.Count
+		}
+		other.Percent = percent(other.Count, len(labels))
+		result = append(result[:top], other)
+	}
+	return result
+}
+
+// organizationLabels groups spellings of an organization differing in case
+// and spaces under the most common one.
+func organizationLabels(participants []Participant) []string {
+	spellings := make(map[string]map[string]int)
+	for _, p := range participants {
+		name := strings.Join(strings.Fields(p.Organization), " ")
+		key := strings.ToLower(name)
+		if spellings[key] == nil {
+			spellings[key] = make(map[string]int)
+		}
+		spellings[key][name]++
+	}
+
+	labels := make([]string, 0, len(participants))
+	for _, p := range participants {
+		best, bestCount := "", 0
+		for name, count := range spellings[strings.ToLower(strings.Join(strings.Fields(p.Organization), " "))] {
+			if count > bestCount || count == bestCount && name < best {
+				best, bestCount = name, count
+			}
+		}
+		labels = append(labels, best)
+	}
+	return labels
+}
+
+func (a *App) dashboard(now time.Time) (dashboard, error) {
+	d := dashboard{GeneratedAt: now}
+
+	var participants []Participant
+	if err := a.db.Find(&participants).Error; err != nil {
+		return d, err
+	}
+	d.Participants = len(participants)
+
+	var registered []time.Time
+	var forms, sections []string
+	presenting := make(map[string]bool)
+	for _, p := range participants {
+		if t, ok := p.Registered(now); ok {
+			registered = append(registered, t)
+		} else {
+			d.UnknownDates++
+		}
+		forms = append(forms, p.PresentationForm)
+		sections = append(sections, p.PresentationSection)
+		if p.PresentationForm != ListenerPresentationForm && p.PresentationForm != OpenUploadPresentationForm {
+			presenting[p.Token] = true
+		}
+	}
+	d.Registrations = dailySeries(registered, now)
+	d.Forms = breakdown(forms, 0)
+	d.Sections = breakdown(sections, 0)
+	d.Organizations = breakdown(organizationLabels(participants), dashboardTopOrganizations)
+
+	var submissions []Submission
+	if err := a.db.Select("token", "type", "created_at").Where("artifact = ''").Find(&submissions).Error; err != nil {
+		return d, err
+	}
+	firstUploads := make(map[paperKey]time.Time)
+	for _, s := range submissions {
+		key := paperKey{s.Token, s.Type}
+		if first, ok := firstUploads[key]; !ok || s.CreatedAt.Before(first) {
+			firstUploads[key] = s.CreatedAt
+		}
+	}
+
+	for _, t := range dashboardUploadTypes {
+		u := dashboardUploads{Type: t, Label: uploadTypeLabel(t), Expected: len(presenting)}
+		if deadline, ok := a.config.Deadlines[t]; ok {
+			u.Deadline = &deadline
+			u.DaysLeft = int(math.Ceil(deadline.AddDate(0, 0, 1).Sub(now).Hours() / 24))
+		}
+
+		var uploads []time.Time
+		for key, first := range firstUploads {
+			if key.Type != t {
+				continue
+			}
+			uploads = append(uploads, first)
+			if presenting[key.Token] {
+				u.Uploaded++
+			}
+			if a.config.UploadOpen(t, first) {
+				u.OnTime++
+			} else {
+				u.Late++
+			}
+		}
+		u.Rate = percent(u.Uploaded, u.Expected)
+
+		span := []time.Time{now}
+		if u.Deadline != nil {
+			span = append(span, *u.Deadline)
+		}
+		u.Days = dailySeries(uploads, span...)
+
+		d.Uploads = append(d.Uploads, u)
+	}
+
+	statuses, err := a.paperStatuses("")
+	if err != nil {
+		return d, err
+	}
+	var current []string
+	for key := range firstUploads {
+		status, ok := statuses[key]
+		if !ok {
+			status.Status = StatusReceived
+		}
+		current = append(current, StatusLabels[status.Status])
+	}
+	d.Statuses = breakdown(current, 0)
+
+	d.Mail.Subjects = []dashboardMailSubject{}
+	if err := a.db.Model(&EmailLog{}).
+		Select("subject, SUM(CASE WHEN error = '' THEN 1 ELSE 0 END) AS sent, SUM(CASE WHEN error <> '' THEN 1 ELSE 0 END) AS failed").
+		Group("subject").Order("COUNT(*) DESC").Scan(&d.Mail.Subjects).Error; err != nil {
+		return d, err
+	}
+	for _, s := range d.Mail.Subjects {
+		d.Mail.Sent += s.Sent
+		d.Mail.Failed += s.Failed
+	}
+	d.Mail.Rate = percent(d.Mail.Sent, d.Mail.Sent+d.Mail.Failed)
+
+	return d, nil
+}
+
+// Chart sizes in SVG units.
+const (
+	chartWidth  = 720
+	chartHeight = 200
+	chartLeft   = 36
+	chartBottom = 24
+	chartTop    = 8
+	barWidth    = 240
+)
+
+type chartBar struct {
+	X, Y, Width, Height float64
+	Title               string
+}
+
+type chartLabel struct {
+	X, Y  float64
+	Label string
+}
+
+// timeChart draws daily counts as bars and the running total as a line
+// scaled to the full height.
+type timeChart struct {
+	Width, Height float64
+	Bars          []chartBar
+	Line          string
+	Ticks         []chartLabel
+	Axis          []chartLabel
+	Total         int
+	// Marker is the deadline, Y is unused.
+	Marker *chartLabel
+}
+
+func newTimeChart(days []dashboardDay, marker *time.Time) timeChart {
+	chart := timeChart{Width: chartWidth, Height: chartHeight}
+	if len(days) == 0 {
+		return chart
+	}
+
+	maxCount := 1
+	for _, d := range days {
+		if d.Count > maxCount {
+			maxCount = d.Count
+		}
+	}
+	chart.Total = days[len(days)-1].Total
+
+	plotWidth := float64(chartWidth - chartLeft)
+	plotHeight := float64(chartHeight - chartBottom - chartTop)
+	step := plotWidth / float64(len(days))
+	bottom := float64(chartHeight - chartBottom)
+
+	points := make([]string, 0, len(days))
+	for i, d := range days {
+		x := chartLeft + float64(i)*step
+		h := plotHeight * float64(d.Count) / float64(maxCount)
+		chart.Bars = append(chart.Bars, chartBar{
+			X: x + step*0.1, Y: bottom - h, Width: step * 0.8, Height: h,
+			Title: fmt.Sprintf("%s: %d, %d in total", d.Date, d.Count, d.Total),
+		})
+
+		y := bottom
+		if chart.Total > 0 {
+			y -= plotHeight * float64(d.Total) / float64(chart.Total)
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x+step/2, y))
+	}
+	chart.Line = strings.Join(points, " ")
+
+	every := (len(days) + 5) / 6
+	for i := 0; i < len(days); i += every {
+		chart.Ticks = append(chart.Ticks, chartLabel{X: chartLeft + float64(i)*step, Y: chartHeight - 6, Label: days[i].Date[5:]})
+	}
+	chart.Axis = []chartLabel{
+		{X: chartLeft - 4, Y: chartTop + 10, Label: strconv.Itoa(maxCount)},
+		{X: chartLeft - 4, Y: bottom, Label: "0"},
+	}
+
+	if marker != nil {
+		first, err := time.ParseInLocation(RegistrationDateLayout, days[0].Date, ConferenceLocation)
+		if err == nil {
+			i := math.Round(marker.Sub(first).Hours() / 24)
+			if i >= 0 && int(i) < len(days) {
+				chart.Marker = &chartLabel{X: chartLeft + (i+1)*step, Label: "Deadline"}
+			}
+		}
+	}
+
+	return chart
+}
+
+// barRow is a row of a breakdown with the length of its bar.
+type barRow struct {
+	dashboardCount
+	Width float64
+}
+
+func newBarRows(counts []dashboardCount) []barRow {
+	maxCount := 1
+	for _, c := range counts {
+		if c.Count > maxCount {
+			maxCount = c.Count
+		}
+	}
+
+	rows := make([]barRow, 0, len(counts))
+	for _, c := range counts {
+		rows = append(rows, barRow{c, math.Max(1, barWidth*float64(c.Count)/float64(maxCount))})
+	}
+	return rows
+}
+
+func (a *App) dashboardView(c *fiber.Ctx) error {
+	d, err := a.dashboard(time.Now())
+	if err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	uploadCharts := make([]timeChart, 0, len(d.Uploads))
+	for _, u := range d.Uploads {
+		uploadCharts = append(uploadCharts, newTimeChart(u.Days, u.Deadline))
+	}
+
+	return c.Render("admin-dashboard", fiber.Map{
+		"Title":         "Dashboard",
+		"Dashboard":     d,
+		"Registrations": newTimeChart(d.Registrations, nil),
+		"UploadCharts":  uploadCharts,
+		"Forms":         newBarRows(d.Forms),
+		"Sections":      newBarRows(d.Sections),
+		"Organizations": newBarRows(d.Organizations),
+		"Statuses":      newBarRows(d.Statuses),
+	})
+}
+
+func (a *App) dashboardJSON(c *fiber.Ctx) error {
+	d, err := a.dashboard(time.Now())
+	if err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	return c.JSON(d)
+}
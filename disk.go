@@ -1,12 +1,52 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+var ErrFileNotFound = errors.New("file not found")
+
+// FileInfo describes a stored file. Name is the slash separated path relative
+// to the disk root, the same one passed to Save.
+type FileInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
 type Disk interface {
 	Save(file io.Reader, fileName string) error
+	// Open returns ErrFileNotFound when the file doesn't exist.
+	Open(fileName string) (io.ReadCloser, error)
+	// List returns all files whose names start with prefix, sorted by name.
+	List(prefix string) ([]FileInfo, error)
+	Stat(fileName string) (FileInfo, error)
+	Delete(fileName string) error
+}
+
+// cleanFileName rejects names escaping the disk root.
+func cleanFileName(fileName string) (string, error) {
+	name := path.Clean("/" + fileName)[1:]
+	if name == "" {
+		return "", fmt.Errorf("invalid file name: '%s'", fileName)
+	}
+	for _, part := range strings.FieldsFunc(fileName, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return "", fmt.Errorf("invalid file name: '%s'", fileName)
+		}
+	}
+	return name, nil
 }
 
 type OsDisk struct {
@@ -32,12 +72,30 @@ func NewOsDisk(path string) (*OsDisk, error) {
 	return &OsDisk{Path: path}, nil
 }
 
+func (d *OsDisk) filePath(fileName string) (string, error) {
+	name, err := cleanFileName(fileName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d.Path, filepath.FromSlash(name)), nil
+}
+
 func (d *OsDisk) Save(file io.Reader, fileName string) error {
-	f, err := os.Create(d.Path + "/" + fileName)
+	p, err := d.filePath(fileName)
 	if err != nil {
 		return err
 	}
 
+	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return err
+	}
+
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
 	_, err = io.Copy(f, file)
 	if err != nil {
 		return err
@@ -46,77 +104,240 @@ func (d *OsDisk) Save(file io.Reader, fileName string) error {
 	return nil
 }
 
-func (a *App) saveToDisk(file io.Reader, extention, fileName string) error {
+func (d *OsDisk) Open(fileName string) (io.ReadCloser, error) {
+	p, err := d.filePath(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, fileName)
+	}
+
+	return f, err
+}
+
+func (d *OsDisk) List(prefix string) ([]FileInfo, error) {
+	files := make([]FileInfo, 0)
+
+	err := filepath.WalkDir(d.Path, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(d.Path, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, FileInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk: %w", err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	return files, nil
+}
+
+func (d *OsDisk) Stat(fileName string) (FileInfo, error) {
+	p, err := d.filePath(fileName)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && info.IsDir() {
+		return FileInfo{}, fmt.Errorf("%w: %s", ErrFileNotFound, fileName)
+	}
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	return FileInfo{Name: fileName, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (d *OsDisk) Delete(fileName string) error {
+	p, err := d.filePath(fileName)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, fileName)
+	}
+
+	return err
+}
+
+// MemDisk keeps files in memory. It is meant for tests and local runs
+// without a disk.
+type MemDisk struct {
+	mu    sync.RWMutex
+	files map[string]memFile
+}
+
+type memFile struct {
+	content []byte
+	modTime time.Time
+}
+
+func NewMemDisk() *MemDisk {
+	return &MemDisk{files: make(map[string]memFile)}
+}
+
+func (d *MemDisk) Save(file io.Reader, fileName string) error {
+	name, err := cleanFileName(fileName)
+	if err != nil {
+		return err
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.files[name] = memFile{content: content, modTime: time.Now()}
+
+	return nil
+}
+
+func (d *MemDisk) Open(fileName string) (io.ReadCloser, error) {
+	name, err := cleanFileName(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	f, ok := d.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, fileName)
+	}
+
+	return io.NopCloser(bytes.NewReader(f.content)), nil
+}
+
+func (d *MemDisk) List(prefix string) ([]FileInfo, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	files := make([]FileInfo, 0)
+	for name, f := range d.files {
+		if strings.HasPrefix(name, prefix) {
+			files = append(files, FileInfo{Name: name, Size: int64(len(f.content)), ModTime: f.modTime})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	return files, nil
+}
+
+func (d *MemDisk) Stat(fileName string) (FileInfo, error) {
+	name, err := cleanFileName(fileName)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	f, ok := d.files[name]
+	if !ok {
+		return FileInfo{}, fmt.Errorf("%w: %s", ErrFileNotFound, fileName)
+	}
 
-	return a.disk.Save(file, fileName+"."+extention)
+	return FileInfo{Name: fileName, Size: int64(len(f.content)), ModTime: f.modTime}, nil
 }
 
-// const (
-// 	YandexDiskAPIURL = "https://cloud-api.yandex.net/v1/disk"
-// 	ArticlesFolder   = "Articles"
-// 	AbstractsFolder  = "Abstracts"
-// )
+func (d *MemDisk) Delete(fileName string) error {
+	name, err := cleanFileName(fileName)
+	if err != nil {
+		return err
+	}
 
-// var (
-// 	YandexClientId    = os.Getenv("YANDEX_CLIENT_ID")
-// 	YandexCallbackURL = os.Getenv("YANDEX_CALLBACK_URL")
-// 	YandexOAuthToken  = os.Getenv("YANDEX_OAUTH_TOKEN")
-// )
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-// type UploadURLResponse struct {
-// 	OperationId string `json:"operation_id"`
-// 	URL         string `json:"href"`
-// 	Method      string `json:"method"`
-// }
+	if _, ok := d.files[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, fileName)
+	}
+	delete(d.files, name)
 
-// func (a *App) saveToYandexDisk(file io.Reader, remotePath string) error {
-// 	header := http.Header{}
-// 	header.Add("Authorization", "OAuth "+YandexOAuthToken)
+	return nil
+}
 
-// 	client := http.Client{}
+// MirrorDisk saves every file to Primary and then copies it to Mirror.
+// Mirror failures are logged and don't fail the save. Reads are served by
+// Primary only.
+type MirrorDisk struct {
+	Primary Disk
+	Mirror  Disk
+	log     *Logger
+}
 
-// 	getUploadURLRequest, err := http.NewRequest("GET",
-// 		fmt.Sprintf("%s/resources/upload?path=%s&overwrite=true", YandexDiskAPIURL, remotePath), nil)
-// 	if err != nil {
-// 		a.log.Error(err)
-// 		return err
-// 	}
+func NewMirrorDisk(primary, mirror Disk, log *Logger) *MirrorDisk {
+	return &MirrorDisk{Primary: primary, Mirror: mirror, log: log}
+}
 
-// 	getUploadURLRequest.Header = header
+func (d *MirrorDisk) Save(file io.Reader, fileName string) error {
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
 
-// 	response, err := client.Do(getUploadURLRequest)
-// 	if err != nil {
-// 		a.log.Error(err)
-// 		return err
-// 	}
+	if err := d.Primary.Save(bytes.NewReader(content), fileName); err != nil {
+		return err
+	}
 
-// 	defer response.Body.Close()
+	if err := d.Mirror.Save(bytes.NewReader(content), fileName); err != nil {
+		d.log.Errorf("Can't mirror file '%s': %v", fileName, err)
+	}
 
-// 	var getUploadURLResponse UploadURLResponse
-// 	if err := json.NewDecoder(response.Body).Decode(&getUploadURLResponse); err != nil {
-// 		a.log.Error(err)
-// 		a.log.Debug(getUploadURLResponse)
-// 		return err
-// 	}
+	return nil
+}
 
-// 	a.log.Debug(getUploadURLResponse)
+func (d *MirrorDisk) Open(fileName string) (io.ReadCloser, error) {
+	return d.Primary.Open(fileName)
+}
 
-// 	uploadFileRequest, err := http.NewRequest(getUploadURLResponse.Method, getUploadURLResponse.URL, file)
-// 	if err != nil {
-// 		a.log.Error(uploadFileRequest)
-// 		return err
-// 	}
+func (d *MirrorDisk) List(prefix string) ([]FileInfo, error) {
+	return d.Primary.List(prefix)
+}
 
-// 	uploadFileRequest.Header = header
+func (d *MirrorDisk) Stat(fileName string) (FileInfo, error) {
+	return d.Primary.Stat(fileName)
+}
 
-// 	response, err = client.Do(uploadFileRequest)
-// 	if err != nil {
-// 		a.log.Error(err)
-// 		return err
-// 	}
-// 	a.log.Debug(response)
+func (d *MirrorDisk) Delete(fileName string) error {
+	if err := d.Primary.Delete(fileName); err != nil {
+		return err
+	}
 
-// 	defer response.Body.Close()
+	if err := d.Mirror.Delete(fileName); err != nil && !errors.Is(err, ErrFileNotFound) {
+		d.log.Errorf("Can't delete mirrored file '%s': %v", fileName, err)
+	}
 
-// 	return nil
-// }
+	return nil
+}
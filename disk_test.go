@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestCleanFileName(t *testing.T) {
+	tests := []struct {
+		name, want string
+		ok         bool
+	}{
+		{"tezis/a.docx", "tezis/a.docx", true},
+		{"/tezis//a.docx", "tezis/a.docx", true},
+		{"./tezis/a.docx", "tezis/a.docx", true},
+		{"tezis/a..docx", "tezis/a..docx", true},
+		{"..docx", "..docx", true},
+		{"", "", false},
+		{"/", "", false},
+		{"..", "", false},
+		{"../a.docx", "", false},
+		{"tezis/../../a.docx", "", false},
+		{`tezis\..\a.docx`, "", false},
+	}
+
+	for _, tt := range tests {
+		got, err := cleanFileName(tt.name)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("cleanFileName(%q) = %q, %v; want %q, ok %v", tt.name, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+// testDisk checks the behaviour every Disk shares.
+func testDisk(t *testing.T, d Disk) {
+	for _, name := range []string{"tezis/c.docx", "article/b.pdf", "tezis/a.docx", "tezis.docx"} {
+		if err := d.Save(strings.NewReader(name), name); err != nil {
+			t.Fatalf("Save(%q): %v", name, err)
+		}
+	}
+
+	file, err := d.Open("/tezis//a.docx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(file)
+	file.Close()
+	if string(content) != "tezis/a.docx" {
+		t.Errorf("opened %q, want %q", content, "tezis/a.docx")
+	}
+
+	if err := d.Save(strings.NewReader("v2"), "tezis/a.docx"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := d.Stat("tezis/a.docx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 2 {
+		t.Errorf("size after overwrite is %d, want 2", info.Size)
+	}
+
+	files, err := d.List("tezis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	if got, want := strings.Join(names, " "), "tezis.docx tezis/a.docx tezis/c.docx"; got != want {
+		t.Errorf("List(\"tezis\") = %s, want %s", got, want)
+	}
+
+	if err := d.Delete("./tezis/c.docx"); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		func() error { _, err := d.Open("tezis/c.docx"); return err }(),
+		func() error { _, err := d.Stat("tezis/c.docx"); return err }(),
+		d.Delete("tezis/c.docx"),
+	} {
+		if !errors.Is(err, ErrFileNotFound) {
+			t.Errorf("error for a deleted file is %v, want ErrFileNotFound", err)
+		}
+	}
+	if _, err := d.Stat("tezis"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Stat of a folder is %v, want ErrFileNotFound", err)
+	}
+
+	for _, name := range []string{"../a.docx", "tezis/../../a.docx", ""} {
+		if err := d.Save(strings.NewReader("x"), name); err == nil || errors.Is(err, ErrFileNotFound) {
+			t.Errorf("Save(%q) error is %v, want invalid name", name, err)
+		}
+		if _, err := d.Open(name); err == nil || errors.Is(err, ErrFileNotFound) {
+			t.Errorf("Open(%q) error is %v, want invalid name", name, err)
+		}
+	}
+}
+
+func TestMemDisk(t *testing.T) {
+	testDisk(t, NewMemDisk())
+}
+
+func TestOsDisk(t *testing.T) {
+	d, err := NewOsDisk(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDisk(t, d)
+}
+
+// failingDisk fails every call.
+type failingDisk struct{ Disk }
+
+var errDiskDown = errors.New("disk is down")
+
+func (failingDisk) Save(io.Reader, string) error { return errDiskDown }
+func (failingDisk) Delete(string) error          { return errDiskDown }
+
+func TestMirrorDisk(t *testing.T) {
+	log := &Logger{zap.NewNop().Sugar()}
+
+	primary, mirror := NewMemDisk(), NewMemDisk()
+	d := NewMirrorDisk(primary, mirror, log)
+	testDisk(t, d)
+
+	files, err := mirror.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("mirror has %d files, want 3", len(files))
+	}
+
+	// A failing mirror doesn't fail saves and deletes.
+	d = NewMirrorDisk(NewMemDisk(), failingDisk{}, log)
+	if err := d.Save(strings.NewReader("x"), "a.docx"); err != nil {
+		t.Errorf("Save with a failing mirror: %v", err)
+	}
+	if err := d.Delete("a.docx"); err != nil {
+		t.Errorf("Delete with a failing mirror: %v", err)
+	}
+
+	// A failing primary does.
+	d = NewMirrorDisk(failingDisk{}, NewMemDisk(), log)
+	if err := d.Save(strings.NewReader("x"), "a.docx"); !errors.Is(err, errDiskDown) {
+		t.Errorf("Save with a failing primary is %v, want errDiskDown", err)
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/360EntSecGroup-Skylar/excelize"
+	"github.com/gofiber/fiber/v2"
+)
+
+// exportColumn is a selectable column of participant exports, Key is also
+// the field name in JSON.
+type exportColumn struct {
+	Key   string
+	Label string
+	Value func(r participantRow) string
+}
+
+var exportColumns = []exportColumn{
+	{"registered", "Registered at", func(r participantRow) string { return r.CreatedAt }},
+	{"surname", "Surname", func(r participantRow) string { return r.Surname }},
+	{"name", "Name", func(r participantRow) string { return r.Name }},
+	{"organization", "Organization", func(r participantRow) string { return r.Organization }},
+	{"position", "Position", func(r participantRow) string { return r.Position }},
+	{"phone", "Phone", func(r participantRow) string { return r.Phone }},
+	{"email", "Email", func(r participantRow) string { return r.Email }},
+	{"form", "Presentation form", func(r participantRow) string { return r.PresentationForm }},
+	{"section", "Presentation section", func(r participantRow) string { return r.PresentationSection }},
+	{"title", "Presentation title", func(r participantRow) string { return r.PresentationTitle }},
+	{"code", "Code", func(r participantRow) string { return r.Token }},
+	{"uploads", "Uploads", func(r participantRow) string {
+		uploads := make([]string, 0, len(r.Papers))
+		for _, p := range r.Papers {
+			uploads = append(uploads, fmt.Sprintf("%s v%d", p.Label, p.Versions))
+		}
+		return strings.Join(uploads, "; ")
+	}},
+	{"status", "Status", func(r participantRow) string {
+		statuses := make([]string, 0, len(r.Papers))
+		for _, p := range r.Papers {
+			statuses = append(statuses, p.Label+": "+p.StatusLabel())
+		}
+		return strings.Join(statuses, "; ")
+	}},
+}
+
+var exportFormats = []string{"xlsx", "csv", "json"}
+
+// utf8BOM makes Excel open CSV files as UTF-8 rather than the ANSI code page.
+const utf8BOM = "\ufeff"
+
+// xlsxFrozenHeader freezes the first row of a sheet.
+const xlsxFrozenHeader = `{"freeze":true,"split":false,"x_split":0,"y_split":1,"top_left_cell":"A2","active_pane":"bottomLeft","panes":[{"sqref":"A2","active_cell":"A2","pane":"bottomLeft"}]}`
+
+func exportColumnByKey(key string) (exportColumn, bool) {
+	for _, c := range exportColumns {
+		if c.Key == key {
+			return c, true
+		}
+	}
+	return exportColumn{}, false
+}
+
+// ExportOptions are the participants, the columns and the format of an
+// export. Filter is the participant table filter, paging aside.
+type ExportOptions struct {
+	Filter  ParticipantFilter
+	Format  string
+	Columns []string
+}
+
+func parseExportOptions(c *fiber.Ctx) ExportOptions {
+	o := ExportOptions{Filter: parseParticipantFilter(c), Format: c.Query("format")}
+	if !contains(exportFormats, o.Format) {
+		o.Format = exportFormats[0]
+	}
+
+	for _, key := range c.Context().QueryArgs().PeekMulti("field") {
+		if _, ok := exportColumnByKey(string(key)); ok && !contains(o.Columns, string(key)) {
+			o.Columns = append(o.Columns, string(key))
+		}
+	}
+
+	return o.withDefaults()
+}
+
+// withDefaults exports all columns when none are selected.
+func (o ExportOptions) withDefaults() ExportOptions {
+	if o.Format == "" {
+		o.Format = exportFormats[0]
+	}
+	if len(o.Columns) == 0 {
+		for _, c := range exportColumns {
+			o.Columns = append(o.Columns, c.Key)
+		}
+	}
+	return o
+}
+
+func (o ExportOptions) FileName() string {
+	return "AMTC_2022_Participants." + o.Format
+}
+
+func (o ExportOptions) columns() []exportColumn {
+	columns := make([]exportColumn, 0, len(o.Columns))
+	for _, key := range o.Columns {
+		if column, ok := exportColumnByKey(key); ok {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// ExportValues are the filter and the order of the table without paging
+// and columns, for the export form.
+func (f ParticipantFilter) ExportValues() url.Values {
+	f.Page = 1
+	f.PerPage = participantPageSizes[0]
+	f.Columns = defaultParticipantColumns
+	return f.values()
+}
+
+// exportParticipants writes the participants of the filter in the table
+// order.
+func (a *App) exportParticipants(w io.Writer, o ExportOptions) error {
+	var participants []Participant
+	if err := a.orderedParticipantQuery(o.Filter).Find(&participants).Error; err != nil {
+		return err
+	}
+	rows, err := a.participantRows(participants)
+	if err != nil {
+		return err
+	}
+
+	columns := o.columns()
+
+	switch o.Format {
+	case "csv":
+		if _, err := io.WriteString(w, utf8BOM); err != nil {
+			return err
+		}
+		cw := csv.NewWriter(w)
+		header := make([]string, 0, len(columns))
+		for _, c := range columns {
+			header = append(header, c.Label)
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		for _, r := range rows {
+			record := make([]string, 0, len(columns))
+			for _, c := range columns {
+				record = append(record, spreadsheetText(c.Value(r)))
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		records := make([]map[string]string, 0, len(rows))
+		for _, r := range rows {
+			record := make(map[string]string, len(columns))
+			for _, c := range columns {
+				record[c.Key] = c.Value(r)
+			}
+			records = append(records, record)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "xlsx":
+		document, err := a.participantWorkbook(rows, columns)
+		if err != nil {
+			return err
+		}
+		return document.Write(w)
+	default:
+		return fmt.Errorf("unknown format %q, use %s", o.Format, strings.Join(exportFormats, ", "))
+	}
+}
+
+// spreadsheetText keeps a CSV value from being run as a formula when the
+// file is opened in a spreadsheet, registration values are typed by anyone.
+func spreadsheetText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// cellName is the name of a cell by zero based column and one based row,
+// "AA1" for 26 and 1.
+func cellName(column, row int) string {
+	return excelize.ToAlphaString(column) + strconv.Itoa(row)
+}
+
+// writeSheet fills a sheet with a frozen header row and an autofilter over
+// the table. Strings are written as string cells, never as formulas.
+func writeSheet(document *excelize.File, sheet string, header []string, rows [][]interface{}, headerStyle int) error {
+	for i, h := range header {
+		document.SetCellStr(sheet, cellName(i, 1), h)
+	}
+	for i, row := range rows {
+		for j, v := range row {
+			if s, ok := v.(string); ok {
+				document.SetCellStr(sheet, cellName(j, i+2), s)
+			} else {
+				document.SetCellValue(sheet, cellName(j, i+2), v)
+			}
+		}
+	}
+
+	last := cellName(len(header)-1, len(rows)+1)
+	document.SetCellStyle(sheet, "A1", cellName(len(header)-1, 1), headerStyle)
+	document.SetColWidth(sheet, "A", excelize.ToAlphaString(len(header)-1), 20)
+	document.SetPanes(sheet, xlsxFrozenHeader)
+
+	return document.AutoFilter(sheet, "A1", last, "")
+}
+
+// participantWorkbook builds the participants sheet of the columns, the
+// submissions of the participants and a summary of them by section.
+func (a *App) participantWorkbook(rows []participantRow, columns []exportColumn) (*excelize.File, error) {
+	document := excelize.NewFile()
+
+	headerStyle, err := document.NewStyle(`{"font":{"bold":true},"fill":{"type":"pattern","color":["#F3F4F6"],"pattern":1}}`)
+	if err != nil {
+		return nil, err
+	}
+
+	const participantsSheet, submissionsSheet, sectionsSheet = "Participants", "Submissions", "Sections"
+	document.SetSheetName("Sheet1", participantsSheet)
+	document.NewSheet(submissionsSheet)
+	document.NewSheet(sectionsSheet)
+
+	header := make([]string, 0, len(columns))
+	for _, c := range columns {
+		header = append(header, c.Label)
+	}
+	values := make([][]interface{}, 0, len(rows))
+	for _, r := range rows {
+		row := make([]interface{}, 0, len(columns))
+		for _, c := range columns {
+			row = append(row, c.Value(r))
+		}
+		values = append(values, row)
+	}
+	if err := writeSheet(document, participantsSheet, header, values, headerStyle); err != nil {
+		return nil, err
+	}
+
+	submissions, err := a.submissionRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSheet(document, submissionsSheet, []string{
+		"Code", "Surname", "Name", "Type", "Item", "Version", "Uploaded at", "File", "Size, bytes", "Pages", "Words", "Compliance", "Status",
+	}, submissions, headerStyle); err != nil {
+		return nil, err
+	}
+
+	sections, err := a.sectionSummaryRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSheet(document, sectionsSheet, []string{
+		"Section", "Participants", "Speakers", "Listeners", "Abstracts", "Full papers", "Accepted papers", "Scheduled talks",
+	}, sections, headerStyle); err != nil {
+		return nil, err
+	}
+
+	document.SetActiveSheet(document.GetSheetIndex(participantsSheet))
+
+	return document, nil
+}
+
+func participantTokens(rows []participantRow) []string {
+	tokens := make([]string, 0, len(rows))
+	for _, r := range rows {
+		tokens = append(tokens, r.Token)
+	}
+	return tokens
+}
+
+// submissionRows lists every uploaded version of the participants.
+func (a *App) submissionRows(rows []participantRow) ([][]interface{}, error) {
+	values := [][]interface{}{}
+	if len(rows) == 0 {
+		return values, nil
+	}
+
+	var submissions []Submission
+	if err := a.db.Where("token IN ?", participantTokens(rows)).Order("token, type, artifact, version").Find(&submissions).Error; err != nil {
+		return nil, err
+	}
+
+	participants := make(map[string]participantRow, len(rows))
+	for _, r := range rows {
+		participants[r.Token] = r
+	}
+
+	for _, s := range submissions {
+		r := participants[s.Token]
+
+		item, status := s.Artifact, ""
+		if item == "" {
+			item = "Paper"
+			for _, p := range r.Papers {
+				if p.Type == s.Type {
+					status = p.StatusLabel()
+				}
+			}
+		}
+
+		compliance := "Not checked"
+		if s.ComplianceChecked {
+			compliance = "OK"
+			if warnings := s.Warnings(); len(warnings) > 0 {
+				compliance = strings.Join(warnings, "; ")
+			}
+		}
+
+		values = append(values, []interface{}{
+			s.Token, r.Surname, r.Name, uploadTypeLabel(s.Type), item, s.Version, s.CreatedAt.Format("2006-01-02 15:04"),
+			s.OriginalName, s.Size, s.Pages, s.Words, compliance, status,
+		})
+	}
+
+	return values, nil
+}
+
+// sectionSummaryRows counts the participants by presentation section, in
+// the order of the programme.
+func (a *App) sectionSummaryRows(rows []participantRow) ([][]interface{}, error) {
+	scheduled := make(map[string]bool)
+	if len(rows) > 0 {
+		var tokens []string
+		if err := a.db.Model(&Slot{}).Where("participant_token IN ?", participantTokens(rows)).Distinct().Pluck("participant_token", &tokens).Error; err != nil {
+			return nil, err
+		}
+		for _, token := range tokens {
+			scheduled[token] = true
+		}
+	}
+
+	type summary struct {
+		participants, speakers, listeners, abstracts, papers, accepted, scheduled int
+	}
+	summaries := make(map[string]*summary)
+	for _, r := range rows {
+		section := r.PresentationSection
+		if section == "" {
+			section = "Not set"
+		}
+		s := summaries[section]
+		if s == nil {
+			s = new(summary)
+			summaries[section] = s
+		}
+
+		s.participants++
+		if strings.Contains(r.PresentationForm, "Speaker") {
+			s.speakers++
+		}
+		if r.PresentationForm == ListenerPresentationForm {
+			s.listeners++
+		}
+		for _, p := range r.Papers {
+			switch p.Type {
+			case "tezis":
+				s.abstracts++
+			case "article":
+				s.papers++
+			}
+			if p.Status == StatusAccepted {
+				s.accepted++
+			}
+		}
+		if scheduled[r.Token] {
+			s.scheduled++
+		}
+	}
+
+	sessions := RegistrationPageContent["ConferenceSessions"].([]string)
+	sections := make([]string, 0, len(summaries))
+	for section := range summaries {
+		sections = append(sections, section)
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		if x, y := sessionIndex(sessions, sections[i]), sessionIndex(sessions, sections[j]); x != y {
+			return x < y
+		}
+		return sections[i] < sections[j]
+	})
+
+	values := make([][]interface{}, 0, len(sections))
+	for _, section := range sections {
+		s := summaries[section]
+		values = append(values, []interface{}{section, s.participants, s.speakers, s.listeners, s.abstracts, s.papers, s.accepted, s.scheduled})
+	}
+
+	return values, nil
+}
+
+func (a *App) exportParticipantsFile(c *fiber.Ctx) error {
+	o := parseExportOptions(c)
+
+	var buf bytes.Buffer
+	if err := a.exportParticipants(&buf, o); err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	a.audit(c, "export.participants", o.FileName(), nil, nil)
+	c.Set("Content-Description", "File Transfer")
+	c.Set("Content-Disposition", "attachment; filename="+o.FileName())
+	switch o.Format {
+	case "csv":
+		c.Type("csv")
+	case "json":
+		c.Type("json")
+	default:
+		c.Type("xlsx")
+	}
+	c.Status(fiber.StatusOK)
+	return c.Send(buf.Bytes())
+}
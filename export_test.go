@@ -0,0 +1,63 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestExportFormulas(t *testing.T) {
+	a := newTestApp(t)
+	formula := `=HYPERLINK("http://evil.example","Open")`
+	participant := Participant{Token: "anna", Surname: formula, Name: "@SUM(A1)", Organization: "-1+2", Phone: "+7 999 000-00-00", Email: "anna@example.com"}
+	if err := a.db.Create(&participant).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	o := ExportOptions{Format: "csv", Filter: ParticipantFilter{Sort: "name"}}.withDefaults()
+	if err := a.exportParticipants(&out, o); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(out.String(), utf8BOM))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records[1:] {
+		for _, v := range record {
+			if v != "" && strings.ContainsRune("=+-@", rune(v[0])) {
+				t.Errorf("CSV value %q starts a formula", v)
+			}
+		}
+	}
+	if !strings.Contains(out.String(), "'"+`=HYPERLINK(""http://evil.example"",""Open"")`) {
+		t.Errorf("CSV lost the value: %s", out.String())
+	}
+
+	out.Reset()
+	o.Format = "xlsx"
+	if err := a.exportParticipants(&out, o); err != nil {
+		t.Fatal(err)
+	}
+	document, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range document.File {
+		if !strings.HasPrefix(f.Name, "xl/worksheets/") {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sheet, _ := io.ReadAll(r)
+		r.Close()
+		if bytes.Contains(sheet, []byte("<f>")) || bytes.Contains(sheet, []byte("<f ")) {
+			t.Errorf("%s has formulas", f.Name)
+		}
+	}
+}
@@ -7,24 +7,62 @@ import (
 	"fmt"
 	"io"
 	"net/mail"
-	"os"
-	"path/filepath"
+	"net/url"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/360EntSecGroup-Skylar/excelize"
 	emailverifier "github.com/AfterShip/email-verifier"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 const (
 	ErrorMessage   = "Some form fields are entered incorrectly. Change them and try again."
 	SuccessMessage = "Seccessfully registered for AMTC 2022!"
+
+	// OpenUploadPresentationForm marks participants created by an open upload.
+	OpenUploadPresentationForm = "Open upload"
+)
+
+var (
+	phoneRe     = regexp.MustCompile(`^((8|\+7)[\- ]?)?(\(?\d{3}\)?[\- ]?)?[\d\- ]{7,10}$`)
+	latinNameRe = regexp.MustCompile(`^[a-zA-Z]+$`)
 )
 
+// validateParticipant checks a participant with the rules of the
+// registration form and returns messages by field. verifyEmail checks the
+// email exists.
+func validateParticipant(p Participant, verifyEmail func(string) error) map[string]string {
+	formErrors := make(map[string]string)
+
+	if p.Phone != "" && !phoneRe.MatchString(p.Phone) {
+		formErrors["Phone"] = "Phone number should be valid format."
+	}
+	if !latinNameRe.MatchString(p.Surname) {
+		formErrors["Surname"] = "Surname can only be a-zA-Z."
+	}
+	if !latinNameRe.MatchString(p.Name) {
+		formErrors["Name"] = "Name can only be a-zA-Z."
+	}
+	if _, err := mail.ParseAddress(p.Email); err != nil {
+		formErrors["Email"] = "Wrong email format. Example: mail@example.com"
+	} else if err := verifyEmail(p.Email); err != nil {
+		formErrors["Email"] = "Email does not exists."
+	}
+
+	return formErrors
+}
+
+// verifyEmail looks the domain of the email up.
+func verifyEmail(email string) error {
+	_, err := emailverifier.NewVerifier().Verify(email)
+	return err
+}
+
 func (a *App) registerNewParticipant(c *fiber.Ctx) error {
 	participant := Participant{
 		Surname:             c.FormValue("surname"),
@@ -38,7 +76,7 @@ func (a *App) registerNewParticipant(c *fiber.Ctx) error {
 		PresentationTitle:   c.FormValue("presentation-title"),
 	}
 
-	participant.CreatedAt = time.Now().Format("01-02-2002")
+	participant.CreatedAt = time.Now().In(ConferenceLocation).Format(RegistrationDateLayout)
 
 	formErrors := make(map[string]string)
 
@@ -55,29 +93,8 @@ func (a *App) registerNewParticipant(c *fiber.Ctx) error {
 		}
 	}
 
-	// Validate phone
-	val, err := regexp.MatchString(`^((8|\+7)[\- ]?)?(\(?\d{3}\)?[\- ]?)?[\d\- ]{7,10}$`, participant.Phone)
-	if err != nil && participant.Phone != "" || !val && participant.Phone != "" {
-		formErrors["Phone"] = "Phone number should be valid format."
-	}
-	//Validate surname
-	val, err = regexp.MatchString(`^[a-zA-Z]+$`, participant.Surname)
-	if err != nil || !val {
-		formErrors["Surname"] = "Surname can only be a-zA-Z."
-	}
-	//validate name
-	val, err = regexp.MatchString(`^[a-zA-Z]+$`, participant.Name)
-	if err != nil || !val {
-		formErrors["Name"] = "Name can only be a-zA-Z."
-	}
-	//validate email
-	if _, err := mail.ParseAddress(participant.Email); err != nil {
-		formErrors["Email"] = "Wrong email format. Example: mail@example.com"
-	}
-
-	verifier := emailverifier.NewVerifier()
-	if _, err = verifier.Verify(participant.Email); err != nil {
-		formErrors["Email"] = "Email does not exists."
+	for field, message := range validateParticipant(participant, verifyEmail) {
+		formErrors[field] = message
 	}
 
 	data := fiber.Map{}
@@ -95,7 +112,8 @@ func (a *App) registerNewParticipant(c *fiber.Ctx) error {
 
 		if err := a.sendEmail(
 			To{strings.Join([]string{participant.Name, participant.Surname}, " "), participant.Email},
-			Message{AfterRegistrationEmail.Subject, fmt.Sprintf(AfterRegistrationEmail.Text, a.config.Domain)},
+			Message{AfterRegistrationEmail.Subject, fmt.Sprintf(AfterRegistrationEmail.Text, a.config.Domain, a.config.Domain, participant.Token)},
+			a.conferenceCalendar(),
 		); err != nil {
 			a.log.Errorf("Can't send email to %s: %w", participant.Email, err.Error())
 		}
@@ -110,64 +128,6 @@ func (a *App) registerNewParticipant(c *fiber.Ctx) error {
 	return c.Render("registration", data)
 }
 
-func (a *App) createExcelFile() (*bytes.Buffer, error) {
-	var participants []Participant
-
-	result := a.db.Find(&participants)
-	a.log.Debug(result)
-	if err := result.Error; err != nil {
-		return &bytes.Buffer{}, err
-	}
-
-	headers := []string{
-		"Registred at",
-		"Name",
-		"Surname",
-		"Organization",
-		"Position",
-		"Phone",
-		"Email",
-		"Presentation Form",
-		"Presentation Section",
-		"Presentation Title",
-		"Code",
-	}
-
-	document := excelize.NewFile()
-
-	sheetName := "AMTC_2022_Participants"
-	_ = document.NewSheet(sheetName)
-	document.DeleteSheet("Sheet1")
-
-	for i, h := range headers {
-		cellId := string([]byte{uint8(65 + i)}) + "1"
-		fmt.Println(cellId)
-		document.SetCellValue(sheetName, cellId, h)
-	}
-
-	for i, participan := range participants {
-		rowIndex := strconv.Itoa(i + 2)
-		document.SetCellValue(sheetName, "A"+rowIndex, participan.CreatedAt)
-		document.SetCellValue(sheetName, "B"+rowIndex, participan.Name)
-		document.SetCellValue(sheetName, "C"+rowIndex, participan.Surname)
-		document.SetCellValue(sheetName, "D"+rowIndex, participan.Organization)
-		document.SetCellValue(sheetName, "E"+rowIndex, participan.Position)
-		document.SetCellValue(sheetName, "F"+rowIndex, participan.Phone)
-		document.SetCellValue(sheetName, "G"+rowIndex, participan.Email)
-		document.SetCellValue(sheetName, "H"+rowIndex, participan.PresentationForm)
-		document.SetCellValue(sheetName, "I"+rowIndex, participan.PresentationSection)
-		document.SetCellValue(sheetName, "J"+rowIndex, participan.PresentationTitle)
-		document.SetCellValue(sheetName, "K"+rowIndex, participan.Token)
-	}
-
-	var buf bytes.Buffer
-	if err := document.Write(&buf); err != nil {
-		return &bytes.Buffer{}, err
-	}
-
-	return &buf, nil
-}
-
 func (a *App) downloadFiles(c *fiber.Ctx) error {
 	fileType := c.Params("file")
 
@@ -181,16 +141,18 @@ func (a *App) downloadFiles(c *fiber.Ctx) error {
 
 	switch fileType {
 	case "participants":
-		file, err = a.createExcelFile()
-		fileName = fmt.Sprintf("AMTC_2022_%s.%s", "Paticipants", "xlsx")
+		o := ExportOptions{Filter: ParticipantFilter{Sort: "name"}}.withDefaults()
+		file = new(bytes.Buffer)
+		err = a.exportParticipants(file, o)
+		fileName = o.FileName()
 	case "article":
-		file, err = createZipArchive(a.config.DiskPath + "/" + fileType)
+		file, err = a.createZipArchive(fileType)
 		fileName = fmt.Sprintf("AMTC_2022_%s.%s", "Articles", "zip")
 	case "tezis":
-		file, err = createZipArchive(a.config.DiskPath + "/" + fileType)
+		file, err = a.createZipArchive(fileType)
 		fileName = fmt.Sprintf("AMTC_2022_%s.%s", "Tezisi", "zip")
 	case "open-upload":
-		file, err = createZipArchive(a.config.DiskPath + "/" + fileType)
+		file, err = a.createZipArchive(fileType)
 		fileName = fmt.Sprintf("AMTC_2022_%s.%s", "Open-upload", "zip")
 	// case "all":
 	// 	file, err = createZipArchive(a.config.DiskPath)
@@ -210,56 +172,89 @@ func (a *App) downloadFiles(c *fiber.Ctx) error {
 			},
 		})
 	}
+	a.audit(c, "export."+fileType, fileName, nil, nil)
 	c.Set("Content-Description", "File Transfer")
 	c.Set("Content-Disposition", "attachment; filename="+fileName)
 	c.Status(fiber.StatusOK)
 	return c.SendStream(file)
 }
 
-func createZipArchive(src string) (*bytes.Buffer, error) {
-	buf := new(bytes.Buffer)
+// createZipArchive packs all files stored under the folder dir.
+func (a *App) createZipArchive(dir string) (*bytes.Buffer, error) {
+	files, err := a.disk.List(dir + "/")
+	if err != nil {
+		return nil, fmt.Errorf("list: %w", err)
+	}
 
+	buf := new(bytes.Buffer)
 	zw := zip.NewWriter(buf)
-	defer zw.Close()
 
-	walker := func(path string, info os.FileInfo, err error) error {
-		fmt.Printf("Crawling: %#v\n", path)
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		file, err := os.Open(path)
-		if err != nil {
-			return err
+	for _, info := range files {
+		if err := a.addToZip(zw, info, strings.TrimPrefix(info.Name, dir+"/")); err != nil {
+			return nil, err
 		}
-		defer file.Close()
+	}
 
-		// Ensure that `path` is not absolute; it should not start with "/".
-		// This snippet happens to work because I don't use
-		// absolute paths, but ensure your real-world code
-		// transforms path into a zip-root relative path.
-		p := strings.TrimLeft(path, src)
-		fmt.Println(path, p)
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
 
-		f, err := zw.Create(p)
-		if err != nil {
-			return err
-		}
+	return buf, nil
+}
 
-		_, err = io.Copy(f, file)
-		if err != nil {
-			return err
-		}
+func (a *App) addToZip(zw *zip.Writer, info FileInfo, name string) error {
+	file, err := a.disk.Open(info.Name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: info.ModTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, file)
+
+	return err
+}
 
-		return nil
+func (a *App) downloadStoredFile(c *fiber.Ctx) error {
+	name := c.Params("*")
+
+	info, err := a.disk.Stat(name)
+	if err != nil {
+		a.log.Error(err)
+		return c.Redirect("/404")
 	}
-	if err := filepath.Walk(src, walker); err != nil {
-		return nil, fmt.Errorf("walk: %w", err)
+
+	file, err := a.disk.Open(info.Name)
+	if err != nil {
+		a.log.Error(err)
+		return c.Redirect("/404")
 	}
 
-	return buf, nil
+	a.audit(c, "file.download", info.Name, nil, nil)
+	c.Set("Content-Description", "File Transfer")
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(info.Name)))
+	return c.SendStream(file, int(info.Size))
+}
+
+func (a *App) deleteStoredFile(c *fiber.Ctx) error {
+	name := c.FormValue("name")
+	back := "/admin/uploads?folder=" + url.QueryEscape(c.FormValue("folder"))
+
+	if err := a.disk.Delete(name); err != nil {
+		a.log.Errorf("Can't delete file '%s': %v", name, err)
+		return c.Redirect(back)
+	}
+	a.audit(c, "file.delete", name, nil, nil)
+
+	return c.Redirect(back)
 }
 
 var form = map[string]map[string]string{
@@ -302,11 +297,29 @@ func (a *App) uploadView(c *fiber.Ctx) error {
 	data["User"] = participant
 	data["Form"] = form[t]
 	data["Path"] = t + "?code=" + participant.Token
+	data["Dashboard"] = "/participant?code=" + participant.Token
+
+	if !a.config.UploadOpen(t, time.Now()) {
+		data["Error"] = UploadClosedMessage
+	}
 
 	return c.Render("upload", data)
 }
 
-const UploadErrorMessage = "Can't upload file."
+const (
+	UploadErrorMessage    = "Can't upload file."
+	UploadRejectedMessage = "The file was rejected by the antivirus check. Please check it and upload again."
+	UploadClosedMessage   = "Uploading is closed, the deadline has passed."
+)
+
+func (a *App) sendUploadRejectedEmail(to To, fileName string) {
+	if err := a.sendEmail(
+		to,
+		Message{UploadRejectedEmail.Subject, fmt.Sprintf(UploadRejectedEmail.Text, to.Name, fileName)},
+	); err != nil {
+		a.log.Error(err)
+	}
+}
 
 func (a *App) uploadFile(c *fiber.Ctx) error {
 	// t is type of file: article/tezis
@@ -344,6 +357,12 @@ func (a *App) uploadFile(c *fiber.Ctx) error {
 	data["Form"] = form[t]
 	data["User"] = participant
 	data["Path"] = t + "?code=" + participant.Token
+	data["Dashboard"] = "/participant?code=" + participant.Token
+
+	if !a.config.UploadOpen(t, time.Now()) {
+		data["Error"] = UploadClosedMessage
+		return c.Render("upload", data)
+	}
 
 	file, err := c.FormFile(t)
 	if err != nil {
@@ -366,9 +385,22 @@ func (a *App) uploadFile(c *fiber.Ctx) error {
 
 	defer content.Close()
 
-	fileName := fmt.Sprintf("%s_%s_%s_%s_%s", participant.Name, participant.Surname, participant.Email, t, time.Now().Format("01-02-2002"))
+	version, err := a.nextSubmissionVersion(participant.Token, t, "")
+	if err != nil {
+		a.log.Error(err)
+		data["Error"] = UploadErrorMessage
+		return c.Render("upload", data)
+	}
+
+	fileName := fmt.Sprintf("%s/%s_%s_%s_%s_v%d.%s", t, participant.Name, participant.Surname, participant.Email, t, version, ext)
+
+	err = a.storeUpload(content, fileName, file.Filename, participant.Email)
+	if errors.Is(err, ErrUploadRejected) {
+		a.sendUploadRejectedEmail(To{strings.Join([]string{participant.Name, participant.Surname}, " "), participant.Email}, file.Filename)
 
-	err = a.saveToDisk(content, ext, t+"/"+fileName)
+		data["Error"] = UploadRejectedMessage
+		return c.Render("upload", data)
+	}
 	if err != nil {
 		a.log.Errorf("Can't save file to disk: %v", err)
 
@@ -376,11 +408,36 @@ func (a *App) uploadFile(c *fiber.Ctx) error {
 		return c.Render("upload", data)
 	}
 
+	submission := Submission{
+		Token:        participant.Token,
+		Type:         t,
+		Version:      version,
+		OriginalName: file.Filename,
+		Path:         fileName,
+		Size:         file.Size,
+	}
+	if submission.SHA256, err = hashUpload(content); err != nil {
+		a.log.Errorf("Can't hash file '%s': %v", fileName, err)
+	}
+	a.checkCompliance(&submission, content)
+
+	if err := a.db.Create(&submission).Error; err != nil {
+		a.log.Errorf("Can't record submission '%s': %v", fileName, err)
+		a.discardUpload(fileName)
+
+		data["Error"] = UploadErrorMessage
+		return c.Render("upload", data)
+	}
+	a.submissionReceived(submission, participant.Email)
+
 	data["Success"] = "File successfully uploaded"
+	data["Warnings"] = submission.Warnings()
+	data["Submission"] = submission
+	data["Receipt"] = a.receiptURL(submission)
 
 	if err = a.sendEmail(
 		To{strings.Join([]string{participant.Name, participant.Surname}, " "), participant.Email},
-		Message{emailMessage.Subject, fmt.Sprintf(emailMessage.Text, strings.Join([]string{participant.Name, participant.Surname}, " "))},
+		Message{emailMessage.Subject, fmt.Sprintf(emailMessage.Text, strings.Join([]string{participant.Name, participant.Surname}, " "), receiptHTML(submission))},
 	); err != nil {
 		a.log.Error(err)
 	}
@@ -430,6 +487,10 @@ func (a *App) sendNewsletter(c *fiber.Ctx) error {
 		}
 	}
 
+	// errorEmails starts with an empty one.
+	failed := len(errorEmails) - 1
+	a.audit(c, "mail.newsletter", fileForm, nil, map[string]int{"sent": len(participants) - failed, "failed": failed})
+
 	data := fiber.Map{}
 
 	if flag {
@@ -502,10 +563,32 @@ func (a *App) openUpload(c *fiber.Ctx) error {
 		}
 		defer content.Close()
 
-		uniqueId := uuid.New().String()
-		fileName := fmt.Sprintf("%s_%s_%s_%s_%s", name, surname, email, "open-upload", uniqueId)
+		participant, registered, err := a.openUploadParticipant(name, surname, email)
+		if err != nil {
+			a.log.Errorf("Can't find or create participant for open upload: %v", err)
+			messages["Error"] = UploadErrorMessage
+			data["Message"] = messages
+			return c.Render("open-upload", data)
+		}
 
-		err = a.saveToDisk(content, ext, "open-upload/"+fileName)
+		version, err := a.nextSubmissionVersion(participant.Token, "open-upload", "")
+		if err != nil {
+			a.log.Error(err)
+			messages["Error"] = UploadErrorMessage
+			data["Message"] = messages
+			return c.Render("open-upload", data)
+		}
+
+		fileName := fmt.Sprintf("open-upload/%s_%s_%s_%s_v%d.%s", name, surname, email, "open-upload", version, ext)
+
+		err = a.storeUpload(content, fileName, file.Filename, email)
+		if errors.Is(err, ErrUploadRejected) {
+			a.sendUploadRejectedEmail(To{strings.Join([]string{name, surname}, " "), email}, file.Filename)
+
+			messages["Error"] = UploadRejectedMessage
+			data["Message"] = messages
+			return c.Render("open-upload", data)
+		}
 		if err != nil {
 			a.log.Errorf("Can't save file to disk: %v", err)
 			messages["Error"] = UploadErrorMessage
@@ -513,11 +596,38 @@ func (a *App) openUpload(c *fiber.Ctx) error {
 			return c.Render("open-upload", data)
 		}
 
+		submission := Submission{
+			Token:        participant.Token,
+			Type:         "open-upload",
+			Version:      version,
+			OriginalName: file.Filename,
+			Path:         fileName,
+			Size:         file.Size,
+			Unverified:   registered,
+		}
+		if submission.SHA256, err = hashUpload(content); err != nil {
+			a.log.Errorf("Can't hash file '%s': %v", fileName, err)
+		}
+		a.checkCompliance(&submission, content)
+
+		if err := a.db.Create(&submission).Error; err != nil {
+			a.log.Errorf("Can't record submission '%s': %v", fileName, err)
+			a.discardUpload(fileName)
+			messages["Error"] = UploadErrorMessage
+			data["Message"] = messages
+			return c.Render("open-upload", data)
+		}
+		a.submissionReceived(submission, email)
+
 		messages["Success"] = "File successfully uploaded"
+		data["Warnings"] = submission.Warnings()
+		data["Submission"] = submission
+		data["Receipt"] = a.receiptURL(submission)
 
+		nameSurname := strings.Join([]string{name, surname}, " ")
 		if err := a.sendEmail(
-			To{strings.Join([]string{name, surname}, " "), email},
-			Message{AfterTezisiUploadEmail.Subject, fmt.Sprintf(AfterArticleUploadEmail.Text, strings.Join([]string{name, surname}, " "))},
+			To{nameSurname, email},
+			Message{AfterOpenUploadEmail.Subject, fmt.Sprintf(AfterOpenUploadEmail.Text, nameSurname, receiptHTML(submission), a.config.Domain, participant.Token)},
 		); err != nil {
 			a.log.Error(err)
 		}
@@ -529,6 +639,30 @@ func (a *App) openUpload(c *fiber.Ctx) error {
 	return c.Render("open-upload", data)
 }
 
+// openUploadParticipant attaches an open upload to the participant registered
+// with the email or creates a lightweight one. registered is true for the
+// former, anyone can type the email of a participant.
+func (a *App) openUploadParticipant(name, surname, email string) (participant Participant, registered bool, err error) {
+	err = a.db.Where("LOWER(email) = ?", strings.ToLower(email)).First(&participant).Error
+	if err == nil {
+		return participant, true, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return participant, false, err
+	}
+
+	participant = Participant{
+		CreatedAt:        time.Now().In(ConferenceLocation).Format(RegistrationDateLayout),
+		Token:            uuid.New().String(),
+		Name:             name,
+		Surname:          surname,
+		Email:            email,
+		PresentationForm: OpenUploadPresentationForm,
+	}
+
+	return participant, false, a.db.Create(&participant).Error
+}
+
 func (a *App) openUploadView(c *fiber.Ctx) error {
 	//month-day now
 	dtNow := time.Now().Format("01-02")
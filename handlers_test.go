@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestOpenUploadUnverified(t *testing.T) {
+	a := newTestApp(t)
+	if err := a.db.Create(&Participant{Token: "anna", Email: "anna@example.com"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	participant, registered, err := a.openUploadParticipant("Eve", "Smith", "ANNA@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if participant.Token != "anna" || !registered {
+		t.Errorf("upload with the email of anna went to %s, registered %v", participant.Token, registered)
+	}
+	participant, registered, err = a.openUploadParticipant("Carl", "Berg", "carl@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if participant.Token == "" || registered {
+		t.Errorf("upload with a new email went to %q, registered %v", participant.Token, registered)
+	}
+
+	if err := a.db.Create(&Submission{Token: "anna", Type: "open-upload", Version: 1, Path: "open-upload/eve.docx", OriginalName: "eve.docx", Unverified: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(a.config)
+	server.Use(func(c *fiber.Ctx) error {
+		c.Locals("admin", AdminUser{Username: "olga", Role: RoleOrganizer})
+		return c.Next()
+	})
+	server.Get("/admin/uploads", a.uploadsAdminView)
+	resp, err := server.Test(httptest.NewRequest(http.MethodGet, "/admin/uploads?folder=open-upload", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), ">Unverified<") {
+		t.Error("uploads page doesn't flag the unverified upload")
+	}
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/360EntSecGroup-Skylar/excelize"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// importMaxRows limits a spreadsheet, larger lists are split.
+const importMaxRows = 2000
+
+// Actions of import rows.
+const (
+	ImportInsert    = "insert"
+	ImportUpdate    = "update"
+	ImportUnchanged = "unchanged"
+	ImportInvalid   = "error"
+)
+
+var importActionLabels = map[string]string{
+	ImportInsert:    "Add",
+	ImportUpdate:    "Update",
+	ImportUnchanged: "Unchanged",
+	ImportInvalid:   "Error",
+}
+
+// importField is a Participant field a column can be mapped to. Columns are
+// mapped by the key, the label or an alias in the header. Code only finds
+// the participant to update.
+type importField struct {
+	Key     string
+	Label   string
+	Column  string
+	Aliases []string
+	Set     func(p *Participant, v string)
+}
+
+var importFields = []importField{
+	{"surname", "Surname", "surname", []string{"last name", "фамилия"}, func(p *Participant, v string) { p.Surname = v }},
+	{"name", "Name", "name", []string{"first name", "имя"}, func(p *Participant, v string) { p.Name = v }},
+	{"organization", "Organization", "organization", []string{"university", "affiliation", "организация"}, func(p *Participant, v string) { p.Organization = v }},
+	{"position", "Position", "position", []string{"должность"}, func(p *Participant, v string) { p.Position = v }},
+	{"phone", "Phone", "phone", []string{"телефон"}, func(p *Participant, v string) { p.Phone = v }},
+	{"email", "Email", "email", []string{"e-mail", "почта", "электронная почта"}, func(p *Participant, v string) { p.Email = v }},
+	{"form", "Presentation form", "presentation_form", []string{"participation form", "форма участия"}, func(p *Participant, v string) { p.PresentationForm = v }},
+	{"section", "Presentation section", "presentation_section", []string{"section", "секция"}, func(p *Participant, v string) { p.PresentationSection = v }},
+	{"title", "Presentation title", "presentation_title", []string{"title", "название доклада", "тема доклада"}, func(p *Participant, v string) { p.PresentationTitle = v }},
+	{"code", "Code", "", []string{"token"}, nil},
+}
+
+var ErrImportEmpty = errors.New("the file has no rows under the header")
+
+func importFieldByKey(key string) (importField, bool) {
+	for _, f := range importFields {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return importField{}, false
+}
+
+func normalizeHeader(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// guessImportMapping maps columns by their headers, each field once.
+func guessImportMapping(header []string) []string {
+	mapping := make([]string, len(header))
+	for i, h := range header {
+		h = normalizeHeader(h)
+		for _, f := range importFields {
+			if (h == f.Key || h == strings.ToLower(f.Label) || contains(f.Aliases, h)) && !contains(mapping, f.Key) {
+				mapping[i] = f.Key
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+// parseImportMapping reads the mapping chosen in the preview, one value per
+// column, and guesses it when there is none.
+func parseImportMapping(values [][]byte, header []string) []string {
+	if len(values) != len(header) {
+		return guessImportMapping(header)
+	}
+
+	mapping := make([]string, len(header))
+	for i, v := range values {
+		if _, ok := importFieldByKey(string(v)); ok {
+			mapping[i] = string(v)
+		}
+	}
+	return mapping
+}
+
+// readImportRows reads the active sheet of an XLSX file or a CSV file
+// separated by commas or semicolons, as Excel saves them in some locales.
+func readImportRows(r io.Reader, fileName string) ([][]string, error) {
+	var rows [][]string
+
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".xlsx":
+		document, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("can't read the spreadsheet: %w", err)
+		}
+		sheet := document.GetSheetName(document.GetActiveSheetIndex())
+		if sheet == "" {
+			sheet = document.GetSheetName(1)
+		}
+		rows = document.GetRows(sheet)
+	case ".csv":
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		content = bytes.TrimPrefix(content, []byte(utf8BOM))
+		// Excel saves plain CSV in the Windows code page of the locale,
+		// Cyrillic names would turn to garbage.
+		if !utf8.Valid(content) {
+			return nil, fmt.Errorf("the CSV file isn't in UTF-8, save it in Excel as \"CSV UTF-8\" or upload the .xlsx file")
+		}
+
+		cr := csv.NewReader(bytes.NewReader(content))
+		firstLine, _, _ := bytes.Cut(content, []byte("\n"))
+		if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+			cr.Comma = ';'
+		}
+		cr.FieldsPerRecord = -1
+		cr.LazyQuotes = true
+		if rows, err = cr.ReadAll(); err != nil {
+			return nil, fmt.Errorf("can't read the CSV file: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("upload a .csv or .xlsx file")
+	}
+
+	// Spreadsheets often end with empty rows.
+	for len(rows) > 0 && strings.TrimSpace(strings.Join(rows[len(rows)-1], "")) == "" {
+		rows = rows[:len(rows)-1]
+	}
+	if len(rows) < 2 {
+		return nil, ErrImportEmpty
+	}
+	if len(rows)-1 > importMaxRows {
+		return nil, fmt.Errorf("the file has %d rows, import at most %d at once", len(rows)-1, importMaxRows)
+	}
+
+	// Rows shorter than the header miss empty cells at the end.
+	for i := range rows {
+		for len(rows[i]) < len(rows[0]) {
+			rows[i] = append(rows[i], "")
+		}
+		rows[i] = rows[i][:len(rows[0])]
+	}
+
+	return rows, nil
+}
+
+func (i ParticipantImport) rows() ([][]string, error) {
+	var rows [][]string
+	err := json.Unmarshal([]byte(i.Rows), &rows)
+	return rows, err
+}
+
+// importRow is the outcome of a spreadsheet row, Line is its number in the
+// spreadsheet.
+type importRow struct {
+	Line        int
+	Action      string
+	Participant Participant
+	Changes     []auditChange
+	Errors      []string
+
+	// before is the participant to update as it is.
+	before Participant
+}
+
+func (r importRow) ActionLabel() string {
+	return importActionLabels[r.Action]
+}
+
+// importPlan is what an import does, the preview shows it and applying
+// saves it.
+type importPlan struct {
+	Header  []string
+	Mapping []string
+	// Errors are about the mapping, nothing is imported with them.
+	Errors []string
+	Rows   []importRow
+	Counts map[string]int
+}
+
+func (p importPlan) Ready() bool {
+	return len(p.Errors) == 0 && p.Counts[ImportInsert]+p.Counts[ImportUpdate] > 0
+}
+
+// importEmailVerifier looks every domain up once.
+func importEmailVerifier() func(string) error {
+	domains := make(map[string]error)
+	return func(email string) error {
+		_, domain, _ := strings.Cut(strings.ToLower(email), "@")
+		err, ok := domains[domain]
+		if !ok {
+			err = verifyEmail(email)
+			domains[domain] = err
+		}
+		return err
+	}
+}
+
+// skipEmailLookup leaves the domains of emails to the import, looking them
+// up on every preview is slow.
+func skipEmailLookup(string) error {
+	return nil
+}
+
+// planImport matches rows to participants by the code or the email and
+// checks them with the registration rules. Empty cells keep the current
+// values of participants.
+func (a *App) planImport(rows [][]string, mapping []string, verify func(string) error) (importPlan, error) {
+	plan := importPlan{Header: rows[0], Mapping: mapping, Counts: make(map[string]int)}
+
+	for _, f := range importFields {
+		n := 0
+		for _, key := range mapping {
+			if key == f.Key {
+				n++
+			}
+		}
+		if n > 1 {
+			plan.Errors = append(plan.Errors, fmt.Sprintf("%s is mapped to %d columns, map it once.", f.Label, n))
+		}
+	}
+	if !contains(mapping, "email") {
+		plan.Errors = append(plan.Errors, "Map a column to Email, participants are found and notified by it.")
+	}
+
+	var participants []Participant
+	if err := a.db.Find(&participants).Error; err != nil {
+		return plan, err
+	}
+	byEmail := make(map[string]Participant, len(participants))
+	byToken := make(map[string]Participant, len(participants))
+	for _, p := range participants {
+		byEmail[strings.ToLower(p.Email)] = p
+		byToken[p.Token] = p
+	}
+
+	forms := RegistrationPageContent["ParticipationForm"].([]string)
+	// Emails seen in the file, by the line.
+	seen := make(map[string]int)
+
+	for i, cells := range rows[1:] {
+		row := importRow{Line: i + 2}
+
+		if strings.TrimSpace(strings.Join(cells, "")) == "" {
+			continue
+		}
+
+		values := make(map[string]string)
+		for j, key := range mapping {
+			if key != "" {
+				values[key] = strings.TrimSpace(cells[j])
+			}
+		}
+
+		var current Participant
+		found := false
+		if code := values["code"]; code != "" {
+			if current, found = byToken[code]; !found {
+				row.Errors = append(row.Errors, "No participant has the code "+code+".")
+			}
+		} else if email := strings.ToLower(values["email"]); email != "" {
+			current, found = byEmail[email]
+		}
+
+		p := current
+		for _, f := range importFields {
+			if v := values[f.Key]; v != "" && f.Set != nil {
+				f.Set(&p, v)
+			}
+		}
+		// Emails are matched regardless of case, the current spelling stays.
+		if strings.EqualFold(p.Email, current.Email) {
+			p.Email = current.Email
+		}
+
+		email := strings.ToLower(p.Email)
+		if other, ok := byEmail[email]; ok && found && other.Token != current.Token {
+			row.Errors = append(row.Errors, "Another participant has the email "+p.Email+".")
+		}
+		if email != "" {
+			if line, ok := seen[email]; ok {
+				row.Errors = append(row.Errors, fmt.Sprintf("The email is on line %d too.", line))
+			}
+			seen[email] = row.Line
+		}
+
+		// Updates are checked for the values they change, participants edited
+		// in the panel may not follow the registration rules.
+		unchanged := map[string]bool{
+			"Surname": p.Surname == current.Surname,
+			"Name":    p.Name == current.Name,
+			"Phone":   p.Phone == current.Phone,
+			"Email":   p.Email == current.Email,
+		}
+		var messages []string
+		for field, message := range validateParticipant(p, verify) {
+			if !found || !unchanged[field] {
+				messages = append(messages, message)
+			}
+		}
+		sort.Strings(messages)
+		row.Errors = append(row.Errors, messages...)
+		if p.PresentationForm != "" && p.PresentationForm != current.PresentationForm && !contains(forms, p.PresentationForm) {
+			row.Errors = append(row.Errors, "Participation form must be one of: "+strings.Join(forms, ", ")+".")
+		}
+		if p.PresentationSection != "" && p.PresentationSection != current.PresentationSection && !contains(PresentationSections, p.PresentationSection) {
+			row.Errors = append(row.Errors, "Section must be one of: "+strings.Join(PresentationSections, ", ")+".")
+		}
+
+		row.Participant, row.before = p, current
+		switch {
+		case len(row.Errors) > 0:
+			row.Action = ImportInvalid
+		case !found:
+			row.Action = ImportInsert
+		default:
+			if row.Changes = diffFields(current, p); len(row.Changes) > 0 {
+				row.Action = ImportUpdate
+			} else {
+				row.Action = ImportUnchanged
+			}
+		}
+
+		plan.Counts[row.Action]++
+		plan.Rows = append(plan.Rows, row)
+	}
+
+	return plan, nil
+}
+
+// importColumns are the columns an import changes.
+func importColumns() []string {
+	var columns []string
+	for _, f := range importFields {
+		if f.Column != "" {
+			columns = append(columns, f.Column)
+		}
+	}
+	return columns
+}
+
+// applyImportPlan saves the rows to add and update, rows with errors are
+// skipped. It returns the saved participants.
+func (a *App) applyImportPlan(i *ParticipantImport, plan importPlan, now time.Time) ([]importRow, error) {
+	var saved []importRow
+
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		// Applying twice at once adds participants twice.
+		result := tx.Model(&ParticipantImport{}).Where("id = ? AND applied_at IS NULL", i.ID).Update("applied_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("the import is already applied")
+		}
+
+		for _, row := range plan.Rows {
+			switch row.Action {
+			case ImportInsert:
+				row.Participant.Token = uuid.New().String()
+				row.Participant.CreatedAt = now.In(ConferenceLocation).Format(RegistrationDateLayout)
+				if err := tx.Create(&row.Participant).Error; err != nil {
+					return fmt.Errorf("line %d: %w", row.Line, err)
+				}
+			case ImportUpdate:
+				if err := tx.Model(&Participant{}).Where("token = ?", row.Participant.Token).
+					Select(importColumns()).Updates(row.Participant).Error; err != nil {
+					return fmt.Errorf("line %d: %w", row.Line, err)
+				}
+			default:
+				continue
+			}
+			saved = append(saved, row)
+		}
+
+		i.Result = fmt.Sprintf("%d added, %d updated, %d unchanged, %d skipped with errors.",
+			plan.Counts[ImportInsert], plan.Counts[ImportUpdate], plan.Counts[ImportUnchanged], plan.Counts[ImportInvalid])
+		i.AppliedAt = &now
+		// Rows hold personal data, applied imports don't need them.
+		i.Rows = ""
+		return tx.Model(&ParticipantImport{}).Where("id = ?", i.ID).
+			Updates(map[string]interface{}{"result": i.Result, "rows": ""}).Error
+	})
+
+	return saved, err
+}
+
+func importURL(id uint, mapping url.Values, message, errorMessage string) string {
+	v := url.Values{}
+	for key, values := range mapping {
+		v[key] = values
+	}
+	if message != "" {
+		v.Set("message", message)
+	}
+	if errorMessage != "" {
+		v.Set("error", errorMessage)
+	}
+	u := "/admin/import/" + strconv.Itoa(int(id))
+	if len(v) > 0 {
+		u += "?" + v.Encode()
+	}
+	return u
+}
+
+func (a *App) importView(c *fiber.Ctx) error {
+	var imports []ParticipantImport
+	if err := a.db.Select("id", "created_at", "author", "file_name", "applied_at", "result").Order("id DESC").Limit(20).Find(&imports).Error; err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	return c.Render("admin-import", fiber.Map{
+		"Title":   "Import participants",
+		"Imports": imports,
+		"Fields":  importFields,
+		"Error":   c.Query("error"),
+	})
+}
+
+func (a *App) uploadImport(c *fiber.Ctx) error {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Redirect("/admin/import?error=" + url.QueryEscape("Choose a .csv or .xlsx file."))
+	}
+
+	content, err := file.Open()
+	if err != nil {
+		a.log.Error(err)
+		return c.Redirect("/admin/import?error=" + url.QueryEscape("Can't read the file."))
+	}
+	defer content.Close()
+
+	rows, err := readImportRows(content, file.Filename)
+	if err != nil {
+		return c.Redirect("/admin/import?error=" + url.QueryEscape("Can't import "+file.Filename+": "+err.Error()+"."))
+	}
+
+	b, err := json.Marshal(rows)
+	if err != nil {
+		a.log.Error(err)
+		return err
+	}
+	i := ParticipantImport{Author: adminActor(c), FileName: file.Filename, Rows: string(b)}
+	if err := a.db.Create(&i).Error; err != nil {
+		a.log.Error(err)
+		return c.Redirect("/admin/import?error=" + url.QueryEscape("Can't save the file."))
+	}
+
+	return c.Redirect(importURL(i.ID, nil, "", ""))
+}
+
+func (a *App) importOr404(c *fiber.Ctx) (ParticipantImport, [][]string, bool) {
+	var i ParticipantImport
+	id, err := strconv.Atoi(c.Params("id"))
+	if err == nil {
+		err = a.db.First(&i, id).Error
+	}
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			a.log.Error(err)
+		}
+		c.Status(fiber.StatusNotFound)
+		c.Bind(fiber.Map{
+			"Title":   "Import not found",
+			"Content": "Upload the file again.",
+		})
+		c.Render("basic", fiber.Map{})
+		return i, nil, false
+	}
+	if i.AppliedAt != nil {
+		return i, nil, true
+	}
+
+	rows, err := i.rows()
+	if err != nil || len(rows) == 0 {
+		a.log.Errorf("Can't read rows of import %d: %v", i.ID, err)
+		c.Status(fiber.StatusInternalServerError)
+		c.Bind(fiber.Map{
+			"Title":   "Import",
+			"Content": "Can't read the import, upload the file again.",
+		})
+		c.Render("basic", fiber.Map{})
+		return i, nil, false
+	}
+
+	return i, rows, true
+}
+
+func (a *App) importPreviewView(c *fiber.Ctx) error {
+	i, rows, ok := a.importOr404(c)
+	if !ok {
+		return nil
+	}
+
+	data := fiber.Map{
+		"Title":   "Import " + i.FileName,
+		"Import":  i,
+		"Fields":  importFields,
+		"Message": c.Query("message"),
+		"Error":   c.Query("error"),
+	}
+
+	if i.AppliedAt == nil {
+		plan, err := a.planImport(rows, parseImportMapping(c.Context().QueryArgs().PeekMulti("map"), rows[0]), skipEmailLookup)
+		if err != nil {
+			a.log.Error(err)
+			return err
+		}
+		data["Plan"] = plan
+	}
+
+	return c.Render("admin-import-preview", data)
+}
+
+func (a *App) applyImport(c *fiber.Ctx) error {
+	i, rows, ok := a.importOr404(c)
+	if !ok {
+		return nil
+	}
+
+	if i.AppliedAt != nil {
+		return c.Redirect(importURL(i.ID, nil, "", "The import is already applied."))
+	}
+	mapping := parseImportMapping(c.Request().PostArgs().PeekMulti("map"), rows[0])
+	mappingValues := url.Values{"map": mapping}
+
+	plan, err := a.planImport(rows, mapping, importEmailVerifier())
+	if err != nil {
+		a.log.Error(err)
+		return c.Redirect(importURL(i.ID, mappingValues, "", "Can't check the rows."))
+	}
+	if !plan.Ready() {
+		return c.Redirect(importURL(i.ID, mappingValues, "", "Nothing to import, check the preview."))
+	}
+
+	saved, err := a.applyImportPlan(&i, plan, time.Now())
+	if err != nil {
+		a.log.Errorf("Can't apply import %d: %v", i.ID, err)
+		return c.Redirect(importURL(i.ID, mappingValues, "", "Can't import: "+err.Error()+"."))
+	}
+
+	for _, row := range saved {
+		if row.Action == ImportInsert {
+			a.audit(c, "participant.create", row.Participant.auditTarget(), nil, row.Participant)
+		} else {
+			a.audit(c, "participant.update", row.Participant.auditTarget(), row.before, row.Participant)
+		}
+	}
+	a.audit(c, "participant.import", "import "+i.FileName, nil, map[string]int{
+		"Added": plan.Counts[ImportInsert], "Updated": plan.Counts[ImportUpdate], "Skipped": plan.Counts[ImportInvalid],
+	})
+
+	message := i.Result
+	if c.FormValue("send-links") == "on" {
+		emails := make([]Email, len(saved))
+		for j, row := range saved {
+			p := row.Participant
+			emails[j] = Email{
+				To:      To{strings.Join([]string{p.Name, p.Surname}, " "), p.Email},
+				Message: Message{ParticipantLinkEmail.Subject, fmt.Sprintf(ParticipantLinkEmail.Text, p.Name, a.config.Domain, p.Token)},
+			}
+		}
+		failed := 0
+		for j, err := range a.sendEmails(emails) {
+			p := saved[j].Participant
+			if err != nil {
+				a.log.Errorf("Can't send email to %s: %v", p.Email, err)
+				failed++
+				continue
+			}
+			a.audit(c, "mail.participant-link", p.auditTarget(), nil, nil)
+		}
+		message += fmt.Sprintf(" Links are sent to %d participants", len(saved)-failed)
+		if failed > 0 {
+			message += fmt.Sprintf(", %d emails failed, see the email log", failed)
+		}
+		message += "."
+	}
+
+	return c.Redirect(importURL(i.ID, nil, message, ""))
+}
+
+// forgetImports deletes imports not applied yet that have the email in their
+// rows, deleted and anonymized participants must not stay in them.
+func forgetImports(tx *gorm.DB, email string) error {
+	if email == "" {
+		return nil
+	}
+	pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(email))
+	return tx.Where("applied_at IS NULL AND LOWER(`rows`) LIKE ? ESCAPE '\\'", "%"+pattern+"%").
+		Delete(&ParticipantImport{}).Error
+}
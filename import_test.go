@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportForgetsRows(t *testing.T) {
+	a := newTestApp(t)
+	rows := [][]string{
+		{"Surname", "Name", "Email"},
+		{"Smith", "Anna", "Anna.Smith@example.com"},
+	}
+	applied := ParticipantImport{FileName: "applied.csv", Rows: `[["Surname","Name","Email"],["Smith","Anna","Anna.Smith@example.com"]]`}
+	pending := ParticipantImport{FileName: "pending.csv", Rows: applied.Rows}
+	other := ParticipantImport{FileName: "other.csv", Rows: `[["Email"],["annaxsmith@example.com"]]`}
+	for _, i := range []*ParticipantImport{&applied, &pending, &other} {
+		if err := a.db.Create(i).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan, err := a.planImport(rows, guessImportMapping(rows[0]), skipEmailLookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Ready() {
+		t.Fatalf("plan isn't ready: %+v", plan)
+	}
+	if _, err := a.applyImportPlan(&applied, plan, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.db.First(&applied, applied.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if applied.Rows != "" || applied.AppliedAt == nil {
+		t.Errorf("applied import keeps rows %q", applied.Rows)
+	}
+
+	if err := forgetImports(a.db, "anna.smith@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	if err := a.db.Model(&ParticipantImport{}).Order("id").Pluck("file_name", &left).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 2 || left[0] != "applied.csv" || left[1] != "other.csv" {
+		t.Errorf("imports left %q, want the applied one and the one without the email", left)
+	}
+}
+
+func TestImportPreviewShowsChanges(t *testing.T) {
+	a := newTestApp(t)
+	if err := a.db.Create(&Participant{Token: "anna", Surname: "Smith", Name: "Anna", Email: "anna@example.com", Phone: "+79990000000"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]string{{"Email", "Surname"}, {"anna@example.com", "Berg"}}
+
+	plan, err := a.planImport(rows, guessImportMapping(rows[0]), skipEmailLookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Rows) != 1 || plan.Rows[0].Action != ImportUpdate {
+		t.Fatalf("plan rows %+v", plan.Rows)
+	}
+	changes := plan.Rows[0].Changes
+	if len(changes) != 1 || changes[0].From != "Smith" || changes[0].To != "Berg" {
+		t.Errorf("preview changes %+v, want the surname values", changes)
+	}
+	if logged := auditDiff(plan.Rows[0].before, plan.Rows[0].Participant); len(logged) != 1 || logged[0].To != auditRedacted {
+		t.Errorf("audit log changes %+v, want the surname redacted", logged)
+	}
+}
+
+func TestReadImportRowsEncoding(t *testing.T) {
+	rows, err := readImportRows(strings.NewReader(utf8BOM+"Surname;Name\nИванова;Анна\n"), "participants.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1][0] != "Иванова" || rows[1][1] != "Анна" {
+		t.Errorf("rows are %q", rows)
+	}
+
+	// "Иван" in Windows-1251.
+	_, err = readImportRows(strings.NewReader("Surname;Name\n\xc8\xe2\xe0\xed;Anna\n"), "participants.csv")
+	if err == nil || !strings.Contains(err.Error(), "UTF-8") {
+		t.Errorf("CSV in Windows-1251 is read with error %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 
 	"gopkg.in/gomail.v2"
 )
@@ -15,6 +16,7 @@ var AfterRegistrationEmail = Message{
 				Thank you for registering at the International Conference «Arctic: Marine Transportation Challenges – 2022» on November 24-25, 2022.
 			</strong></p>
 			<p>You can find up-to-date information about the key dates of the Conference <a href="%s/programme-overview">here</a>.</p>
+			<p>Your uploads will be listed on <a href="%s/participant?code=%s">your submissions page</a>.</p>
 			<p>If you have any questions, please contact by <a href="mailto:amtc@gumrf.ru">amtc@gumrf.ru</a>.</p>
 		</body>
 		</html>`,
@@ -53,6 +55,8 @@ var AfterTezisiUploadEmail = Message{
 		<html>
 		<body>
 			<p><strong>Dear %s, abstracts uploaded successfully.</strong></p>
+			<p>Please keep this receipt of your submission:</p>
+			%s
 			<p>The International Conference «Arctic: Marine Transportation Challenges – 2022» will be held on November 24-25, 2022</p>
 			<p>If you have any questions, please contact by <a href="mailto:amtc@gumrf.ru">amtc@gumrf.ru</a>.</p>
 		</body>
@@ -64,6 +68,8 @@ var AfterArticleUploadEmail = Message{
 		<html>
 		<body>
 			<p><strong>Dear %s, full paper uploaded successfully.</strong></p>
+			<p>Please keep this receipt of your submission:</p>
+			%s
 			<p> We will contact you if there are questions about the results of the review. </p>
 			<p>Please clarify by amtc@gumrf.ru whether an oral presentation is planned or only publication. In the case of an oral presentation, whether it will be a face-to-face or online participation.</p>
 			<p>If you have any questions, please contact by <a href="mailto:amtc@gumrf.ru">amtc@gumrf.ru</a>.</p>
@@ -71,6 +77,121 @@ var AfterArticleUploadEmail = Message{
 		</html>`,
 }
 
+var AfterOpenUploadEmail = Message{
+	Subject: "Full paper upload",
+	Text: `
+		<html>
+		<body>
+			<p><strong>Dear %s, full paper uploaded successfully.</strong></p>
+			<p>Please keep this receipt of your submission:</p>
+			%s
+			<p>Your uploads are listed on <a href="%s/participant?code=%s">your submissions page</a>, you can download them there. Please keep this link, it gives access to your submissions.</p>
+			<p>The International Conference «Arctic: Marine Transportation Challenges – 2022» will be held on November 24-25, 2022</p>
+			<p>If you have any questions, please contact by <a href="mailto:amtc@gumrf.ru">amtc@gumrf.ru</a>.</p>
+		</body>
+		</html>`,
+}
+var UploadRejectedEmail = Message{
+	Subject: "Upload rejected",
+	Text: `
+		<html>
+		<body>
+			<p><strong>Dear %s, the file «%s» you uploaded was rejected by the antivirus check.</strong></p>
+			<p>Please check the file and upload it again. If you believe this is a mistake, please contact by <a href="mailto:amtc@gumrf.ru">amtc@gumrf.ru</a>.</p>
+		</body>
+		</html>`,
+}
+var ReviewerInvitationEmail = Message{
+	Subject: "Invitation to review",
+	Text: `
+		<html>
+		<body>
+			<p><strong>Dear %s, you are invited to review submissions to the International Conference «Arctic: Marine Transportation Challenges – 2022».</strong></p>
+			<p>The submissions assigned to you are listed on <a href="%s/reviewer?code=%s">your reviewer page</a>. Please keep this link, it gives access to your reviews.</p>
+			<p>If you have any questions, please contact by <a href="mailto:amtc@gumrf.ru">amtc@gumrf.ru</a>.</p>
+		</body>
+		</html>`,
+}
+
+var StatusUnderReviewEmail = Message{
+	Subject: "Your submission is under review",
+	Text: `
+		<html>
+		<body>
+			<p><strong>Dear %s, your submission (%s) «%s» has been sent to the reviewers.</strong></p>
+			%s
+			<p>You can follow the status of your submission on <a href="%s/participant?code=%s">your submissions page</a>.</p>
+			<p>If you have any questions, please contact by <a href="mailto:amtc@gumrf.ru">amtc@gumrf.ru</a>.</p>
+		</body>
+		</html>`,
+}
+
+var StatusRevisionRequestedEmail = Message{
+	Subject: "Revision of your submission is requested",
+	Text: `
+		<html>
+		<body>
+			<p><strong>Dear %s, the reviewers ask you to revise your submission (%s) «%s». Please upload a new version.</strong></p>
+			%s
+			<p>You can follow the status of your submission on <a href="%s/participant?code=%s">your submissions page</a>.</p>
+			<p>If you have any questions, please contact by <a href="mailto:amtc@gumrf.ru">amtc@gumrf.ru</a>.</p>
+		</body>
+		</html>`,
+}
+
+var StatusAcceptedEmail = Message{
+	Subject: "Your submission is accepted",
+	Text: `
+		<html>
+		<body>
+			<p><strong>Dear %s, we are pleased to inform you that your submission (%s) «%s» has been accepted.</strong></p>
+			%s
+			<p>You can follow the status of your submission on <a href="%s/participant?code=%s">your submissions page</a>.</p>
+			<p>If you have any questions, please contact by <a href="mailto:amtc@gumrf.ru">amtc@gumrf.ru</a>.</p>
+		</body>
+		</html>`,
+}
+
+var StatusRejectedEmail = Message{
+	Subject: "Decision on your submission",
+	Text: `
+		<html>
+		<body>
+			<p><strong>Dear %s, we regret to inform you that your submission (%s) «%s» has not been accepted.</strong></p>
+			%s
+			<p>You can follow the status of your submission on <a href="%s/participant?code=%s">your submissions page</a>.</p>
+			<p>If you have any questions, please contact by <a href="mailto:amtc@gumrf.ru">amtc@gumrf.ru</a>.</p>
+		</body>
+		</html>`,
+}
+
+var AfterCameraReadyUploadEmail = Message{
+	Subject: "Camera-ready upload",
+	Text: `
+		<html>
+		<body>
+			<p><strong>Dear %s, %s uploaded successfully.</strong></p>
+			<p>Please keep this receipt of your submission:</p>
+			%s
+			<p>You can check which camera-ready items are still missing on <a href="%s/participant?code=%s">your submissions page</a>.</p>
+			<p>If you have any questions, please contact by <a href="mailto:amtc@gumrf.ru">amtc@gumrf.ru</a>.</p>
+		</body>
+		</html>`,
+}
+
+var ParticipantLinkEmail = Message{
+	Subject: "Your submissions page",
+	Text: `
+		<html>
+		<body>
+			<p><strong>Dear %s,</strong></p>
+			<p>You can upload your papers and follow their status on <a href="%s/participant?code=%s">your submissions page</a>.</p>
+			<p>Please don't share the link, it signs you in.</p>
+			<p>If you have any questions, please contact by <a href="mailto:amtc@gumrf.ru">amtc@gumrf.ru</a>.</p>
+		</body>
+		</html>`,
+}
+
 type To struct {
 	Name  string
 	Email string
@@ -81,20 +202,89 @@ type Message struct {
 	Text    string
 }
 
-func (a *App) sendEmail(to To, message Message) error {
+// Attachment is a file sent with an email.
+type Attachment struct {
+	Name        string
+	ContentType string
+	Content     []byte
+}
+
+// Email is a message to one recipient.
+type Email struct {
+	To          To
+	Message     Message
+	Attachments []Attachment
+}
+
+// sendEmail sends the message and records it in the email log.
+func (a *App) sendEmail(to To, message Message, attachments ...Attachment) error {
+	return a.sendEmails([]Email{{to, message, attachments}})[0]
+}
+
+// sendEmails sends the messages over one connection and records them in the
+// email log. It returns the error of every message.
+func (a *App) sendEmails(emails []Email) []error {
+	errs := make([]error, len(emails))
+
+	var m gomail.SendCloser
+	for i, e := range emails {
+		if m == nil {
+			var err error
+			if m, err = a.dialSMTP(); err != nil {
+				for j := i; j < len(emails); j++ {
+					errs[j] = err
+				}
+				break
+			}
+		}
+		if errs[i] = m.Send(a.config.SMTP.User, []string{e.To.Email}, a.newEmail(e)); errs[i] != nil {
+			// The connection may be broken, the next message dials again.
+			m.Close()
+			m = nil
+		}
+	}
+	if m != nil {
+		m.Close()
+	}
+
+	for i, e := range emails {
+		entry := EmailLog{To: e.To.Email, Subject: e.Message.Subject}
+		if errs[i] != nil {
+			entry.Error = errs[i].Error()
+		}
+		if err := a.db.Create(&entry).Error; err != nil {
+			a.log.Error(err)
+		}
+	}
+
+	return errs
+}
+
+func (a *App) dialSMTP() (gomail.SendCloser, error) {
 	m, err := gomail.NewDialer(a.config.SMTP.Host, a.config.SMTP.Port, a.config.SMTP.User, a.config.SMTP.Password).Dial()
 	if err != nil {
-		return fmt.Errorf("can't authenticate to an SMTP server: %w", err)
+		return nil, fmt.Errorf("can't authenticate to an SMTP server: %w", err)
 	}
-	defer m.Close()
 
 	a.log.Infof("Authenticated to SMTP server: %s:%d", a.config.SMTP.Host, a.config.SMTP.Port)
+	return m, nil
+}
 
+func (a *App) newEmail(e Email) *gomail.Message {
 	email := gomail.NewMessage(gomail.SetCharset("UTF-8"), gomail.SetEncoding(gomail.Base64))
 	email.SetAddressHeader("From", a.config.SMTP.User, "AMTC 2022 Organizers")
-	email.SetAddressHeader("To", to.Email, to.Name)
-	email.SetHeader("Subject", message.Subject)
-	email.SetBody("text/html", message.Text)
-
-	return m.Send(a.config.SMTP.User, []string{to.Email}, email)
+	email.SetAddressHeader("To", e.To.Email, e.To.Name)
+	email.SetHeader("Subject", e.Message.Subject)
+	email.SetBody("text/html", e.Message.Text)
+	for _, attachment := range e.Attachments {
+		content := attachment.Content
+		email.Attach(attachment.Name,
+			gomail.SetHeader(map[string][]string{"Content-Type": {attachment.ContentType}}),
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			}),
+		)
+	}
+	return email
 }
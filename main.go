@@ -13,7 +13,6 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/basicauth"
 	"github.com/gofiber/fiber/v2/middleware/csrf"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -33,26 +32,21 @@ func init() {
 }
 
 type App struct {
-	server *fiber.App
-	db     *gorm.DB
-	mailer gomail.SendCloser
-	log    *Logger
-	disk   Disk
-	config *Config
+	server  *fiber.App
+	db      *gorm.DB
+	mailer  gomail.SendCloser
+	log     *Logger
+	disk    Disk
+	scanner *ClamdScanner
+	oidc    *OIDCProvider
+	config  *Config
 }
 
 func (a *App) Init(config *Config, log *Logger) error {
-	//db, err := gorm.Open(mysql.Open(config.DatabaseURL), &gorm.Config{})
-	db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
+	db, err := openDatabase(config, log)
 	if err != nil {
-		return fmt.Errorf("can't open database: %w", err)
+		return err
 	}
-	log.Infof("Connected to database: %s", config.DatabaseURL)
-
-	if err := db.AutoMigrate(&Participant{}); err != nil {
-		return fmt.Errorf("can't apply migrations to database: %w", err)
-	}
-	log.Info("Migrations applied")
 
 	// m, err := gomail.NewDialer(config.SMTP.Host, config.SMTP.Port, config.SMTP.User, config.SMTP.Password).Dial()
 	// if err != nil {
@@ -60,19 +54,29 @@ func (a *App) Init(config *Config, log *Logger) error {
 	// }
 	// log.Infof("Authenticated to SMTP server: %s:%d", config.SMTP.Host, config.SMTP.Port)
 
-	disk, err := NewOsDisk(config.DiskPath)
+	disk, err := newDisk(config, log)
 	if err != nil {
-		return fmt.Errorf("can't init disk at '%s': %w", config.DiskPath, err)
+		return err
 	}
-	log.Infof("Disk initialized at: %s", disk.Path)
 
-	server := fiber.New(fiber.Config{
-		Views:        html.New("./views", ".html"),
-		ViewsLayout:  "main",
-		ServerHeader: "Content-Security-Policy",
-	})
+	if config.ClamdAddress != "" {
+		a.scanner, err = NewClamdScanner(config.ClamdAddress)
+		if err != nil {
+			return err
+		}
+		if err := a.scanner.Ping(); err != nil {
+			log.Errorf("Clamd is not available, uploads will be quarantined: %v", err)
+		} else {
+			log.Infof("Uploads are scanned by clamd: %s", config.ClamdAddress)
+		}
+	}
+
+	if config.OIDC.Issuer != "" {
+		a.oidc = NewOIDCProvider(config.OIDC)
+		log.Infof("Admin single sign-on through %s", config.OIDC.Issuer)
+	}
 
-	a.server = server
+	a.server = newServer(config)
 	a.db = db
 	//a.mailer = m
 	a.log = log
@@ -84,6 +88,77 @@ func (a *App) Init(config *Config, log *Logger) error {
 	return nil
 }
 
+func newServer(config *Config) *fiber.App {
+	views := html.New("./views", ".html")
+	views.AddFunc("filesize", formatFileSize)
+	views.AddFunc("hasPrefix", strings.HasPrefix)
+
+	proxies := config.TrustedProxies
+	if config.HTTPAddressUnix != "" {
+		// Connections through the socket come from a local proxy and have no
+		// address.
+		proxies = append([]string{"0.0.0.0"}, proxies...)
+	}
+
+	return fiber.New(fiber.Config{
+		Views:        views,
+		ViewsLayout:  "main",
+		ServerHeader: "Content-Security-Policy",
+		// Sign in lockouts and the audit log need the client address, not the
+		// address of the proxy in front of the server. Only trusted proxies
+		// can set it, X-Forwarded-For isn't used as clients can prepend to it.
+		ProxyHeader:             "X-Real-IP",
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          proxies,
+		EnableIPValidation:      true,
+	})
+}
+
+func openDatabase(config *Config, log *Logger) (*gorm.DB, error) {
+	//db, err := gorm.Open(mysql.Open(config.DatabaseURL), &gorm.Config{})
+	db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("can't open database: %w", err)
+	}
+	log.Infof("Connected to database: %s", config.DatabaseURL)
+
+	if err := migrateDatabase(db); err != nil {
+		return nil, fmt.Errorf("can't apply migrations to database: %w", err)
+	}
+	log.Info("Migrations applied")
+
+	return db, nil
+}
+
+func migrateDatabase(db *gorm.DB) error {
+	return db.AutoMigrate(&Participant{}, &Submission{}, &QuarantinedFile{}, &Reviewer{}, &ReviewCriterion{}, &Assignment{}, &Review{}, &StatusChange{}, &ScheduleDay{}, &Room{}, &ScheduleSession{}, &Slot{}, &AdminUser{}, &AdminSession{}, &LoginAttempt{}, &RecoveryCode{}, &TrustedDevice{}, &OIDCLogin{}, &ParticipantNote{}, &EmailLog{}, &AuditEvent{}, &ParticipantImport{})
+}
+
+func newDisk(config *Config, log *Logger) (Disk, error) {
+	osDisk, err := NewOsDisk(config.DiskPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't init disk at '%s': %w", config.DiskPath, err)
+	}
+
+	if config.YandexDisk.Token == "" {
+		log.Infof("Disk initialized at: %s", osDisk.Path)
+		return osDisk, nil
+	}
+
+	yandexDisk, err := NewYandexDisk(config.YandexDisk)
+	if err != nil {
+		return nil, fmt.Errorf("can't init yandex disk: %w", err)
+	}
+
+	if config.YandexDisk.Mirror {
+		log.Infof("Disk initialized at: %s, mirrored to Yandex Disk: %s", osDisk.Path, yandexDisk.rootPath())
+		return NewMirrorDisk(osDisk, yandexDisk, log), nil
+	}
+
+	log.Infof("Disk initialized at Yandex Disk: %s", yandexDisk.rootPath())
+	return yandexDisk, nil
+}
+
 func (a *App) Run() {
 	if a.config.HTTPAddressUnix != "" {
 		ln, err := net.Listen("unix", a.config.HTTPAddressUnix)
@@ -153,17 +228,25 @@ func (a *App) registerRoutes() {
 			return c.Next()
 		},
 		csrf.New(csrf.Config{
-			KeyLookup:      "header:X-Csrf-Token",
+			KeyLookup:      "form:_csrf",
 			CookieName:     "csrf",
 			CookieSameSite: "Lax",
 			Expiration:     1 * time.Hour,
 			KeyGenerator:   utils.UUID,
 			CookieHTTPOnly: true,
+			ContextKey:     "csrf",
 		}),
+		func(c *fiber.Ctx) error {
+			c.Bind(fiber.Map{
+				"Csrf": c.Locals("csrf"),
+			})
+			return c.Next()
+		},
 	)
 
 	s.Get("/", a.mainView)
 	s.Get("/programme-overview", a.programOverviewView)
+	s.Get("/programme.ics", a.programmeCalendar)
 	s.Get("/keynote-speakers", a.keynoteSpeakersView)
 	s.Get("/requirements", a.requirementsView)
 	s.Get("/general-information", a.generalInfoView)
@@ -171,17 +254,31 @@ func (a *App) registerRoutes() {
 	s.Post("/registration-and-submission", a.registerNewParticipant)
 	s.Get("/upload/:type", a.uploadView)
 	s.Post("/upload/:type", a.uploadFile)
+	s.Get("/participant", a.participantView)
+	s.Get("/participant/files/:id", a.participantFile)
+	s.Get("/participant/receipts/:id", a.receiptView)
+	s.Post("/participant/camera-ready/:type/:artifact", a.uploadCameraReady)
+	s.Get("/participant/agenda.ics", a.participantCalendar)
 	s.Get("/open-upload", a.openUploadView)
 	s.Post("/open-upload", a.openUpload)
+	s.Get("/reviewer", a.reviewerView)
+	s.Get("/reviewer/assignments/:id", a.reviewView)
+	s.Post("/reviewer/assignments/:id", a.saveReview)
+	s.Get("/reviewer/assignments/:id/file", a.reviewFile)
+
+	// Sign in pages are the only admin pages without a session.
+	s.Get("/admin/login", a.loginView)
+	s.Post("/admin/login", a.login)
+	s.Get("/admin/login/totp", a.loginTOTPView)
+	s.Post("/admin/login/totp", a.loginTOTP)
+	if a.oidc != nil {
+		s.Get("/admin/login/oidc", a.oidcLogin)
+		s.Get("/admin/login/oidc/callback", a.oidcCallback)
+	}
 
 	admin := s.Group("/admin",
-		basicauth.New(
-			basicauth.Config{
-				Users: map[string]string{
-					"admin": a.config.AdminPassword,
-				},
-			},
-		),
+		a.requireAdminSession,
+		a.loadAdminUser,
 		func(c *fiber.Ctx) error {
 			c.Bind(fiber.Map{
 				"Title": "Admin",
@@ -190,8 +287,60 @@ func (a *App) registerRoutes() {
 		},
 	)
 	admin.Get("/", a.adminView)
-	admin.Post("/mailing", a.sendNewsletter)
-	admin.Get("/download/:file", a.downloadFiles)
+	admin.Post("/logout", a.logout)
+	admin.Get("/sessions", a.adminSessionsView)
+	admin.Post("/sessions/:key/revoke", a.revokeSession)
+	admin.Get("/totp", a.totpView)
+	admin.Get("/totp/qr.png", a.totpQR)
+	admin.Post("/totp", a.enableTOTP)
+	admin.Post("/totp/disable", a.disableTOTP)
+	admin.Post("/totp/recovery-codes", a.regenerateRecoveryCodes)
+	admin.Post("/mailing", allow(PermManage), a.sendNewsletter)
+	admin.Get("/dashboard", allow(PermView), a.dashboardView)
+	admin.Get("/dashboard.json", allow(PermView), a.dashboardJSON)
+	admin.Get("/participants/:token", allow(PermView), a.participantAdminView)
+	admin.Post("/participants/:token", allow(PermManage), a.updateParticipant)
+	admin.Post("/participants/:token/notes", allow(PermManage), a.createParticipantNote)
+	admin.Post("/participants/:token/token", allow(PermManage), a.regenerateParticipantToken)
+	admin.Post("/participants/:token/resend", allow(PermManage), a.resendParticipantLink)
+	admin.Post("/participants/:token/delete", allow(PermManage), a.deleteParticipant)
+	admin.Post("/participants/:token/anonymize", allow(PermManage), a.anonymizeParticipant)
+	admin.Get("/export", allow(PermView), a.exportParticipantsFile)
+	admin.Get("/import", allow(PermManage), a.importView)
+	admin.Post("/import", allow(PermManage), a.uploadImport)
+	admin.Get("/import/:id", allow(PermManage), a.importPreviewView)
+	admin.Post("/import/:id", allow(PermManage), a.applyImport)
+	admin.Get("/download/:file", allow(PermView), a.downloadFiles)
+	admin.Get("/uploads", allow(PermView), a.uploadsAdminView)
+	admin.Get("/files/*", allow(PermView), a.downloadStoredFile)
+	admin.Post("/files/delete", allow(PermManage), a.deleteStoredFile)
+	admin.Get("/reviews", allow(PermReviews), a.reviewsAdminView)
+	admin.Post("/reviewers", allow(PermManage), a.createReviewer)
+	admin.Post("/reviewers/:id", allow(PermManage), a.updateReviewer)
+	admin.Post("/reviewers/:id/toggle", allow(PermManage), a.toggleReviewer)
+	admin.Post("/review-criteria", allow(PermManage), a.createReviewCriterion)
+	admin.Post("/review-criteria/:id/delete", allow(PermManage), a.deleteReviewCriterion)
+	admin.Post("/assignments", allow(PermManage), a.createAssignment)
+	admin.Post("/assignments/auto", allow(PermManage), a.autoAssign)
+	admin.Post("/assignments/commit", allow(PermManage), a.commitAssignments)
+	admin.Post("/assignments/discard", allow(PermManage), a.discardAssignments)
+	admin.Post("/status", allow(PermManage), a.changePaperStatus)
+	admin.Get("/camera-ready", allow(PermView), a.cameraReadyAdminView)
+	admin.Post("/book", allow(PermManage), a.buildBookOfAbstracts)
+	admin.Get("/schedule", allow(PermView), a.scheduleAdminView)
+	admin.Post("/schedule/days", allow(PermManage), a.createScheduleDay)
+	admin.Post("/schedule/days/:id/delete", allow(PermManage), a.deleteScheduleDay)
+	admin.Post("/schedule/rooms", allow(PermManage), a.createRoom)
+	admin.Post("/schedule/rooms/:id/delete", allow(PermManage), a.deleteRoom)
+	admin.Post("/schedule/sessions", allow(PermManage), a.createScheduleSession)
+	admin.Post("/schedule/sessions/:id/delete", allow(PermManage), a.deleteScheduleSession)
+	admin.Post("/schedule/slots", allow(PermManage), a.createSlot)
+	admin.Post("/schedule/slots/:id/delete", allow(PermManage), a.deleteSlot)
+	admin.Post("/assignments/:id/delete", allow(PermManage), a.deleteAssignment)
+	admin.Get("/audit", allow(PermUsers), a.auditView)
+	admin.Get("/users", allow(PermUsers), a.adminUsersView)
+	admin.Post("/users", allow(PermUsers), a.createAdminUser)
+	admin.Post("/users/:id", allow(PermUsers), a.updateAdminUser)
 
 	s.Use(a.notFoundView)
 }
@@ -211,8 +360,6 @@ func main() {
 				return fmt.Errorf("error in load env serve: %w", err)
 			}
 
-			fmt.Println(config)
-
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -262,7 +409,7 @@ func main() {
 	command.PersistentFlags().StringVar(&config.DiskPath, "disk-path", "", "")
 	command.MarkPersistentFlagRequired("db-url")
 	command.MarkPersistentFlagRequired("disk-path")
-	command.AddCommand(serveCmd)
+	command.AddCommand(serveCmd, adminCommand(config, logger), auditCommand(config, logger))
 
 	command.Execute()
 }
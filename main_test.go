@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestApp returns an App with a migrated database of its own and files
+// kept in memory.
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateDatabase(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return &App{
+		db:     db,
+		log:    &Logger{zap.NewNop().Sugar()},
+		disk:   NewMemDisk(),
+		config: &Config{},
+	}
+}
+
+func TestClientAddress(t *testing.T) {
+	for _, test := range []struct {
+		config Config
+		want   string
+	}{
+		{Config{TrustedProxies: []string{"127.0.0.1", "::1"}}, "0.0.0.0"},
+		{Config{TrustedProxies: []string{"0.0.0.0/8"}}, "203.0.113.7"},
+		// Requests through the unix socket come from a local proxy.
+		{Config{HTTPAddressUnix: "amtc.sock"}, "203.0.113.7"},
+	} {
+		server := newServer(&test.config)
+		server.Get("/", func(c *fiber.Ctx) error { return c.SendString(c.IP()) })
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Real-IP", "203.0.113.7")
+		resp, err := server.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip, _ := io.ReadAll(resp.Body)
+		if string(ip) != test.want {
+			t.Errorf("client address with %+v is %s, want %s", test.config, ip, test.want)
+		}
+	}
+}
+
+func TestCSRFFormField(t *testing.T) {
+	a := newTestApp(t)
+	a.server = newServer(a.config)
+	a.registerRoutes()
+
+	resp, err := a.server.Test(httptest.NewRequest(http.MethodGet, "/admin/login", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrf := cookie(resp, "csrf")
+	if csrf == nil {
+		t.Fatal("no CSRF cookie on the sign in page")
+	}
+
+	post := func(form url.Values, header string) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/login", strings.NewReader(form.Encode()))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+		if header != "" {
+			req.Header.Set("X-Csrf-Token", header)
+		}
+		req.AddCookie(csrf)
+		resp, err := a.server.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	form := url.Values{"username": {"olga"}, "password": {"wrong password"}}
+	if status := post(form, ""); status != fiber.StatusForbidden {
+		t.Errorf("form without _csrf is %d, want 403", status)
+	}
+	if status := post(form, csrf.Value); status != fiber.StatusForbidden {
+		t.Errorf("token in the header instead of the form is %d, want 403", status)
+	}
+	form.Set("_csrf", "forged")
+	if status := post(form, ""); status != fiber.StatusForbidden {
+		t.Errorf("form with a wrong _csrf is %d, want 403", status)
+	}
+	form.Set("_csrf", csrf.Value)
+	if status := post(form, ""); status == fiber.StatusForbidden {
+		t.Error("form with _csrf is rejected")
+	}
+}
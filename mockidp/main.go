@@ -0,0 +1,37 @@
+// Command mockidp is an OpenID Connect provider for trying admin single
+// sign-on locally. The sign in page takes any subject, email and groups.
+//
+//	go run ./mockidp -addr 127.0.0.1:9000 -client-id amtc -client-secret secret
+//
+// It keeps everything in memory and signs tokens with a key generated on
+// start. Never expose it.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/rmnvlv/Web-Arctic/internal/mockidp"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:9000", "listen address")
+	issuer := flag.String("issuer", "", "issuer URL, http://<addr> by default")
+	clientID := flag.String("client-id", "amtc", "client ID")
+	clientSecret := flag.String("client-secret", "", "client secret, empty for a public client")
+	groupsInUserinfo := flag.Bool("groups-in-userinfo", false, "leave groups out of the ID token, like many providers")
+	flag.Parse()
+
+	if *issuer == "" {
+		*issuer = "http://" + *addr
+	}
+
+	p, err := mockidp.New(*issuer, *clientID, *clientSecret, *groupsInUserinfo)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Mock identity provider %s for client %s", p.Issuer(), p.ClientID())
+	log.Fatal(http.ListenAndServe(*addr, p.Handler()))
+}
@@ -1,5 +1,10 @@
 package main
 
+import (
+	"strings"
+	"time"
+)
+
 type Participant struct {
 	CreatedAt    string
 	Token        string `gorm:"primaryKey"`
@@ -18,3 +23,277 @@ type Participant struct {
 
 	PresentationTitle string
 }
+
+// ParticipantNote is a note of the organizers about a participant.
+type ParticipantNote struct {
+	ID               uint `gorm:"primaryKey"`
+	CreatedAt        time.Time
+	ParticipantToken string `gorm:"index"`
+	Author           string
+	Text             string
+}
+
+// AuditEvent is an entry of the audit log of the admin panel, entries are
+// never changed or deleted. Action is "<object>.<verb>", Changes is a JSON
+// list of changed fields with old and new values.
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+	Actor     string    `gorm:"index"`
+	IP        string
+	Action    string `gorm:"index"`
+	Target    string
+	Changes   string
+}
+
+// EmailLog is an email sent to a participant or a reviewer, Error is empty
+// when it was delivered to the SMTP server.
+type EmailLog struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	To        string `gorm:"index"`
+	Subject   string
+	Error     string
+}
+
+// ParticipantImport is a spreadsheet of participants uploaded by the
+// committee. Rows are its cells as JSON, the header first. An import is
+// applied once, Result sums it up.
+type ParticipantImport struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	Author    string
+	FileName  string
+	Rows      string
+	AppliedAt *time.Time
+	Result    string
+}
+
+// Submission is a version of a file uploaded by a participant.
+type Submission struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	Token     string `gorm:"index"`
+	// tezis|article|open-upload
+	Type string
+	// Artifact is a camera-ready item of the paper (final|copyright|slides),
+	// empty for the paper itself. Artifacts are versioned separately.
+	Artifact     string `gorm:"not null;default:''"`
+	Version      int
+	OriginalName string
+	Path         string
+	Size         int64
+	SHA256       string
+
+	// Result of the check against the article template.
+	ComplianceChecked  bool
+	ComplianceWarnings string
+	Pages              int
+	Words              int
+	Title              string
+
+	// Unverified open uploads were attached to a registered participant by
+	// the email typed in the form, nobody checked the uploader owns it.
+	Unverified bool
+}
+
+func (s Submission) Warnings() []string {
+	if s.ComplianceWarnings == "" {
+		return nil
+	}
+	return strings.Split(s.ComplianceWarnings, "\n")
+}
+
+// QuarantinedFile is an upload rejected by the antivirus check.
+type QuarantinedFile struct {
+	ID           uint `gorm:"primaryKey"`
+	CreatedAt    time.Time
+	Path         string
+	OriginalName string
+	Email        string
+	Reason       string
+}
+
+// Reviewer is a member of the programme committee. Reviewers sign in to
+// their portal with Token like participants do.
+type Reviewer struct {
+	ID           uint `gorm:"primaryKey"`
+	CreatedAt    time.Time
+	Token        string `gorm:"uniqueIndex"`
+	Name         string
+	Surname      string
+	Email        string
+	Organization string
+	Disabled     bool
+	// Sections are presentation sections the reviewer is an expert in, one
+	// per line as they contain commas.
+	Sections string
+	// Keywords and Conflicts are comma separated. Conflicts are emails, names
+	// or organizations the reviewer must not review.
+	Keywords  string
+	Conflicts string
+}
+
+// ReviewCriterion is a scored question of the review form.
+type ReviewCriterion struct {
+	ID          uint `gorm:"primaryKey"`
+	Name        string
+	Description string
+	MaxScore    int
+	Position    int
+}
+
+// Assignment asks a reviewer to review the latest version of a participant's
+// upload of Type.
+type Assignment struct {
+	ID               uint `gorm:"primaryKey"`
+	CreatedAt        time.Time
+	ReviewerID       uint   `gorm:"index"`
+	ParticipantToken string `gorm:"index"`
+	Type             string
+	// Draft assignments are proposed by the automatic assignment and are not
+	// visible to reviewers until committed.
+	Draft bool
+}
+
+type Review struct {
+	ID           uint `gorm:"primaryKey"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	AssignmentID uint `gorm:"uniqueIndex"`
+	// Scores are JSON encoded by criterion ID.
+	Scores              string
+	Recommendation      string
+	CommentsToAuthors   string
+	CommentsToCommittee string
+	Submitted           bool
+}
+
+// StatusChange is a transition of the status of a paper, the latest one is
+// the current status.
+type StatusChange struct {
+	ID               uint `gorm:"primaryKey"`
+	CreatedAt        time.Time
+	ParticipantToken string `gorm:"index"`
+	Type             string
+	From             string
+	To               string
+	// Actor is the admin or the participant email who made the change.
+	Actor string
+	Note  string
+}
+
+type ScheduleDay struct {
+	ID    uint `gorm:"primaryKey"`
+	Date  time.Time
+	Title string
+}
+
+type Room struct {
+	ID   uint `gorm:"primaryKey"`
+	Name string
+}
+
+// ScheduleSession is a session of the programme in a room, Name is one of
+// ConferenceSessions or a free text like «Coffee break».
+type ScheduleSession struct {
+	ID     uint `gorm:"primaryKey"`
+	DayID  uint `gorm:"index"`
+	RoomID uint
+	Name   string
+	Start  time.Time
+	End    time.Time
+	Chair  string
+}
+
+// Slot is a talk of a session. Talks of accepted papers refer to the paper,
+// others like keynotes only have a title and a speaker.
+type Slot struct {
+	ID               uint `gorm:"primaryKey"`
+	SessionID        uint `gorm:"index"`
+	Start            time.Time
+	End              time.Time
+	Title            string
+	Speaker          string
+	ParticipantToken string `gorm:"index"`
+	Type             string
+	Keynote          bool
+}
+
+// AdminUser is an account of the admin panel. Role is one of Roles.
+type AdminUser struct {
+	ID           uint `gorm:"primaryKey"`
+	CreatedAt    time.Time
+	Username     string `gorm:"uniqueIndex"`
+	PasswordHash string
+	Role         string
+	Disabled     bool
+	// TOTPSecret is the base32 secret of the authenticator app, kept while
+	// enrolling before TOTPEnabled. TOTPLastStep is the time step of the last
+	// accepted code, codes are not accepted twice.
+	TOTPSecret   string
+	TOTPEnabled  bool
+	TOTPLastStep int64
+	// OIDCSubject is the subject at the identity provider of accounts
+	// signing in with single sign-on.
+	OIDCSubject string `gorm:"column:oidc_subject;index:idx_admin_users_oidc_subject"`
+}
+
+// RecoveryCode signs in instead of a one-time code once. Hash is the SHA-256
+// of the code.
+type RecoveryCode struct {
+	ID          uint `gorm:"primaryKey"`
+	AdminUserID uint `gorm:"index"`
+	Hash        string
+	UsedAt      *time.Time
+}
+
+// TrustedDevice skips the second factor on a browser until ExpiresAt. ID is
+// the SHA-256 of the device cookie.
+type TrustedDevice struct {
+	ID          string `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	AdminUserID uint `gorm:"index"`
+	UserAgent   string
+}
+
+// AdminSession is a signed in admin. ID is the SHA-256 of the session cookie,
+// so the table doesn't hold usable credentials.
+type AdminSession struct {
+	ID         string `gorm:"primaryKey"`
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	Username   string `gorm:"index"`
+	IP         string
+	UserAgent  string
+	RevokedAt  *time.Time
+	// MFAPending sessions passed the password and wait for the second factor.
+	MFAPending bool
+	// SSO sessions signed in at the identity provider.
+	SSO bool
+}
+
+// OIDCLogin is a single sign-on in progress, between the redirect to the
+// identity provider and the callback. ID is the SHA-256 of the state.
+type OIDCLogin struct {
+	ID        string `gorm:"primaryKey"`
+	CreatedAt time.Time
+	Nonce     string
+	Verifier  string
+	Next      string
+}
+
+func (OIDCLogin) TableName() string {
+	return "oidc_logins"
+}
+
+// LoginAttempt is a sign in to the admin panel, failures lock the account
+// and the address out for a while.
+type LoginAttempt struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+	Username  string    `gorm:"index"`
+	IP        string    `gorm:"index"`
+	Success   bool
+}
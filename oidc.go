@@ -0,0 +1,743 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateCookie = "amtc_oidc"
+	// oidcLoginLifetime is the time to sign in at the identity provider.
+	oidcLoginLifetime = 10 * time.Minute
+	// oidcClockSkew is allowed between us and the identity provider.
+	oidcClockSkew = time.Minute
+	// oidcDiscoveryTTL is how long the provider metadata is cached.
+	oidcDiscoveryTTL = time.Hour
+	// oidcKeysRefresh limits refetching the keys on unknown key IDs.
+	oidcKeysRefresh = time.Minute
+)
+
+var ErrOIDCNotAllowed = errors.New("oidc: the account has no access to the admin panel")
+
+// OIDCProvider signs admins in with the authorization code flow and PKCE.
+// Metadata and keys are fetched on first use, so the server starts while
+// the identity provider is down.
+type OIDCProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery oidcDiscovery
+	fetchedAt time.Time
+	keys      map[string]crypto.PublicKey
+	keysAt    time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+type oidcTokens struct {
+	IDToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// OIDCClaims are claims of the ID token and the userinfo endpoint.
+type OIDCClaims map[string]interface{}
+
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *OIDCProvider) getJSON(rawURL, bearer string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("oidc: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s: %s", rawURL, resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// discover returns the provider metadata, p.mu must be held.
+func (p *OIDCProvider) discover() (oidcDiscovery, error) {
+	if !p.fetchedAt.IsZero() && time.Since(p.fetchedAt) < oidcDiscoveryTTL {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := p.getJSON(strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", "", &d); err != nil {
+		return d, err
+	}
+	if d.Issuer != p.config.Issuer {
+		return d, fmt.Errorf("oidc: issuer of the metadata is %q, not %q", d.Issuer, p.config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return d, errors.New("oidc: metadata misses endpoints")
+	}
+
+	p.discovery, p.fetchedAt = d, time.Now()
+	return d, nil
+}
+
+func (p *OIDCProvider) metadata() (oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.discover()
+}
+
+// AuthURL is the authorization endpoint to send the browser to.
+func (p *OIDCProvider) AuthURL(state, nonce, verifier string) (string, error) {
+	d, err := p.metadata()
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.config.ClientID)
+	v.Set("redirect_uri", p.config.RedirectURL)
+	v.Set("scope", strings.Join(p.config.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", pkceChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Exchange redeems the authorization code at the token endpoint.
+func (p *OIDCProvider) Exchange(code, verifier string) (oidcTokens, error) {
+	var tokens oidcTokens
+
+	d, err := p.metadata()
+	if err != nil {
+		return tokens, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.config.ClientID)
+
+	// client_secret_basic is the default, some providers only take the
+	// secret in the form.
+	basic := len(d.TokenAuthMethods) == 0 || contains(d.TokenAuthMethods, "client_secret_basic")
+	if p.config.ClientSecret != "" && !basic {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokens, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" && basic {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return tokens, fmt.Errorf("oidc: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil && resp.StatusCode == http.StatusOK {
+		return tokens, fmt.Errorf("oidc: token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return tokens, fmt.Errorf("oidc: token endpoint: %s %s %s", resp.Status, tokens.Error, tokens.Description)
+	}
+	if tokens.IDToken == "" {
+		return tokens, errors.New("oidc: token response has no id_token")
+	}
+
+	return tokens, nil
+}
+
+// Userinfo fetches claims of the access token.
+func (p *OIDCProvider) Userinfo(accessToken string) (OIDCClaims, error) {
+	d, err := p.metadata()
+	if err != nil {
+		return nil, err
+	}
+	if d.UserinfoEndpoint == "" {
+		return nil, nil
+	}
+
+	var claims OIDCClaims
+	if err := p.getJSON(d.UserinfoEndpoint, accessToken, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// key returns the signing key, refetching the key set for unknown IDs as
+// the provider rotates keys.
+func (p *OIDCProvider) key(kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+	if !p.keysAt.IsZero() && time.Since(p.keysAt) < oidcKeysRefresh {
+		return nil, fmt.Errorf("oidc: unknown key %q", kid)
+	}
+
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(d.JWKSURI, "", &set); err != nil {
+		return nil, err
+	}
+
+	p.keys, p.keysAt = make(map[string]crypto.PublicKey), time.Now()
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		p.keys[k.Kid] = key
+	}
+
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown key %q", kid)
+}
+
+// findKey looks the key up, tokens without an ID use the only key.
+func (p *OIDCProvider) findKey(kid string) crypto.PublicKey {
+	if key, ok := p.keys[kid]; ok {
+		return key
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("oidc: bad key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("oidc: bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("oidc: unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("oidc: EC key is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("oidc: unsupported key type %q", k.Kty)
+}
+
+var jwsHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verifyJWS checks the signature of a token. Only asymmetric algorithms
+// are accepted, "none" and HMAC would let anyone with the client secret,
+// or no one at all, sign tokens.
+func verifyJWS(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	hash, ok := jwsHashes[alg]
+	if !ok {
+		return fmt.Errorf("oidc: unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] == 'R' {
+			return rsa.VerifyPKCS1v15(key, hash, digest, sig)
+		}
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(key, hash, digest, sig, nil)
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[0] == 'E' && len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(key, digest, r, s) {
+				return nil
+			}
+			return errors.New("oidc: bad signature")
+		}
+	}
+
+	return fmt.Errorf("oidc: key doesn't match algorithm %q", alg)
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("oidc: malformed token: %w", err)
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Verify checks the signature and the claims of an ID token.
+func (p *OIDCProvider) Verify(idToken, nonce string, now time.Time) (OIDCClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("oidc: malformed token: %w", err)
+	}
+	if _, ok := jwsHashes[header.Alg]; !ok {
+		return nil, fmt.Errorf("oidc: unsupported algorithm %q", header.Alg)
+	}
+
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWS(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims OIDCClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	if claims.String("iss") != p.config.Issuer {
+		return nil, fmt.Errorf("oidc: token of issuer %q", claims.String("iss"))
+	}
+	audience := claims.Strings("aud")
+	if !contains(audience, p.config.ClientID) {
+		return nil, errors.New("oidc: token is not for this client")
+	}
+	if len(audience) > 1 && claims.String("azp") != p.config.ClientID {
+		return nil, errors.New("oidc: token is authorized for another party")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("oidc: token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("oidc: token is not valid yet")
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.String("nonce")), []byte(nonce)) != 1 {
+		return nil, errors.New("oidc: nonce doesn't match")
+	}
+	if claims.String("sub") == "" {
+		return nil, errors.New("oidc: token has no subject")
+	}
+
+	return claims, nil
+}
+
+// lookup finds a claim by a dotted path.
+func (c OIDCClaims) lookup(path string) interface{} {
+	var v interface{} = map[string]interface{}(c)
+	for _, name := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[name]
+	}
+	return v
+}
+
+func (c OIDCClaims) String(name string) string {
+	s, _ := c.lookup(name).(string)
+	return s
+}
+
+// Strings reads claims that are a string or a list of strings.
+func (c OIDCClaims) Strings(name string) []string {
+	switch v := c.lookup(name).(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// Email is the lowercase email when the provider says it's verified. It
+// links accounts and grants roles by the domain, anyone can claim an
+// unverified one.
+func (c OIDCClaims) Email() string {
+	if verified, _ := c["email_verified"].(bool); !verified {
+		return ""
+	}
+	return strings.ToLower(c.String("email"))
+}
+
+// Role is the most privileged role the groups and the email domain map to.
+func (c OIDCConfig) Role(claims OIDCClaims) string {
+	granted := make(map[string]bool)
+	for _, group := range claims.Strings(c.GroupsClaim) {
+		if role, ok := c.RoleGroups[group]; ok {
+			granted[role] = true
+		}
+	}
+	if email := claims.Email(); strings.Contains(email, "@") {
+		if role, ok := c.RoleDomains[email[strings.LastIndex(email, "@")+1:]]; ok {
+			granted[role] = true
+		}
+	}
+
+	for _, role := range Roles {
+		if granted[role] {
+			return role
+		}
+	}
+	return ""
+}
+
+// MapsRoles is true when the identity provider decides the roles. Without
+// mappings only accounts created here sign in, with their own roles.
+func (c OIDCConfig) MapsRoles() bool {
+	return len(c.RoleGroups) > 0 || len(c.RoleDomains) > 0
+}
+
+// oidcUser finds the account of a single sign-on. Accounts are matched by
+// the subject, or by the email on the first sign in, and created when
+// AutoCreate is on.
+func (a *App) oidcUser(claims OIDCClaims) (AdminUser, error) {
+	sub := claims.String("sub")
+	email := claims.Email()
+	role := a.config.OIDC.Role(claims)
+
+	var user AdminUser
+	err := a.db.Where("oidc_subject = ?", sub).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) && email != "" {
+		err = a.db.Where("username = ? AND (oidc_subject = '' OR oidc_subject IS NULL)", email).First(&user).Error
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if !a.config.OIDC.AutoCreate || role == "" {
+			return user, fmt.Errorf("%w: no account for %s", ErrOIDCNotAllowed, sub)
+		}
+
+		username := email
+		if username == "" {
+			username = claims.String("preferred_username")
+		}
+		if username == "" {
+			username = sub
+		}
+
+		var taken int64
+		if err := a.db.Model(&AdminUser{}).Where("username = ?", username).Count(&taken).Error; err != nil {
+			return user, err
+		}
+		if taken > 0 {
+			return user, fmt.Errorf("%w: username %s is taken by another account", ErrOIDCNotAllowed, username)
+		}
+
+		user = AdminUser{Username: username, Role: role, OIDCSubject: sub}
+		if err := a.db.Create(&user).Error; err != nil {
+			return user, err
+		}
+		a.log.Infof("Created admin account %s with role %s on single sign-on", user.Username, user.Role)
+		return user, nil
+	}
+	if err != nil {
+		return user, err
+	}
+
+	if user.Disabled {
+		return user, fmt.Errorf("%w: %s is disabled", ErrOIDCNotAllowed, user.Username)
+	}
+
+	updates := make(map[string]interface{})
+	if user.OIDCSubject == "" {
+		updates["oidc_subject"] = sub
+	}
+	if a.config.OIDC.MapsRoles() {
+		if role == "" {
+			return user, fmt.Errorf("%w: no role for %s", ErrOIDCNotAllowed, user.Username)
+		}
+		if role != user.Role {
+			a.log.Infof("Role of admin account %s changed from %s to %s by single sign-on", user.Username, user.Role, role)
+			updates["role"] = role
+		}
+	}
+	if len(updates) > 0 {
+		if err := a.db.Model(&user).Updates(updates).Error; err != nil {
+			return user, err
+		}
+	}
+
+	return user, nil
+}
+
+func (a *App) setOIDCCookie(c *fiber.Ctx, value string, expires time.Time) {
+	// Lax, the callback is a navigation from the identity provider.
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/admin/login/oidc",
+		Expires:  expires,
+		Secure:   strings.HasPrefix(a.config.Domain, "https://"),
+		HTTPOnly: true,
+		SameSite: "Lax",
+	})
+}
+
+// oidcLogin sends the browser to the identity provider.
+func (a *App) oidcLogin(c *fiber.Ctx) error {
+	now := time.Now()
+
+	// Forget abandoned sign ins.
+	if err := a.db.Where("created_at < ?", now.Add(-oidcLoginLifetime)).Delete(&OIDCLogin{}).Error; err != nil {
+		a.log.Error(err)
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		token, err := newSessionToken()
+		if err != nil {
+			return err
+		}
+		secrets[i] = token
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	authURL, err := a.oidc.AuthURL(state, nonce, verifier)
+	if err != nil {
+		a.log.Error(err)
+		return a.renderLogin(c, "", "Single sign-on is not available, try again later.")
+	}
+
+	login := OIDCLogin{ID: sha256Hex(state), CreatedAt: now, Nonce: nonce, Verifier: verifier, Next: adminNext(c.Query("next"))}
+	if err := a.db.Create(&login).Error; err != nil {
+		return err
+	}
+
+	a.setOIDCCookie(c, state, now.Add(oidcLoginLifetime))
+
+	return c.Redirect(authURL)
+}
+
+// oidcCallback finishes the sign in at the identity provider.
+func (a *App) oidcCallback(c *fiber.Ctx) error {
+	state := c.Cookies(oidcStateCookie)
+	a.setOIDCCookie(c, "", time.Unix(0, 0))
+
+	if e := c.Query("error"); e != "" {
+		a.log.Infof("Single sign-on failed at the identity provider: %s %s", e, c.Query("error_description"))
+		return a.renderLogin(c, "", "Sign in at the identity provider failed or was cancelled.")
+	}
+
+	expired := func() error {
+		return a.renderLogin(c, "", "Single sign-on expired or was started in another browser, try again.")
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		return expired()
+	}
+
+	var login OIDCLogin
+	if err := a.db.Where("id = ?", sha256Hex(state)).First(&login).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			a.log.Error(err)
+		}
+		return expired()
+	}
+	// A sign in completes once.
+	if result := a.db.Delete(&login); result.Error != nil || result.RowsAffected != 1 {
+		return expired()
+	}
+	if time.Since(login.CreatedAt) > oidcLoginLifetime {
+		return expired()
+	}
+
+	tokens, err := a.oidc.Exchange(c.Query("code"), login.Verifier)
+	if err != nil {
+		a.log.Error(err)
+		return a.renderLogin(c, "", "Can't sign in, try again later.")
+	}
+	claims, err := a.oidc.Verify(tokens.IDToken, login.Nonce, time.Now())
+	if err != nil {
+		a.log.Error(err)
+		return a.renderLogin(c, "", "Can't sign in, try again later.")
+	}
+
+	// Providers often leave groups out of the ID token.
+	if tokens.AccessToken != "" && (claims.lookup(a.config.OIDC.GroupsClaim) == nil || claims["email"] == nil) {
+		info, err := a.oidc.Userinfo(tokens.AccessToken)
+		if err != nil {
+			a.log.Error(err)
+		}
+		if info.String("sub") == claims.String("sub") {
+			for name, v := range info {
+				if _, ok := claims[name]; !ok {
+					claims[name] = v
+				}
+			}
+		}
+	}
+
+	user, err := a.oidcUser(claims)
+	if err != nil {
+		if errors.Is(err, ErrOIDCNotAllowed) {
+			a.log.Info(err)
+			a.auditAs(claims.Email(), c.IP(), "login.failed", "subject "+claims.String("sub"), nil, map[string]string{"method": "sso"})
+			return a.renderLogin(c, "", "Your account has no access to the admin panel.")
+		}
+		a.log.Error(err)
+		return a.renderLogin(c, "", "Can't sign in, try again later.")
+	}
+
+	// Accounts with their own second factor keep it, the identity provider
+	// doesn't know about it.
+	token, session, err := a.startSession(AdminSession{
+		Username:   user.Username,
+		IP:         c.IP(),
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		SSO:        true,
+		MFAPending: user.TOTPEnabled && !a.deviceTrusted(c.Cookies(trustedDeviceCookie), user),
+	})
+	if err != nil {
+		return err
+	}
+	a.setSessionCookie(c, token, time.Now().Add(AdminSessionLifetime))
+
+	next := adminNext(login.Next)
+	if session.MFAPending {
+		next = "/admin/login/totp?next=" + url.QueryEscape(next)
+	} else {
+		a.auditAs(user.Username, c.IP(), "login", "user "+user.Username, nil, map[string]string{"method": "sso"})
+	}
+
+	// Browsers hold back the strict session cookie on redirects started at
+	// another site, the page continues from here.
+	return c.Render("admin-login-redirect", fiber.Map{"Next": next}, "")
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pquerna/otp/totp"
+	"github.com/rmnvlv/Web-Arctic/internal/mockidp"
+)
+
+// ssoTest runs the authorization code flow against the mock identity
+// provider.
+type ssoTest struct {
+	t   *testing.T
+	a   *App
+	idp *httptest.Server
+}
+
+func newSSOTest(t *testing.T, configure func(*OIDCConfig)) *ssoTest {
+	idp := httptest.NewUnstartedServer(nil)
+	issuer := "http://" + idp.Listener.Addr().String()
+	provider, err := mockidp.New(issuer, "amtc", "secret", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.Config.Handler = provider.Handler()
+	idp.Start()
+	t.Cleanup(idp.Close)
+
+	a := newTestApp(t)
+	a.config.Domain = "http://amtc.test"
+	a.config.OIDC = OIDCConfig{
+		Issuer:       issuer,
+		ClientID:     "amtc",
+		ClientSecret: "secret",
+		RedirectURL:  "http://amtc.test/admin/login/oidc/callback",
+		Scopes:       []string{"openid", "email", "profile", "groups"},
+		Label:        "Sign in with the university account",
+		GroupsClaim:  "groups",
+	}
+	if configure != nil {
+		configure(&a.config.OIDC)
+	}
+	a.oidc = NewOIDCProvider(a.config.OIDC)
+	a.server = newServer(a.config)
+	a.registerRoutes()
+
+	return &ssoTest{t: t, a: a, idp: idp}
+}
+
+func (s *ssoTest) do(req *http.Request) *http.Response {
+	s.t.Helper()
+	resp, err := s.a.server.Test(req, -1)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return resp
+}
+
+func cookie(resp *http.Response, name string) *http.Cookie {
+	for _, c := range resp.Cookies() {
+		if c.Name == name && c.Value != "" {
+			return c
+		}
+	}
+	return nil
+}
+
+// authorize starts a sign in and submits the form of the identity provider
+// with user, it returns the callback URL and the state cookie.
+func (s *ssoTest) authorize(user url.Values) (*url.URL, *http.Cookie) {
+	s.t.Helper()
+
+	resp := s.do(httptest.NewRequest(http.MethodGet, "/admin/login/oidc", nil))
+	if resp.StatusCode != http.StatusFound {
+		s.t.Fatalf("sign in start status is %d", resp.StatusCode)
+	}
+	state := cookie(resp, oidcStateCookie)
+	if state == nil {
+		s.t.Fatal("sign in start sets no state cookie")
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.PostForm(resp.Header.Get(fiber.HeaderLocation), user)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+	if err != nil || callback.Path != "/admin/login/oidc/callback" {
+		s.t.Fatalf("identity provider redirected to %q", resp.Header.Get(fiber.HeaderLocation))
+	}
+	return callback, state
+}
+
+func (s *ssoTest) callback(callback *url.URL, state *http.Cookie) (*http.Response, string) {
+	s.t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	if state != nil {
+		req.AddCookie(state)
+	}
+	resp := s.do(req)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return resp, string(body)
+}
+
+// signIn runs the whole flow and returns the admin session cookie, nil when
+// the sign in failed.
+func (s *ssoTest) signIn(user url.Values) (*http.Cookie, string) {
+	s.t.Helper()
+	resp, body := s.callback(s.authorize(user))
+	return cookie(resp, adminSessionCookie), body
+}
+
+func ssoUser(sub, email string, groups ...string) url.Values {
+	return url.Values{
+		"action":         {"allow"},
+		"sub":            {sub},
+		"email":          {email},
+		"email_verified": {"true"},
+		"name":           {"Olga Organizer"},
+		"groups":         {strings.Join(groups, ",")},
+	}
+}
+
+func TestOIDCSignInLinksAccount(t *testing.T) {
+	s := newSSOTest(t, nil)
+	if err := s.a.db.Create(&AdminUser{Username: "olga@university.example", Role: RoleOrganizer}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	session, body := s.signIn(ssoUser("user-1", "Olga@University.example"))
+	if session == nil {
+		t.Fatalf("no session after sign in: %s", body)
+	}
+
+	var user AdminUser
+	if err := s.a.db.Where("username = ?", "olga@university.example").First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.OIDCSubject != "user-1" || user.Role != RoleOrganizer {
+		t.Errorf("account after sign in is %+v", user)
+	}
+
+	var stored AdminSession
+	if err := s.a.db.Where("id = ?", sha256Hex(session.Value)).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !stored.SSO || stored.Username != user.Username {
+		t.Errorf("session is %+v", stored)
+	}
+
+	// The session opens the admin panel, without it the browser is sent to
+	// sign in.
+	req := httptest.NewRequest(http.MethodGet, "/admin/dashboard.json", nil)
+	req.AddCookie(session)
+	if resp := s.do(req); resp.Header.Get(fiber.HeaderContentType) != fiber.MIMEApplicationJSON {
+		t.Errorf("admin panel with the session is %d %s", resp.StatusCode, resp.Header.Get(fiber.HeaderLocation))
+	}
+	if resp := s.do(httptest.NewRequest(http.MethodGet, "/admin/dashboard.json", nil)); resp.StatusCode != http.StatusFound {
+		t.Errorf("admin panel without a session is %d", resp.StatusCode)
+	}
+
+	// Later sign ins find the account by the subject.
+	if session, body := s.signIn(ssoUser("user-1", "olga.new@university.example")); session == nil {
+		t.Errorf("no session on the second sign in: %s", body)
+	}
+}
+
+func TestOIDCSignInWithoutAccount(t *testing.T) {
+	s := newSSOTest(t, nil)
+
+	session, body := s.signIn(ssoUser("user-2", "stranger@example.com", "amtc-organizers"))
+	if session != nil || !strings.Contains(body, "no access to the admin panel") {
+		t.Errorf("sign in without an account: %s", body)
+	}
+}
+
+func TestOIDCUnverifiedEmail(t *testing.T) {
+	s := newSSOTest(t, func(c *OIDCConfig) {
+		c.AutoCreate = true
+		c.RoleDomains = map[string]string{"university.example": RoleChair}
+	})
+	if err := s.a.db.Create(&AdminUser{Username: "olga@university.example", Role: RoleOrganizer}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither links the account of the email nor gets the role of its domain.
+	user := ssoUser("user-5", "olga@university.example")
+	user.Del("email_verified")
+	if session, body := s.signIn(user); session != nil || !strings.Contains(body, "no access to the admin panel") {
+		t.Errorf("sign in with an unverified email: %s", body)
+	}
+	var linked int64
+	s.a.db.Model(&AdminUser{}).Where("oidc_subject = ?", "user-5").Count(&linked)
+	if linked != 0 {
+		t.Error("unverified email linked an account")
+	}
+
+	user.Set("email_verified", "true")
+	if session, body := s.signIn(user); session == nil {
+		t.Errorf("no session with the verified email: %s", body)
+	}
+}
+
+func TestOIDCSecondFactor(t *testing.T) {
+	s := newSSOTest(t, nil)
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: "olga@university.example", Period: totpPeriod})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := AdminUser{Username: "olga@university.example", Role: RoleOrganizer, TOTPSecret: key.Secret(), TOTPEnabled: true}
+	if err := s.a.db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	session, body := s.signIn(ssoUser("user-1", "olga@university.example"))
+	if session == nil || !strings.Contains(body, "/admin/login/totp?next=") {
+		t.Fatalf("sign in of an account with a second factor: %s", body)
+	}
+
+	// The session waits for the code.
+	req := httptest.NewRequest(http.MethodGet, "/admin/dashboard.json", nil)
+	req.AddCookie(session)
+	if resp := s.do(req); !strings.HasPrefix(resp.Header.Get(fiber.HeaderLocation), "/admin/login/totp") {
+		t.Errorf("admin panel before the code is %d %s", resp.StatusCode, resp.Header.Get(fiber.HeaderLocation))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/login/totp", nil)
+	req.AddCookie(session)
+	csrf := cookie(s.do(req), "csrf")
+	if csrf == nil {
+		t.Fatal("no CSRF cookie on the code page")
+	}
+	code, err := totp.GenerateCodeCustom(key.Secret(), time.Now(), totpOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/admin/login/totp", strings.NewReader(url.Values{"_csrf": {csrf.Value}, "code": {code}}.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	req.AddCookie(session)
+	req.AddCookie(csrf)
+	if resp := s.do(req); resp.Header.Get(fiber.HeaderLocation) != "/admin" {
+		t.Fatalf("code is %d %s", resp.StatusCode, resp.Header.Get(fiber.HeaderLocation))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/dashboard.json", nil)
+	req.AddCookie(session)
+	if resp := s.do(req); resp.Header.Get(fiber.HeaderContentType) != fiber.MIMEApplicationJSON {
+		t.Errorf("admin panel after the code is %d %s", resp.StatusCode, resp.Header.Get(fiber.HeaderLocation))
+	}
+}
+
+func TestOIDCSecondFactorRequired(t *testing.T) {
+	s := newSSOTest(t, nil)
+	s.a.config.TOTPRequiredRoles = []string{RoleOrganizer}
+	if err := s.a.db.Create(&AdminUser{Username: "olga@university.example", Role: RoleOrganizer}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	session, body := s.signIn(ssoUser("user-1", "olga@university.example"))
+	if session == nil {
+		t.Fatalf("no session after sign in: %s", body)
+	}
+
+	// Single sign-on doesn't skip enrollment.
+	req := httptest.NewRequest(http.MethodGet, "/admin/dashboard.json", nil)
+	req.AddCookie(session)
+	if resp := s.do(req); resp.Header.Get(fiber.HeaderLocation) != "/admin/totp" {
+		t.Errorf("admin panel without a second factor is %d %s", resp.StatusCode, resp.Header.Get(fiber.HeaderLocation))
+	}
+}
+
+func TestOIDCAutoCreate(t *testing.T) {
+	s := newSSOTest(t, func(c *OIDCConfig) {
+		c.AutoCreate = true
+		c.RoleGroups = map[string]string{"amtc-organizers": RoleOrganizer, "amtc-chairs": RoleChair}
+	})
+
+	// Groups come from the userinfo endpoint.
+	session, body := s.signIn(ssoUser("user-3", "chair@university.example", "amtc-chairs", "staff"))
+	if session == nil {
+		t.Fatalf("no session after sign in: %s", body)
+	}
+	var user AdminUser
+	if err := s.a.db.Where("oidc_subject = ?", "user-3").First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "chair@university.example" || user.Role != RoleChair {
+		t.Errorf("created account is %+v", user)
+	}
+
+	// Roles follow the groups.
+	if session, body := s.signIn(ssoUser("user-3", "chair@university.example", "amtc-organizers")); session == nil {
+		t.Fatalf("no session after sign in: %s", body)
+	}
+	s.a.db.First(&user, user.ID)
+	if user.Role != RoleOrganizer {
+		t.Errorf("role after the group change is %s", user.Role)
+	}
+
+	// No group, no account.
+	if session, _ := s.signIn(ssoUser("user-4", "student@university.example", "students")); session != nil {
+		t.Error("account without a mapped group signed in")
+	}
+}
+
+func TestOIDCCallbackChecks(t *testing.T) {
+	s := newSSOTest(t, nil)
+	if err := s.a.db.Create(&AdminUser{Username: "olga@university.example", Role: RoleOrganizer}).Error; err != nil {
+		t.Fatal(err)
+	}
+	user := ssoUser("user-1", "olga@university.example")
+
+	// The state must match the cookie of the browser that started it.
+	callback, state := s.authorize(user)
+	if resp, body := s.callback(callback, nil); cookie(resp, adminSessionCookie) != nil || !strings.Contains(body, "expired") {
+		t.Errorf("callback without the state cookie: %s", body)
+	}
+	if resp, body := s.callback(callback, &http.Cookie{Name: oidcStateCookie, Value: state.Value + "x"}); cookie(resp, adminSessionCookie) != nil || !strings.Contains(body, "expired") {
+		t.Errorf("callback with another state: %s", body)
+	}
+
+	// A callback completes once.
+	callback, state = s.authorize(user)
+	if resp, body := s.callback(callback, state); cookie(resp, adminSessionCookie) == nil {
+		t.Fatalf("no session after sign in: %s", body)
+	}
+	if resp, body := s.callback(callback, state); cookie(resp, adminSessionCookie) != nil || !strings.Contains(body, "expired") {
+		t.Errorf("replayed callback: %s", body)
+	}
+
+	// A code is redeemed with its own verifier only.
+	callback, state = s.authorize(user)
+	other, otherState := s.authorize(user)
+	q := other.Query()
+	q.Set("code", callback.Query().Get("code"))
+	other.RawQuery = q.Encode()
+	if resp, body := s.callback(other, otherState); cookie(resp, adminSessionCookie) != nil || !strings.Contains(body, "try again later") {
+		t.Errorf("code of another sign in: %s", body)
+	}
+	if resp, body := s.callback(callback, state); cookie(resp, adminSessionCookie) != nil {
+		t.Errorf("code redeemed twice: %s", body)
+	}
+
+	// Denied at the identity provider.
+	user.Set("action", "deny")
+	if session, body := s.signIn(user); session != nil || !strings.Contains(body, "failed or was cancelled") {
+		t.Errorf("denied sign in: %s", body)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// uploadSection is a group of submissions of one type on the participant page.
+type uploadSection struct {
+	Type        string
+	Label       string
+	Submissions []Submission
+	Open        bool
+	Deadline    time.Time
+	Status      paperStatus
+
+	// Camera-ready items are collected once the paper is accepted.
+	CameraReady         []cameraReadyItem
+	CameraReadyOpen     bool
+	CameraReadyDeadline time.Time
+}
+
+var uploadTypes = []struct {
+	Type  string
+	Label string
+}{
+	{"tezis", "Abstracts"},
+	{"article", "Full paper"},
+	{"open-upload", "Open upload"},
+}
+
+func (a *App) nextSubmissionVersion(token, t, artifact string) (int, error) {
+	var version int
+	err := a.db.Model(&Submission{}).
+		Where("token = ? AND type = ? AND artifact = ?", token, t, artifact).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&version).Error
+
+	return version + 1, err
+}
+
+func (a *App) participantByToken(token string) (Participant, error) {
+	var participant Participant
+	if token == "" {
+		return participant, gorm.ErrRecordNotFound
+	}
+
+	err := a.db.Where("token = ?", token).First(&participant).Error
+
+	return participant, err
+}
+
+func (a *App) participantView(c *fiber.Ctx) error {
+	participant, err := a.participantByToken(c.Query("code"))
+	if err != nil {
+		a.log.Error(err)
+		return c.Redirect("/404")
+	}
+
+	return a.renderParticipant(c, participant, fiber.Map{})
+}
+
+func (a *App) renderParticipant(c *fiber.Ctx, participant Participant, data fiber.Map) error {
+	var submissions []Submission
+	if err := a.db.Where("token = ?", participant.Token).Order("type, version desc").Find(&submissions).Error; err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	statuses, err := a.paperStatuses(participant.Token)
+	if err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	now := time.Now()
+	sections := make([]uploadSection, 0, len(uploadTypes))
+	for _, t := range uploadTypes {
+		section := uploadSection{Type: t.Type, Label: t.Label}
+		for _, s := range submissions {
+			if s.Type == t.Type && s.Artifact == "" {
+				section.Submissions = append(section.Submissions, s)
+			}
+		}
+
+		if len(section.Submissions) > 0 {
+			section.Status = statuses[paperKey{participant.Token, t.Type}]
+			if section.Status.Status == "" {
+				section.Status.Status = StatusReceived
+			}
+		}
+
+		if section.Status.Status == StatusAccepted {
+			section.CameraReady = a.cameraReadyItems(t.Type, submissions)
+			section.CameraReadyOpen = a.config.UploadOpen(CameraReadyDeadline, now)
+			section.CameraReadyDeadline = a.config.Deadlines[CameraReadyDeadline]
+		}
+
+		// Open uploads can't be replaced from here, only shown.
+		if t.Type == "open-upload" {
+			if len(section.Submissions) == 0 {
+				continue
+			}
+		} else {
+			section.Open = a.config.UploadOpen(t.Type, now)
+			section.Deadline = a.config.Deadlines[t.Type]
+		}
+
+		sections = append(sections, section)
+	}
+
+	data["Title"] = "My submissions"
+	data["User"] = participant
+	data["Sections"] = sections
+	data["StatusLabels"] = StatusLabels
+
+	return c.Render("participant", data)
+}
+
+func (a *App) participantFile(c *fiber.Ctx) error {
+	participant, err := a.participantByToken(c.Query("code"))
+	if err != nil {
+		a.log.Error(err)
+		return c.Redirect("/404")
+	}
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Redirect("/404")
+	}
+
+	var submission Submission
+	err = a.db.Where("id = ? AND token = ?", id, participant.Token).First(&submission).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Redirect("/404")
+	}
+	if err != nil {
+		return err
+	}
+
+	file, err := a.disk.Open(submission.Path)
+	if err != nil {
+		a.log.Errorf("Can't open submission %d: %v", submission.ID, err)
+		return c.Redirect("/404")
+	}
+
+	c.Set("Content-Description", "File Transfer")
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(submission.Path)))
+	return c.SendStream(file, int(submission.Size))
+}
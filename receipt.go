@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// hashUpload returns the hex encoded SHA-256 of the content and rewinds it.
+func hashUpload(content io.ReadSeeker) (string, error) {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Number identifies the submission in receipts and correspondence.
+func (s Submission) Number() string {
+	return fmt.Sprintf("AMTC-2022-%06d", s.ID)
+}
+
+// ReceivedAt is the server timestamp in the conference time zone.
+func (s Submission) ReceivedAt() string {
+	return s.CreatedAt.In(ConferenceLocation).Format("2006-01-02 15:04:05 MST (-07:00)")
+}
+
+var receiptEmailTemplate = template.Must(template.New("receipt").Parse(`
+			<table cellpadding="4" style="border-collapse: collapse;">
+				<tr><td>Submission ID</td><td><strong>{{.Number}}</strong></td></tr>
+				<tr><td>File</td><td>{{.OriginalName}}</td></tr>
+				<tr><td>Size</td><td>{{.Size}} bytes</td></tr>
+				<tr><td>SHA-256</td><td><code>{{.SHA256}}</code></td></tr>
+				<tr><td>Received at</td><td>{{.ReceivedAt}}</td></tr>
+			</table>`))
+
+// receiptHTML renders the receipt block included into upload emails.
+func receiptHTML(s Submission) string {
+	var b strings.Builder
+	if err := receiptEmailTemplate.Execute(&b, s); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+func (a *App) receiptURL(s Submission) string {
+	return fmt.Sprintf("/participant/receipts/%d?code=%s", s.ID, s.Token)
+}
+
+func (a *App) receiptView(c *fiber.Ctx) error {
+	participant, err := a.participantByToken(c.Query("code"))
+	if err != nil {
+		a.log.Error(err)
+		return c.Redirect("/404")
+	}
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Redirect("/404")
+	}
+
+	var submission Submission
+	err = a.db.Where("id = ? AND token = ?", id, participant.Token).First(&submission).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Redirect("/404")
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "receipt-"+submission.Number()+".html"))
+
+	// The receipt is a standalone document, so it is rendered without layout.
+	return c.Render("receipt", fiber.Map{
+		"User":       participant,
+		"Submission": submission,
+		"Domain":     a.config.Domain,
+	}, "")
+}
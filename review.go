@@ -0,0 +1,625 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var Recommendations = []string{
+	"Accept",
+	"Minor revision",
+	"Major revision",
+	"Reject",
+}
+
+func (r Review) ScoreMap() map[uint]int {
+	scores := make(map[uint]int)
+	if r.Scores != "" {
+		_ = json.Unmarshal([]byte(r.Scores), &scores)
+	}
+	return scores
+}
+
+// paperKey identifies an upload of a participant regardless of its version.
+type paperKey struct {
+	Token string
+	Type  string
+}
+
+func (k paperKey) String() string {
+	return k.Token + "|" + k.Type
+}
+
+func parsePaperKey(s string) (paperKey, bool) {
+	token, t, ok := strings.Cut(s, "|")
+	return paperKey{token, t}, ok && token != "" && t != ""
+}
+
+type assignmentView struct {
+	Assignment
+	Reviewer Reviewer
+	Review   *Review
+}
+
+// paperReviews is a paper with its assignments and aggregated scores.
+type paperReviews struct {
+	Key         paperKey
+	Participant Participant
+	Latest      Submission
+	Assignments []assignmentView
+
+	Submitted       int
+	MeanScores      map[uint]float64
+	MeanTotal       float64
+	Recommendations map[string]int
+
+	// Conflicts are reasons reviewers can't review the paper by reviewer ID.
+	Conflicts map[uint]string
+	Status    paperStatus
+}
+
+// latestSubmissions returns the latest version of every paper.
+func (a *App) latestSubmissions() (map[paperKey]Submission, error) {
+	var submissions []Submission
+	if err := a.db.Where("artifact = ?", "").Order("version").Find(&submissions).Error; err != nil {
+		return nil, err
+	}
+
+	latest := make(map[paperKey]Submission)
+	for _, s := range submissions {
+		latest[paperKey{s.Token, s.Type}] = s
+	}
+
+	return latest, nil
+}
+
+func (a *App) assignmentViews(query *gorm.DB) ([]assignmentView, error) {
+	var assignments []Assignment
+	if err := query.Order("id").Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+
+	views := make([]assignmentView, 0, len(assignments))
+	for _, assignment := range assignments {
+		view := assignmentView{Assignment: assignment}
+
+		if err := a.db.First(&view.Reviewer, assignment.ReviewerID).Error; err != nil {
+			return nil, err
+		}
+
+		var review Review
+		err := a.db.Where("assignment_id = ?", assignment.ID).First(&review).Error
+		if err == nil {
+			view.Review = &review
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		views = append(views, view)
+	}
+
+	return views, nil
+}
+
+// aggregate computes mean scores of submitted reviews.
+func (p *paperReviews) aggregate(criteria []ReviewCriterion) {
+	p.MeanScores = make(map[uint]float64)
+	p.Recommendations = make(map[string]int)
+
+	sums := make(map[uint]int)
+	total := 0
+	for _, assignment := range p.Assignments {
+		if assignment.Review == nil || !assignment.Review.Submitted {
+			continue
+		}
+		p.Submitted++
+		p.Recommendations[assignment.Review.Recommendation]++
+
+		scores := assignment.Review.ScoreMap()
+		for _, c := range criteria {
+			sums[c.ID] += scores[c.ID]
+			total += scores[c.ID]
+		}
+	}
+
+	if p.Submitted == 0 {
+		return
+	}
+
+	for _, c := range criteria {
+		p.MeanScores[c.ID] = float64(sums[c.ID]) / float64(p.Submitted)
+	}
+	p.MeanTotal = float64(total) / float64(p.Submitted)
+}
+
+func (a *App) reviewCriteria() ([]ReviewCriterion, error) {
+	var criteria []ReviewCriterion
+	err := a.db.Order("position, id").Find(&criteria).Error
+	return criteria, err
+}
+
+func (a *App) papersWithReviews() ([]paperReviews, error) {
+	latest, err := a.latestSubmissions()
+	if err != nil {
+		return nil, err
+	}
+
+	criteria, err := a.reviewCriteria()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, err := a.paperStatuses("")
+	if err != nil {
+		return nil, err
+	}
+
+	papers := make([]paperReviews, 0, len(latest))
+	for key, submission := range latest {
+		paper := paperReviews{Key: key, Latest: submission, Status: statuses[key]}
+		if paper.Status.Status == "" {
+			paper.Status.Status = StatusReceived
+		}
+
+		if err := a.db.Where("token = ?", key.Token).First(&paper.Participant).Error; err != nil {
+			a.log.Errorf("Submission %d has no participant: %v", submission.ID, err)
+		}
+
+		paper.Assignments, err = a.assignmentViews(a.db.Where("participant_token = ? AND type = ?", key.Token, key.Type))
+		if err != nil {
+			return nil, err
+		}
+
+		paper.aggregate(criteria)
+		papers = append(papers, paper)
+	}
+
+	sort.Slice(papers, func(i, j int) bool { return papers[i].Latest.ID < papers[j].Latest.ID })
+
+	return papers, nil
+}
+
+func (a *App) reviewsAdminView(c *fiber.Ctx) error {
+	papers, err := a.papersWithReviews()
+	if err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	filter := c.Query("status")
+	if filter != "" {
+		filtered := make([]paperReviews, 0, len(papers))
+		for _, paper := range papers {
+			if paper.Status.Status == filter {
+				filtered = append(filtered, paper)
+			}
+		}
+		papers = filtered
+	}
+
+	criteria, err := a.reviewCriteria()
+	if err != nil {
+		return err
+	}
+
+	var reviewers []Reviewer
+	if err := a.db.Order("surname, name").Find(&reviewers).Error; err != nil {
+		return err
+	}
+
+	var participants []Participant
+	if err := a.db.Find(&participants).Error; err != nil {
+		return err
+	}
+	markConflicts(papers, reviewers, participants)
+
+	var drafts int64
+	a.db.Model(&Assignment{}).Where("draft = ?", true).Count(&drafts)
+
+	return c.Render("admin-reviews", fiber.Map{
+		"Title":           "Reviews",
+		"Papers":          papers,
+		"Criteria":        criteria,
+		"Reviewers":       reviewers,
+		"Recommendations": Recommendations,
+		"Sections":        PresentationSections,
+		"Drafts":          drafts,
+		"ReviewsPerPaper": a.config.ReviewsPerPaper,
+		"Statuses":        Statuses,
+		"StatusLabels":    StatusLabels,
+		"Filter":          filter,
+	})
+}
+
+func (a *App) createReviewer(c *fiber.Ctx) error {
+	reviewer := Reviewer{
+		Token:        uuid.New().String(),
+		Name:         strings.TrimSpace(c.FormValue("name")),
+		Surname:      strings.TrimSpace(c.FormValue("surname")),
+		Email:        strings.TrimSpace(c.FormValue("email")),
+		Organization: strings.TrimSpace(c.FormValue("organization")),
+	}
+
+	if reviewer.Email == "" {
+		return c.Redirect("/admin/reviews")
+	}
+
+	if err := a.db.Create(&reviewer).Error; err != nil {
+		a.log.Errorf("Can't create reviewer: %v", err)
+		return c.Redirect("/admin/reviews")
+	}
+	a.audit(c, "reviewer.create", "reviewer "+reviewer.Email, nil, reviewer)
+
+	a.sendReviewerInvitation(reviewer)
+	a.audit(c, "mail.reviewer-invitation", "reviewer "+reviewer.Email, nil, nil)
+
+	return c.Redirect("/admin/reviews")
+}
+
+func (a *App) sendReviewerInvitation(reviewer Reviewer) {
+	nameSurname := strings.Join([]string{reviewer.Name, reviewer.Surname}, " ")
+
+	if err := a.sendEmail(
+		To{nameSurname, reviewer.Email},
+		Message{ReviewerInvitationEmail.Subject, fmt.Sprintf(ReviewerInvitationEmail.Text, nameSurname, a.config.Domain, reviewer.Token)},
+	); err != nil {
+		a.log.Errorf("Can't send email to %s: %v", reviewer.Email, err)
+	}
+}
+
+func (a *App) toggleReviewer(c *fiber.Ctx) error {
+	var reviewer Reviewer
+	if err := a.db.First(&reviewer, c.Params("id")).Error; err != nil {
+		return c.Redirect("/admin/reviews")
+	}
+
+	disabled := !reviewer.Disabled
+	if err := a.db.Model(&reviewer).Update("disabled", disabled).Error; err != nil {
+		a.log.Error(err)
+	}
+	a.audit(c, "reviewer.update", "reviewer "+reviewer.Email, map[string]bool{"Disabled": !disabled}, map[string]bool{"Disabled": disabled})
+
+	return c.Redirect("/admin/reviews")
+}
+
+func (a *App) createReviewCriterion(c *fiber.Ctx) error {
+	maxScore, err := strconv.Atoi(c.FormValue("max-score"))
+	if err != nil || maxScore <= 0 {
+		maxScore = 5
+	}
+	position, _ := strconv.Atoi(c.FormValue("position"))
+
+	criterion := ReviewCriterion{
+		Name:        strings.TrimSpace(c.FormValue("name")),
+		Description: strings.TrimSpace(c.FormValue("description")),
+		MaxScore:    maxScore,
+		Position:    position,
+	}
+
+	if criterion.Name != "" {
+		if err := a.db.Create(&criterion).Error; err != nil {
+			a.log.Error(err)
+		} else {
+			a.audit(c, "criterion.create", "criterion "+criterion.Name, nil, criterion)
+		}
+	}
+
+	return c.Redirect("/admin/reviews")
+}
+
+func (a *App) deleteReviewCriterion(c *fiber.Ctx) error {
+	var criterion ReviewCriterion
+	if err := a.db.First(&criterion, c.Params("id")).Error; err != nil {
+		return c.Redirect("/admin/reviews")
+	}
+
+	if err := a.db.Delete(&criterion).Error; err != nil {
+		a.log.Error(err)
+	} else {
+		a.audit(c, "criterion.delete", "criterion "+criterion.Name, criterion, nil)
+	}
+
+	return c.Redirect("/admin/reviews")
+}
+
+func (a *App) createAssignment(c *fiber.Ctx) error {
+	key, ok := parsePaperKey(c.FormValue("paper"))
+	reviewerID, err := strconv.Atoi(c.FormValue("reviewer"))
+	if !ok || err != nil {
+		return c.Redirect("/admin/reviews")
+	}
+
+	var reviewer Reviewer
+	if err := a.db.First(&reviewer, reviewerID).Error; err != nil {
+		return c.Redirect("/admin/reviews")
+	}
+	var participants []Participant
+	if err := a.db.Find(&participants).Error; err != nil {
+		a.log.Error(err)
+		return c.Redirect("/admin/reviews")
+	}
+	var participant *Participant
+	for i := range participants {
+		if participants[i].Token == key.Token {
+			participant = &participants[i]
+		}
+	}
+	if participant == nil {
+		return c.Redirect("/admin/reviews")
+	}
+	// The form disables conflicting reviewers, a crafted request must not
+	// get around that.
+	coAuthors := coAuthorIndex(participants)[normalize(participant.PresentationTitle)]
+	if reason := conflictOfInterest(reviewer, *participant, coAuthors); reason != "" {
+		a.log.Warnf("Refused to assign reviewer %d to paper %s: %s", reviewer.ID, key.Code(), reason)
+		return c.Redirect("/admin/reviews")
+	}
+
+	var count int64
+	a.db.Model(&Assignment{}).
+		Where("reviewer_id = ? AND participant_token = ? AND type = ?", reviewerID, key.Token, key.Type).
+		Count(&count)
+	if count > 0 {
+		return c.Redirect("/admin/reviews")
+	}
+
+	// While automatic assignments are previewed manual ones join them.
+	var drafts int64
+	a.db.Model(&Assignment{}).Where("draft = ?", true).Count(&drafts)
+
+	if err := a.db.Create(&Assignment{
+		ReviewerID:       uint(reviewerID),
+		ParticipantToken: key.Token,
+		Type:             key.Type,
+		Draft:            drafts > 0,
+	}).Error; err != nil {
+		a.log.Error(err)
+	} else {
+		a.audit(c, "assignment.create", "paper "+key.Code(), nil, map[string]int{"ReviewerID": reviewerID})
+	}
+
+	return c.Redirect("/admin/reviews")
+}
+
+func (a *App) deleteAssignment(c *fiber.Ctx) error {
+	var assignment Assignment
+	if err := a.db.First(&assignment, c.Params("id")).Error; err != nil {
+		return c.Redirect("/admin/reviews")
+	}
+
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("assignment_id = ?", assignment.ID).Delete(&Review{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&assignment).Error
+	})
+	if err != nil {
+		a.log.Error(err)
+	} else {
+		a.audit(c, "assignment.delete", "paper "+assignment.Code(), map[string]uint{"ReviewerID": assignment.ReviewerID}, nil)
+	}
+
+	return c.Redirect("/admin/reviews")
+}
+
+func (a *App) reviewerByToken(token string) (Reviewer, error) {
+	var reviewer Reviewer
+	if token == "" {
+		return reviewer, gorm.ErrRecordNotFound
+	}
+
+	err := a.db.Where("token = ? AND disabled = ?", token, false).First(&reviewer).Error
+
+	return reviewer, err
+}
+
+func (a *App) reviewerView(c *fiber.Ctx) error {
+	reviewer, err := a.reviewerByToken(c.Query("code"))
+	if err != nil {
+		return c.Redirect("/404")
+	}
+
+	assignments, err := a.assignmentViews(a.db.Where("reviewer_id = ? AND draft = ?", reviewer.ID, false))
+	if err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	return c.Render("reviewer", fiber.Map{
+		"Title":       "Reviews",
+		"Reviewer":    reviewer,
+		"Assignments": assignments,
+	})
+}
+
+// reviewerAssignment loads an assignment of the reviewer from the request.
+func (a *App) reviewerAssignment(c *fiber.Ctx) (Reviewer, Assignment, error) {
+	var assignment Assignment
+
+	reviewer, err := a.reviewerByToken(c.Query("code"))
+	if err != nil {
+		return reviewer, assignment, err
+	}
+
+	err = a.db.Where("id = ? AND reviewer_id = ? AND draft = ?", c.Params("id"), reviewer.ID, false).First(&assignment).Error
+
+	return reviewer, assignment, err
+}
+
+func (a *App) latestSubmission(token, t string) (Submission, error) {
+	var submission Submission
+	err := a.db.Where("token = ? AND type = ? AND artifact = ?", token, t, "").Order("version desc").First(&submission).Error
+	return submission, err
+}
+
+func (a *App) reviewView(c *fiber.Ctx) error {
+	reviewer, assignment, err := a.reviewerAssignment(c)
+	if err != nil {
+		return c.Redirect("/404")
+	}
+
+	return a.renderReview(c, reviewer, assignment, fiber.Map{})
+}
+
+func (a *App) renderReview(c *fiber.Ctx, reviewer Reviewer, assignment Assignment, data fiber.Map) error {
+	criteria, err := a.reviewCriteria()
+	if err != nil {
+		return err
+	}
+
+	var review Review
+	if err := a.db.Where("assignment_id = ?", assignment.ID).First(&review).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	submission, err := a.latestSubmission(assignment.ParticipantToken, assignment.Type)
+	if err != nil {
+		a.log.Error(err)
+	}
+
+	// Reviewers only see what doesn't identify the author.
+	var participant Participant
+	a.db.Where("token = ?", assignment.ParticipantToken).First(&participant)
+
+	data["Title"] = "Review"
+	data["Reviewer"] = reviewer
+	data["Assignment"] = assignment
+	data["Paper"] = fiber.Map{
+		"Title":   participant.PresentationTitle,
+		"Section": participant.PresentationSection,
+	}
+	data["Submission"] = submission
+	data["Criteria"] = criteria
+	data["Review"] = review
+	data["Scores"] = review.ScoreMap()
+	data["Recommendations"] = Recommendations
+
+	return c.Render("review", data)
+}
+
+func (a *App) saveReview(c *fiber.Ctx) error {
+	reviewer, assignment, err := a.reviewerAssignment(c)
+	if err != nil {
+		return c.Redirect("/404")
+	}
+
+	var review Review
+	err = a.db.Where("assignment_id = ?", assignment.ID).First(&review).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if review.Submitted {
+		return a.renderReview(c, reviewer, assignment, fiber.Map{"Error": "The review is already submitted."})
+	}
+
+	criteria, err := a.reviewCriteria()
+	if err != nil {
+		return err
+	}
+
+	formErrors := make(map[string]string)
+
+	scores := make(map[uint]int)
+	for _, criterion := range criteria {
+		v := c.FormValue(fmt.Sprintf("score-%d", criterion.ID))
+		if v == "" {
+			continue
+		}
+		score, err := strconv.Atoi(v)
+		if err != nil || score < 0 || score > criterion.MaxScore {
+			formErrors[criterion.Name] = fmt.Sprintf("Score must be from 0 to %d.", criterion.MaxScore)
+			continue
+		}
+		scores[criterion.ID] = score
+	}
+
+	encoded, _ := json.Marshal(scores)
+
+	review.AssignmentID = assignment.ID
+	review.Scores = string(encoded)
+	if recommendation := c.FormValue("recommendation"); recommendation == "" || contains(Recommendations, recommendation) {
+		review.Recommendation = recommendation
+	} else {
+		formErrors["Recommendation"] = "Choose a recommendation from the list."
+	}
+	review.CommentsToAuthors = c.FormValue("comments-to-authors")
+	review.CommentsToCommittee = c.FormValue("comments-to-committee")
+
+	if c.FormValue("action") == "submit" {
+		if len(scores) < len(criteria) {
+			formErrors["Scores"] = "All scores are required to submit the review."
+		}
+		if review.Recommendation == "" {
+			formErrors["Recommendation"] = "Recommendation is required to submit the review."
+		}
+		review.Submitted = len(formErrors) == 0
+	}
+
+	if err := a.db.Save(&review).Error; err != nil {
+		a.log.Error(err)
+		return a.renderReview(c, reviewer, assignment, fiber.Map{"Error": "Can't save the review."})
+	}
+
+	data := fiber.Map{"Errors": formErrors}
+	if len(formErrors) > 0 {
+		data["Error"] = ErrorMessage
+	} else if review.Submitted {
+		data["Success"] = "The review is submitted, thank you!"
+	} else {
+		data["Success"] = "The draft is saved."
+	}
+
+	return a.renderReview(c, reviewer, assignment, data)
+}
+
+func (a *App) reviewFile(c *fiber.Ctx) error {
+	_, assignment, err := a.reviewerAssignment(c)
+	if err != nil {
+		return c.Redirect("/404")
+	}
+
+	submission, err := a.latestSubmission(assignment.ParticipantToken, assignment.Type)
+	if err != nil {
+		return c.Redirect("/404")
+	}
+
+	file, err := a.disk.Open(submission.Path)
+	if err != nil {
+		a.log.Errorf("Can't open submission %d: %v", submission.ID, err)
+		return c.Redirect("/404")
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		a.log.Errorf("Can't read submission %d: %v", submission.ID, err)
+		return c.Redirect("/404")
+	}
+
+	anonymized, err := anonymizeDocument(content, strings.TrimPrefix(path.Ext(submission.Path), "."))
+	if err != nil {
+		// Reviews are blind, the file is never served with the author in it.
+		a.log.Errorf("Can't anonymize submission %d: %v", submission.ID, err)
+		c.Status(fiber.StatusInternalServerError)
+		c.Bind(fiber.Map{
+			"Title":   "Submission",
+			"Content": "The file can't be prepared for a blind review. Please contact the organizers at amtc@gumrf.ru.",
+		})
+		return c.Render("basic", fiber.Map{})
+	}
+
+	c.Set("Content-Description", "File Transfer")
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", assignment.BlindFileName(submission)))
+	return c.Send(anonymized)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCreateAssignmentConflict(t *testing.T) {
+	a := newTestApp(t)
+	participants := []Participant{
+		{Token: "anna", Email: "anna@example.com", Organization: "Ice Institute", PresentationTitle: "Ice loads"},
+		{Token: "boris", Email: "boris@example.com", PresentationTitle: "Ice Loads"},
+	}
+	reviewers := []Reviewer{
+		{Token: "r1", Email: "BORIS@example.com"},
+		{Token: "r2", Email: "olga@example.com", Organization: "ice institute"},
+		{Token: "r3", Email: "ivan@example.com", Conflicts: "anna@example.com"},
+		{Token: "r4", Email: "pavel@example.com"},
+	}
+	for _, p := range participants {
+		if err := a.db.Create(&p).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range reviewers {
+		if err := a.db.Create(&reviewers[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := fiber.New()
+	app.Post("/admin/assignments", a.createAssignment)
+	for _, r := range reviewers {
+		form := url.Values{"paper": {paperKey{"anna", "tezis"}.String()}, "reviewer": {strconv.Itoa(int(r.ID))}}
+		req := httptest.NewRequest(http.MethodPost, "/admin/assignments", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if _, err := app.Test(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var assignments []Assignment
+	if err := a.db.Find(&assignments).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(assignments) != 1 || assignments[0].ReviewerID != reviewers[3].ID {
+		t.Errorf("assigned %+v, want only the reviewer without a conflict", assignments)
+	}
+}
+
+func TestReviewFileAnonymized(t *testing.T) {
+	a := newTestApp(t)
+	server := newServer(a.config)
+	server.Get("/reviewer/assignments/:id/file", a.reviewFile)
+
+	var docx bytes.Buffer
+	zw := zip.NewWriter(&docx)
+	for name, content := range map[string]string{
+		"docProps/core.xml": `<cp:coreProperties><dc:title>Ice loads</dc:title><dc:creator>Anna Smith</dc:creator><cp:lastModifiedBy>Anna Smith</cp:lastModifiedBy></cp:coreProperties>`,
+		"docProps/app.xml":  `<Properties><Company>Ice Institute</Company><Pages>2</Pages></Properties>`,
+		"word/document.xml": `<w:document><w:body><w:ins w:author="Anna Smith" w:initials="AS"><w:p><w:r><w:t>Ice loads</w:t></w:r></w:p></w:ins></w:body></w:document>`,
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pdf := "%PDF-1.4\n1 0 obj\n<< /Title (Ice loads) /Author (Anna Smith) /Creator (Word) >>\nendobj\n" +
+		"2 0 obj\n<< /Type /Metadata >>\nstream\n<x:xmpmeta><dc:creator><rdf:Seq><rdf:li>Anna Smith</rdf:li></rdf:Seq></dc:creator><pdf:Author>Anna Smith</pdf:Author></x:xmpmeta>\nendstream\nendobj\n%%EOF\n"
+
+	// The information dictionary in a compressed object stream.
+	var objects bytes.Buffer
+	zlw := zlib.NewWriter(&objects)
+	io.WriteString(zlw, "1 0 << /Author (Anna Smith) >>")
+	zlw.Close()
+	compressed := "%PDF-1.5\n2 0 obj\n<< /Type /ObjStm /Filter /FlateDecode >>\nstream\n" + objects.String() + "\nendstream\nendobj\n%%EOF\n"
+
+	reviewer := Reviewer{Token: "r1", Email: "olga@example.com"}
+	if err := a.db.Create(&reviewer).Error; err != nil {
+		t.Fatal(err)
+	}
+	for token, file := range map[string]string{"docx": docx.String(), "pdf": pdf, "broken": "Anna Smith", "compressed": compressed} {
+		name := "article/" + token + ".docx"
+		if token == "pdf" || token == "compressed" {
+			name = "article/" + token + ".pdf"
+		}
+		if err := a.disk.Save(strings.NewReader(file), name); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.db.Create(&Submission{Token: token, Type: "article", Version: 1, Path: name}).Error; err != nil {
+			t.Fatal(err)
+		}
+		assignment := Assignment{ReviewerID: reviewer.ID, ParticipantToken: token, Type: "article"}
+		if err := a.db.Create(&assignment).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := server.Test(httptest.NewRequest(http.MethodGet, fmt.Sprintf("/reviewer/assignments/%d/file?code=r1", assignment.ID), nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+
+		switch token {
+		case "broken", "compressed":
+			if resp.StatusCode != fiber.StatusInternalServerError {
+				t.Errorf("file that can't be anonymized is %d, want 500", resp.StatusCode)
+			}
+		case "docx":
+			body = unzipAll(t, body)
+		}
+		for _, author := range []string{"Anna Smith", "AS", "Ice Institute"} {
+			if bytes.Contains(body, []byte(author)) {
+				t.Errorf("%s served to the reviewer has %q:\n%s", token, author, body)
+			}
+		}
+		if (token == "docx" || token == "pdf") && !bytes.Contains(body, []byte("Ice loads")) {
+			t.Errorf("%s served to the reviewer lost its content:\n%s", token, body)
+		}
+	}
+}
+
+// unzipAll concatenates the entries of an archive.
+func unzipAll(t *testing.T, content []byte) []byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all []byte
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		all = append(all, data...)
+	}
+	return all
+}
+
+func TestSaveReviewRecommendation(t *testing.T) {
+	a := newTestApp(t)
+	server := newServer(a.config)
+	server.Post("/reviewer/assignments/:id", a.saveReview)
+
+	reviewer := Reviewer{Token: "r1", Email: "olga@example.com"}
+	if err := a.db.Create(&reviewer).Error; err != nil {
+		t.Fatal(err)
+	}
+	assignment := Assignment{ReviewerID: reviewer.ID, ParticipantToken: "anna", Type: "article"}
+	if err := a.db.Create(&assignment).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(recommendation string) Review {
+		form := url.Values{"action": {"submit"}, "recommendation": {recommendation}}
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/reviewer/assignments/%d?code=r1", assignment.ID), strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if _, err := server.Test(req, -1); err != nil {
+			t.Fatal(err)
+		}
+		var review Review
+		a.db.Where("assignment_id = ?", assignment.ID).First(&review)
+		return review
+	}
+
+	if review := save("Accept without reading"); review.Submitted || review.Recommendation != "" {
+		t.Errorf("review with a recommendation not in the list is %+v", review)
+	}
+	if review := save("Minor revision"); !review.Submitted || review.Recommendation != "Minor revision" {
+		t.Errorf("review with a recommendation from the list is %+v", review)
+	}
+}
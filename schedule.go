@@ -0,0 +1,493 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func clock(t time.Time) string {
+	return t.In(ConferenceLocation).Format("15:04")
+}
+
+func (d ScheduleDay) Label() string {
+	return d.Date.In(ConferenceLocation).Format("Monday, January 2")
+}
+
+func (s ScheduleSession) Time() string {
+	return clock(s.Start) + "–" + clock(s.End)
+}
+
+func (s Slot) Time() string {
+	return clock(s.Start) + "–" + clock(s.End)
+}
+
+func (s Slot) Day() string {
+	return s.Start.In(ConferenceLocation).Format("January 2")
+}
+
+func overlaps(aStart, aEnd, bStart, bEnd time.Time) bool {
+	return aStart.Before(bEnd) && bStart.Before(aEnd)
+}
+
+type sessionView struct {
+	ScheduleSession
+	Room  Room
+	Slots []Slot
+}
+
+type dayView struct {
+	ScheduleDay
+	Sessions []sessionView
+}
+
+// programme loads the schedule ordered by time.
+func (a *App) programme() ([]dayView, error) {
+	var days []ScheduleDay
+	if err := a.db.Order("date").Find(&days).Error; err != nil {
+		return nil, err
+	}
+
+	var rooms []Room
+	if err := a.db.Find(&rooms).Error; err != nil {
+		return nil, err
+	}
+	roomByID := make(map[uint]Room)
+	for _, room := range rooms {
+		roomByID[room.ID] = room
+	}
+
+	var sessions []ScheduleSession
+	if err := a.db.Order("start, room_id").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	var slots []Slot
+	if err := a.db.Order("start").Find(&slots).Error; err != nil {
+		return nil, err
+	}
+	slotsBySession := make(map[uint][]Slot)
+	for _, slot := range slots {
+		slotsBySession[slot.SessionID] = append(slotsBySession[slot.SessionID], slot)
+	}
+
+	views := make([]dayView, 0, len(days))
+	for _, day := range days {
+		view := dayView{ScheduleDay: day}
+		for _, session := range sessions {
+			if session.DayID == day.ID {
+				view.Sessions = append(view.Sessions, sessionView{session, roomByID[session.RoomID], slotsBySession[session.ID]})
+			}
+		}
+		views = append(views, view)
+	}
+
+	return views, nil
+}
+
+// sameSpeaker matches talks by the paper author or by the name.
+func sameSpeaker(a, b Slot) bool {
+	if a.ParticipantToken != "" && a.ParticipantToken == b.ParticipantToken {
+		return true
+	}
+	return normalize(a.Speaker) != "" && normalize(a.Speaker) == normalize(b.Speaker)
+}
+
+// scheduleConflicts finds speakers in two places at once, chairs speaking in
+// another session during their session, double-booked rooms and talks out of
+// their session time.
+func scheduleConflicts(days []dayView) []string {
+	conflicts := make([]string, 0)
+
+	type talk struct {
+		Slot
+		Session sessionView
+	}
+	talks := make([]talk, 0)
+	sessions := make([]sessionView, 0)
+	for _, day := range days {
+		for _, session := range day.Sessions {
+			sessions = append(sessions, session)
+			for _, slot := range session.Slots {
+				talks = append(talks, talk{slot, session})
+				if slot.Start.Before(session.Start) || slot.End.After(session.End) {
+					conflicts = append(conflicts, fmt.Sprintf("«%s» at %s is out of the session %s %s.", slot.Title, slot.Time(), session.Name, session.Time()))
+				}
+			}
+		}
+	}
+
+	for i, a := range talks {
+		for _, b := range talks[i+1:] {
+			if sameSpeaker(a.Slot, b.Slot) && overlaps(a.Start, a.End, b.Start, b.End) {
+				conflicts = append(conflicts, fmt.Sprintf("%s speaks at %s in %s and at %s in %s.", a.Speaker, a.Time(), a.Session.Room.Name, b.Time(), b.Session.Room.Name))
+			}
+		}
+	}
+
+	for _, session := range sessions {
+		chair := normalize(session.Chair)
+		if chair == "" {
+			continue
+		}
+		for _, t := range talks {
+			if t.Session.ID != session.ID && normalize(t.Speaker) == chair && overlaps(session.Start, session.End, t.Start, t.End) {
+				conflicts = append(conflicts, fmt.Sprintf("%s chairs %s %s and speaks at %s in %s.", session.Chair, session.Name, session.Time(), t.Time(), t.Session.Room.Name))
+			}
+		}
+	}
+
+	for i, a := range sessions {
+		for _, b := range sessions[i+1:] {
+			if a.RoomID == b.RoomID && overlaps(a.Start, a.End, b.Start, b.End) {
+				conflicts = append(conflicts, fmt.Sprintf("%s is booked for %s %s and %s %s.", a.Room.Name, a.Name, a.Time(), b.Name, b.Time()))
+			}
+		}
+	}
+
+	return conflicts
+}
+
+type acceptedPaper struct {
+	Key         paperKey
+	Participant Participant
+}
+
+func (a *App) acceptedPapers() ([]acceptedPaper, error) {
+	statuses, err := a.paperStatuses("")
+	if err != nil {
+		return nil, err
+	}
+
+	papers := make([]acceptedPaper, 0)
+	for key, status := range statuses {
+		if status.Status != StatusAccepted {
+			continue
+		}
+		paper := acceptedPaper{Key: key}
+		if err := a.db.Where("token = ?", key.Token).First(&paper.Participant).Error; err != nil {
+			a.log.Errorf("Accepted paper %s has no participant: %v", key, err)
+			continue
+		}
+		papers = append(papers, paper)
+	}
+
+	sort.Slice(papers, func(i, j int) bool { return papers[i].Participant.Surname < papers[j].Participant.Surname })
+
+	return papers, nil
+}
+
+func (a *App) scheduleAdminView(c *fiber.Ctx) error {
+	days, err := a.programme()
+	if err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	var rooms []Room
+	if err := a.db.Order("name").Find(&rooms).Error; err != nil {
+		return err
+	}
+
+	papers, err := a.acceptedPapers()
+	if err != nil {
+		return err
+	}
+
+	return c.Render("admin-schedule", fiber.Map{
+		"Title":              "Schedule",
+		"Days":               days,
+		"Rooms":              rooms,
+		"Papers":             papers,
+		"ConferenceSessions": RegistrationPageContent["ConferenceSessions"],
+		"Conflicts":          scheduleConflicts(days),
+	})
+}
+
+// parseClock returns the time of the day in the conference time zone.
+func parseClock(day ScheduleDay, v string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04", day.Date.In(ConferenceLocation).Format("2006-01-02")+" "+v, ConferenceLocation)
+}
+
+func parseInterval(day ScheduleDay, start, end string) (time.Time, time.Time, error) {
+	s, err := parseClock(day, start)
+	if err != nil {
+		return s, s, err
+	}
+	e, err := parseClock(day, end)
+	if err != nil {
+		return s, e, err
+	}
+	if !s.Before(e) {
+		return s, e, fmt.Errorf("%s is not before %s", start, end)
+	}
+	return s, e, nil
+}
+
+func (a *App) createScheduleDay(c *fiber.Ctx) error {
+	date, err := time.ParseInLocation("2006-01-02", c.FormValue("date"), ConferenceLocation)
+	if err != nil {
+		return c.Redirect("/admin/schedule")
+	}
+
+	day := ScheduleDay{Date: date, Title: strings.TrimSpace(c.FormValue("title"))}
+	if err := a.db.Create(&day).Error; err != nil {
+		a.log.Error(err)
+	} else {
+		a.audit(c, "schedule.day.create", "day "+day.Label(), nil, day)
+	}
+
+	return c.Redirect("/admin/schedule")
+}
+
+func (a *App) deleteScheduleDay(c *fiber.Ctx) error {
+	var day ScheduleDay
+	if err := a.db.First(&day, c.Params("id")).Error; err != nil {
+		return c.Redirect("/admin/schedule")
+	}
+
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		sessions := tx.Model(&ScheduleSession{}).Select("id").Where("day_id = ?", day.ID)
+		if err := tx.Where("session_id IN (?)", sessions).Delete(&Slot{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("day_id = ?", day.ID).Delete(&ScheduleSession{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&day).Error
+	})
+	if err != nil {
+		a.log.Error(err)
+	} else {
+		a.audit(c, "schedule.day.delete", "day "+day.Label(), day, nil)
+	}
+
+	return c.Redirect("/admin/schedule")
+}
+
+func (a *App) createRoom(c *fiber.Ctx) error {
+	if name := strings.TrimSpace(c.FormValue("name")); name != "" {
+		if err := a.db.Create(&Room{Name: name}).Error; err != nil {
+			a.log.Error(err)
+		} else {
+			a.audit(c, "schedule.room.create", "room "+name, nil, nil)
+		}
+	}
+
+	return c.Redirect("/admin/schedule")
+}
+
+func (a *App) deleteRoom(c *fiber.Ctx) error {
+	var used int64
+	a.db.Model(&ScheduleSession{}).Where("room_id = ?", c.Params("id")).Count(&used)
+	if used > 0 {
+		a.log.Infof("Room %s is used by %d sessions", c.Params("id"), used)
+		return c.Redirect("/admin/schedule")
+	}
+
+	var room Room
+	if err := a.db.First(&room, c.Params("id")).Error; err != nil {
+		return c.Redirect("/admin/schedule")
+	}
+
+	if err := a.db.Delete(&room).Error; err != nil {
+		a.log.Error(err)
+	} else {
+		a.audit(c, "schedule.room.delete", "room "+room.Name, nil, nil)
+	}
+
+	return c.Redirect("/admin/schedule")
+}
+
+func (a *App) createScheduleSession(c *fiber.Ctx) error {
+	var day ScheduleDay
+	if err := a.db.First(&day, c.FormValue("day")).Error; err != nil {
+		return c.Redirect("/admin/schedule")
+	}
+
+	roomID, err := strconv.Atoi(c.FormValue("room"))
+	if err != nil {
+		return c.Redirect("/admin/schedule")
+	}
+
+	start, end, err := parseInterval(day, c.FormValue("start"), c.FormValue("end"))
+	if err != nil {
+		a.log.Infof("Wrong session time: %v", err)
+		return c.Redirect("/admin/schedule")
+	}
+
+	name := strings.TrimSpace(c.FormValue("name"))
+	if name == "" {
+		name = strings.TrimSpace(c.FormValue("custom-name"))
+	}
+
+	session := ScheduleSession{
+		DayID:  day.ID,
+		RoomID: uint(roomID),
+		Name:   name,
+		Start:  start,
+		End:    end,
+		Chair:  strings.TrimSpace(c.FormValue("chair")),
+	}
+	if err := a.db.Create(&session).Error; err != nil {
+		a.log.Error(err)
+	} else {
+		a.audit(c, "schedule.session.create", "session "+session.Name, nil, session)
+	}
+
+	return c.Redirect("/admin/schedule")
+}
+
+func (a *App) deleteScheduleSession(c *fiber.Ctx) error {
+	var session ScheduleSession
+	if err := a.db.First(&session, c.Params("id")).Error; err != nil {
+		return c.Redirect("/admin/schedule")
+	}
+
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", session.ID).Delete(&Slot{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&session).Error
+	})
+	if err != nil {
+		a.log.Error(err)
+	} else {
+		a.audit(c, "schedule.session.delete", "session "+session.Name, session, nil)
+	}
+
+	return c.Redirect("/admin/schedule")
+}
+
+func (a *App) createSlot(c *fiber.Ctx) error {
+	var session ScheduleSession
+	if err := a.db.First(&session, c.FormValue("session")).Error; err != nil {
+		return c.Redirect("/admin/schedule")
+	}
+
+	var day ScheduleDay
+	if err := a.db.First(&day, session.DayID).Error; err != nil {
+		return c.Redirect("/admin/schedule")
+	}
+
+	start, end, err := parseInterval(day, c.FormValue("start"), c.FormValue("end"))
+	if err != nil {
+		a.log.Infof("Wrong slot time: %v", err)
+		return c.Redirect("/admin/schedule")
+	}
+
+	slot := Slot{
+		SessionID: session.ID,
+		Start:     start,
+		End:       end,
+		Title:     strings.TrimSpace(c.FormValue("title")),
+		Speaker:   strings.TrimSpace(c.FormValue("speaker")),
+		Keynote:   c.FormValue("keynote") == "on",
+	}
+
+	// A talk of an accepted paper takes the title and the speaker from it.
+	if key, ok := parsePaperKey(c.FormValue("paper")); ok {
+		participant, err := a.participantByToken(key.Token)
+		if err != nil {
+			return c.Redirect("/admin/schedule")
+		}
+		slot.ParticipantToken = key.Token
+		slot.Type = key.Type
+		if slot.Title == "" {
+			slot.Title = participant.PresentationTitle
+		}
+		if slot.Speaker == "" {
+			slot.Speaker = strings.Join([]string{participant.Name, participant.Surname}, " ")
+		}
+	}
+
+	if slot.Title == "" {
+		return c.Redirect("/admin/schedule")
+	}
+
+	if err := a.db.Create(&slot).Error; err != nil {
+		a.log.Error(err)
+	} else {
+		a.audit(c, "schedule.slot.create", "slot "+slot.Title, nil, slot)
+	}
+
+	return c.Redirect("/admin/schedule")
+}
+
+func (a *App) deleteSlot(c *fiber.Ctx) error {
+	var slot Slot
+	if err := a.db.First(&slot, c.Params("id")).Error; err != nil {
+		return c.Redirect("/admin/schedule")
+	}
+
+	if err := a.db.Delete(&slot).Error; err != nil {
+		a.log.Error(err)
+	} else {
+		a.audit(c, "schedule.slot.delete", "slot "+slot.Title, slot, nil)
+	}
+
+	return c.Redirect("/admin/schedule")
+}
+
+// ReviewResultsDate is the key of the date authors learn review results in
+// Config.Deadlines.
+const ReviewResultsDate = "review-results"
+
+type importantDate struct {
+	Label string
+	Date  string
+}
+
+// importantDates are the deadlines from the configuration and the days of
+// the programme. The dates announced in the call for papers stand in for
+// the ones not configured.
+func (a *App) importantDates(days []dayView) []importantDate {
+	dates := make([]importantDate, 0)
+	for _, d := range []struct{ Label, Key, Announced string }{
+		{"Abstract submission", "tezis", "30.09.2022"},
+		{"Full paper submission", "article", "31.10.2022"},
+		{"Results of the review process", ReviewResultsDate, "15.11.2022"},
+		{"Camera-ready submission", CameraReadyDeadline, ""},
+	} {
+		if date, ok := a.config.Deadlines[d.Key]; ok {
+			dates = append(dates, importantDate{d.Label, date.Format("02.01.2006")})
+		} else if d.Announced != "" {
+			dates = append(dates, importantDate{d.Label, d.Announced})
+		}
+	}
+
+	date := "24-25.11.2022"
+	if len(days) > 0 {
+		first := days[0].Date.In(ConferenceLocation)
+		last := days[len(days)-1].Date.In(ConferenceLocation)
+		date = dateRange(first, last)
+	}
+	dates = append(dates, importantDate{"Conference", date})
+
+	return dates
+}
+
+// dateRange is "24-25.11.2022", with the month and the year of the first day
+// when they differ from the last: "30.11-01.12.2022", "31.12.2022-01.01.2023".
+func dateRange(first, last time.Time) string {
+	switch {
+	case first.Format("02.01.2006") == last.Format("02.01.2006"):
+		return first.Format("02.01.2006")
+	case first.Year() != last.Year():
+		return first.Format("02.01.2006") + "-" + last.Format("02.01.2006")
+	case first.Month() != last.Month():
+		return first.Format("02.01") + "-" + last.Format("02.01.2006")
+	}
+	return first.Format("02") + "-" + last.Format("02.01.2006")
+}
+
+func (a *App) keynotes() ([]Slot, error) {
+	var slots []Slot
+	err := a.db.Where("keynote = ?", true).Order("start").Find(&slots).Error
+	return slots, err
+}
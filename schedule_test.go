@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImportantDates(t *testing.T) {
+	a := newTestApp(t)
+
+	// Without configuration the announced dates are shown.
+	got := a.importantDates(nil)
+	want := []importantDate{
+		{"Abstract submission", "30.09.2022"},
+		{"Full paper submission", "31.10.2022"},
+		{"Results of the review process", "15.11.2022"},
+		{"Conference", "24-25.11.2022"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("dates are %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("date %d is %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	a.config.Deadlines = map[string]time.Time{
+		"tezis":             time.Date(2022, 10, 7, 0, 0, 0, 0, ConferenceLocation),
+		CameraReadyDeadline: time.Date(2022, 11, 20, 0, 0, 0, 0, ConferenceLocation),
+	}
+	days := []dayView{
+		{ScheduleDay: ScheduleDay{Date: time.Date(2022, 11, 23, 0, 0, 0, 0, ConferenceLocation)}},
+		{ScheduleDay: ScheduleDay{Date: time.Date(2022, 11, 25, 0, 0, 0, 0, ConferenceLocation)}},
+	}
+	got = a.importantDates(days)
+	want = []importantDate{
+		{"Abstract submission", "07.10.2022"},
+		{"Full paper submission", "31.10.2022"},
+		{"Results of the review process", "15.11.2022"},
+		{"Camera-ready submission", "20.11.2022"},
+		{"Conference", "23-25.11.2022"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("dates are %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("date %d is %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDateRange(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, ConferenceLocation)
+	}
+	for _, test := range []struct {
+		first, last time.Time
+		want        string
+	}{
+		{day(2022, 11, 24), day(2022, 11, 24), "24.11.2022"},
+		{day(2022, 11, 24), day(2022, 11, 25), "24-25.11.2022"},
+		{day(2022, 11, 30), day(2022, 12, 1), "30.11-01.12.2022"},
+		{day(2022, 12, 31), day(2023, 1, 1), "31.12.2022-01.01.2023"},
+	} {
+		if got := dateRange(test.first, test.last); got != test.want {
+			t.Errorf("range from %s to %s is %q, want %q", test.first.Format("2006-01-02"), test.last.Format("2006-01-02"), got, test.want)
+		}
+	}
+}
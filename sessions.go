@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	adminSessionCookie = "amtc_admin"
+
+	// AdminSessionIdle ends sessions without requests.
+	AdminSessionIdle = 30 * time.Minute
+	// AdminSessionLifetime ends sessions regardless of activity.
+	AdminSessionLifetime = 12 * time.Hour
+
+	// LockoutWindow is the period failed sign ins are counted in.
+	LockoutWindow = 15 * time.Minute
+	// LockoutAccountFailures lock an account out for LockoutWindow.
+	LockoutAccountFailures = 5
+	// LockoutIPFailures lock an address out for LockoutWindow, higher than
+	// for accounts as a committee may share an address.
+	LockoutIPFailures = 20
+)
+
+var ErrLockedOut = errors.New("too many failed sign ins, try again later")
+
+func sha256Hex(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Active is false for revoked and expired sessions.
+func (s AdminSession) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Sub(s.LastSeenAt) < AdminSessionIdle && now.Sub(s.CreatedAt) < AdminSessionLifetime
+}
+
+// Key identifies the session in forms without exposing its ID.
+func (s AdminSession) Key() string {
+	return s.ID[:16]
+}
+
+func (a *App) setSessionCookie(c *fiber.Ctx, value string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     adminSessionCookie,
+		Value:    value,
+		Path:     "/admin",
+		Expires:  expires,
+		Secure:   strings.HasPrefix(a.config.Domain, "https://"),
+		HTTPOnly: true,
+		SameSite: "Strict",
+	})
+}
+
+// lockedOut counts recent failures of the account and of the address.
+func (a *App) lockedOut(username, ip string) (bool, error) {
+	since := time.Now().Add(-LockoutWindow)
+
+	var accountFailures, ipFailures int64
+	if err := a.db.Model(&LoginAttempt{}).Where("username = ? AND success = ? AND created_at > ?", username, false, since).Count(&accountFailures).Error; err != nil {
+		return false, err
+	}
+	if err := a.db.Model(&LoginAttempt{}).Where("ip = ? AND success = ? AND created_at > ?", ip, false, since).Count(&ipFailures).Error; err != nil {
+		return false, err
+	}
+
+	return accountFailures >= LockoutAccountFailures || ipFailures >= LockoutIPFailures, nil
+}
+
+// signIn checks the credentials and starts a session, returning its cookie.
+// Accounts with a second factor get a pending session unless the browser
+// is trusted.
+func (a *App) signIn(username, password, ip, userAgent, device string) (string, AdminSession, error) {
+	var session AdminSession
+
+	locked, err := a.lockedOut(username, ip)
+	if err != nil {
+		return "", session, err
+	}
+	if locked {
+		a.log.Infof("Sign in of %s from %s is locked out", username, ip)
+		return "", session, ErrLockedOut
+	}
+
+	ok := a.authorizeAdmin(username, password)
+	if err := a.db.Create(&LoginAttempt{Username: username, IP: ip, Success: ok}).Error; err != nil {
+		a.log.Error(err)
+	}
+	if !ok {
+		return "", session, fiber.ErrUnauthorized
+	}
+
+	var user AdminUser
+	if err := a.db.Where("username = ?", username).First(&user).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", session, err
+	}
+
+	return a.startSession(AdminSession{
+		Username:   username,
+		IP:         ip,
+		UserAgent:  userAgent,
+		MFAPending: user.TOTPEnabled && !a.deviceTrusted(device, user),
+	})
+}
+
+// startSession stores a new session, returning its cookie.
+func (a *App) startSession(session AdminSession) (string, AdminSession, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", session, err
+	}
+
+	now := time.Now()
+	session.ID = sha256Hex(token)
+	session.CreatedAt = now
+	session.LastSeenAt = now
+
+	return token, session, a.db.Create(&session).Error
+}
+
+// revokeSessions signs the account out everywhere, on password changes and
+// when it's disabled.
+func (a *App) revokeSessions(username string) {
+	if err := a.db.Model(&AdminSession{}).Where("username = ? AND revoked_at IS NULL", username).Update("revoked_at", time.Now()).Error; err != nil {
+		a.log.Error(err)
+	}
+}
+
+// requireAdminSession lets requests with an active session in and sends
+// the rest to the sign in page.
+func (a *App) requireAdminSession(c *fiber.Ctx) error {
+	var session AdminSession
+	err := a.db.Where("id = ?", sha256Hex(c.Cookies(adminSessionCookie))).First(&session).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	now := time.Now()
+	if err != nil || !session.Active(now) {
+		return c.Redirect("/admin/login?next=" + url.QueryEscape(c.OriginalURL()))
+	}
+	if session.MFAPending {
+		return c.Redirect("/admin/login/totp?next=" + url.QueryEscape(c.OriginalURL()))
+	}
+
+	if now.Sub(session.LastSeenAt) > time.Minute {
+		if err := a.db.Model(&session).Update("last_seen_at", now).Error; err != nil {
+			a.log.Error(err)
+		}
+	}
+
+	c.Locals("username", session.Username)
+	c.Locals("session", session)
+
+	return c.Next()
+}
+
+func (a *App) loginView(c *fiber.Ctx) error {
+	return c.Render("admin-login", fiber.Map{
+		"Title": "Admin sign in",
+		"Next":  c.Query("next"),
+		"SSO":   a.ssoLabel(),
+	})
+}
+
+// renderLogin shows the sign in page with an error.
+func (a *App) renderLogin(c *fiber.Ctx, username, message string) error {
+	c.Status(fiber.StatusUnauthorized)
+	return c.Render("admin-login", fiber.Map{
+		"Title":    "Admin sign in",
+		"Next":     c.FormValue("next"),
+		"Username": username,
+		"Error":    message,
+		"SSO":      a.ssoLabel(),
+	})
+}
+
+// ssoLabel is the single sign-on button, empty without it.
+func (a *App) ssoLabel() string {
+	if a.oidc == nil {
+		return ""
+	}
+	return a.config.OIDC.Label
+}
+
+func (a *App) login(c *fiber.Ctx) error {
+	username := strings.TrimSpace(c.FormValue("username"))
+
+	token, session, err := a.signIn(username, c.FormValue("password"), c.IP(), c.Get(fiber.HeaderUserAgent), c.Cookies(trustedDeviceCookie))
+	if err != nil {
+		message := "Wrong username or password."
+		if errors.Is(err, ErrLockedOut) {
+			message = "Too many failed attempts. Try again in 15 minutes."
+			a.auditAs(username, c.IP(), "login.locked", "user "+username, nil, nil)
+		} else if errors.Is(err, fiber.ErrUnauthorized) {
+			a.auditAs(username, c.IP(), "login.failed", "user "+username, nil, nil)
+		} else {
+			a.log.Error(err)
+			message = "Can't sign in, try again later."
+		}
+
+		return a.renderLogin(c, username, message)
+	}
+
+	a.setSessionCookie(c, token, time.Now().Add(AdminSessionLifetime))
+
+	if session.MFAPending {
+		return c.Redirect("/admin/login/totp?next=" + url.QueryEscape(c.FormValue("next")))
+	}
+
+	a.auditAs(username, c.IP(), "login", "user "+username, nil, map[string]string{"method": "password"})
+	return c.Redirect(adminNext(c.FormValue("next")))
+}
+
+// adminNext is the page to go after signing in, only in the admin panel and
+// not on other sites.
+func adminNext(next string) string {
+	if !strings.HasPrefix(next, "/admin") || strings.HasPrefix(next, "/admin/login") {
+		return "/admin"
+	}
+	return next
+}
+
+func (a *App) logout(c *fiber.Ctx) error {
+	if session, ok := c.Locals("session").(AdminSession); ok {
+		if err := a.db.Model(&session).Update("revoked_at", time.Now()).Error; err != nil {
+			a.log.Error(err)
+		}
+	}
+
+	a.setSessionCookie(c, "", time.Unix(0, 0))
+	a.audit(c, "logout", "user "+adminActor(c), nil, nil)
+
+	return c.Redirect("/admin/login")
+}
+
+// adminSessionsView lists active sessions of the account, and of everyone
+// for admins managing accounts.
+func (a *App) adminSessionsView(c *fiber.Ctx) error {
+	admin, _ := c.Locals("admin").(AdminUser)
+	current, _ := c.Locals("session").(AdminSession)
+
+	now := time.Now()
+	query := a.db.Where("revoked_at IS NULL AND last_seen_at > ? AND created_at > ?", now.Add(-AdminSessionIdle), now.Add(-AdminSessionLifetime)).Order("last_seen_at desc")
+	if !admin.Can(PermUsers) {
+		query = query.Where("username = ?", admin.Username)
+	}
+
+	var sessions []AdminSession
+	if err := query.Find(&sessions).Error; err != nil {
+		a.log.Error(err)
+		return err
+	}
+
+	return c.Render("admin-sessions", fiber.Map{
+		"Title":    "Sessions",
+		"Sessions": sessions,
+		"Current":  current.ID,
+	})
+}
+
+func (a *App) revokeSession(c *fiber.Ctx) error {
+	admin, _ := c.Locals("admin").(AdminUser)
+
+	key := c.Params("key")
+	if _, err := hex.DecodeString(key); err != nil || len(key) != 16 {
+		return c.Redirect("/admin/sessions")
+	}
+
+	query := a.db.Model(&AdminSession{}).Where("id LIKE ? AND revoked_at IS NULL", key+"%")
+	if !admin.Can(PermUsers) {
+		query = query.Where("username = ?", admin.Username)
+	}
+
+	if err := query.Update("revoked_at", time.Now()).Error; err != nil {
+		a.log.Error(err)
+	}
+	a.audit(c, "session.revoke", "session "+key, nil, nil)
+
+	return c.Redirect("/admin/sessions")
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func createAdmin(t *testing.T, a *App, username, role, password string) AdminUser {
+	t.Helper()
+	hash, err := hashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := AdminUser{Username: username, Role: role, PasswordHash: hash}
+	if err := a.db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestLockoutAccount(t *testing.T) {
+	a := newTestApp(t)
+	createAdmin(t, a, "olga", RoleOrganizer, "olgapass1")
+	createAdmin(t, a, "boris", RoleChair, "borispass1")
+
+	// Failures before the window don't count.
+	for i := 0; i < LockoutAccountFailures; i++ {
+		if err := a.db.Create(&LoginAttempt{CreatedAt: time.Now().Add(-LockoutWindow - time.Minute), Username: "olga", IP: "10.0.0.1"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < LockoutAccountFailures; i++ {
+		if _, _, err := a.signIn("olga", "wrong", "10.0.0.1", "", ""); !errors.Is(err, fiber.ErrUnauthorized) {
+			t.Fatalf("failure %d is %v, want unauthorized", i+1, err)
+		}
+	}
+
+	if _, _, err := a.signIn("olga", "olgapass1", "10.0.0.2", "", ""); !errors.Is(err, ErrLockedOut) {
+		t.Errorf("right password after %d failures is %v, want locked out", LockoutAccountFailures, err)
+	}
+	if _, _, err := a.signIn("boris", "borispass1", "10.0.0.1", "", ""); err != nil {
+		t.Errorf("another account from the address is %v", err)
+	}
+}
+
+func TestLockoutIP(t *testing.T) {
+	a := newTestApp(t)
+	createAdmin(t, a, "olga", RoleOrganizer, "olgapass1")
+
+	// Failures spread over accounts stay under the account limit.
+	for i := 0; i < LockoutIPFailures; i++ {
+		if _, _, err := a.signIn(fmt.Sprintf("user%d", i%4), "wrong", "10.0.0.1", "", ""); !errors.Is(err, fiber.ErrUnauthorized) {
+			t.Fatalf("failure %d is %v, want unauthorized", i+1, err)
+		}
+	}
+
+	if _, _, err := a.signIn("olga", "olgapass1", "10.0.0.1", "", ""); !errors.Is(err, ErrLockedOut) {
+		t.Errorf("sign in from the address after %d failures is %v, want locked out", LockoutIPFailures, err)
+	}
+	if _, _, err := a.signIn("olga", "olgapass1", "10.0.0.2", "", ""); err != nil {
+		t.Errorf("sign in from another address is %v", err)
+	}
+}
+
+func TestAdminSessionActive(t *testing.T) {
+	now := time.Now()
+	revoked := now.Add(-time.Minute)
+	for _, test := range []struct {
+		name    string
+		session AdminSession
+		active  bool
+	}{
+		{"fresh", AdminSession{CreatedAt: now.Add(-time.Hour), LastSeenAt: now.Add(-time.Minute)}, true},
+		{"idle", AdminSession{CreatedAt: now.Add(-time.Hour), LastSeenAt: now.Add(-AdminSessionIdle)}, false},
+		{"old", AdminSession{CreatedAt: now.Add(-AdminSessionLifetime), LastSeenAt: now.Add(-time.Minute)}, false},
+		{"revoked", AdminSession{CreatedAt: now.Add(-time.Hour), LastSeenAt: now.Add(-time.Minute), RevokedAt: &revoked}, false},
+	} {
+		if active := test.session.Active(now); active != test.active {
+			t.Errorf("%s session active is %v", test.name, active)
+		}
+	}
+}
+
+func TestRequireAdminSession(t *testing.T) {
+	a := newTestApp(t)
+	app := fiber.New()
+	app.Get("/admin/page", a.requireAdminSession, func(c *fiber.Ctx) error {
+		return c.SendString(c.Locals("username").(string))
+	})
+
+	start := func() (string, AdminSession) {
+		token, session, err := a.startSession(AdminSession{Username: "olga"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token, session
+	}
+	get := func(token string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/admin/page", nil)
+		req.AddCookie(&http.Cookie{Name: adminSessionCookie, Value: token})
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	signedOut := func(name string, resp *http.Response) {
+		t.Helper()
+		if resp.StatusCode != http.StatusFound || resp.Header.Get(fiber.HeaderLocation) != "/admin/login?next=%2Fadmin%2Fpage" {
+			t.Errorf("%s session is %d %s, want the sign in page", name, resp.StatusCode, resp.Header.Get(fiber.HeaderLocation))
+		}
+	}
+
+	token, _ := start()
+	if resp := get(token); resp.StatusCode != http.StatusOK {
+		t.Errorf("active session is %d %s", resp.StatusCode, resp.Header.Get(fiber.HeaderLocation))
+	}
+	signedOut("unknown", get("unknown"))
+
+	token, session := start()
+	if err := a.db.Model(&session).Update("last_seen_at", time.Now().Add(-AdminSessionIdle-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+	signedOut("idle", get(token))
+
+	token, session = start()
+	if err := a.db.Model(&session).Update("created_at", time.Now().Add(-AdminSessionLifetime-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+	signedOut("old", get(token))
+
+	token, _ = start()
+	a.revokeSessions("olga")
+	signedOut("revoked", get(token))
+}
+
+func TestRevokeSession(t *testing.T) {
+	a := newTestApp(t)
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("admin", AdminUser{Username: "olga", Role: RoleOrganizer})
+		c.Locals("username", "olga")
+		return c.Next()
+	})
+	app.Post("/admin/sessions/:key/revoke", a.revokeSession)
+
+	_, own, err := a.startSession(AdminSession{Username: "olga"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := a.startSession(AdminSession{Username: "boris"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, session := range []AdminSession{own, other} {
+		if _, err := app.Test(httptest.NewRequest(http.MethodPost, "/admin/sessions/"+session.Key()+"/revoke", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a.db.First(&own, "id = ?", own.ID)
+	a.db.First(&other, "id = ?", other.ID)
+	if own.RevokedAt == nil {
+		t.Error("own session is not revoked")
+	}
+	if other.RevokedAt != nil {
+		t.Error("account without the permission revoked a session of another account")
+	}
+}
+
+func TestAdminNext(t *testing.T) {
+	for next, want := range map[string]string{
+		"":                        "/admin",
+		"/admin/participants/abc": "/admin/participants/abc",
+		"/admin/login/totp":       "/admin",
+		"https://evil.example":    "/admin",
+		"//evil.example/admin":    "/admin",
+		"/participant":            "/admin",
+	} {
+		if got := adminNext(next); got != want {
+			t.Errorf("adminNext(%q) = %q, want %q", next, got, want)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	StatusReceived          = "received"
+	StatusUnderReview       = "under-review"
+	StatusRevisionRequested = "revision-requested"
+	StatusAccepted          = "accepted"
+	StatusRejected          = "rejected"
+)
+
+var Statuses = []string{
+	StatusReceived,
+	StatusUnderReview,
+	StatusRevisionRequested,
+	StatusAccepted,
+	StatusRejected,
+}
+
+var StatusLabels = map[string]string{
+	StatusReceived:          "Received",
+	StatusUnderReview:       "Under review",
+	StatusRevisionRequested: "Revision requested",
+	StatusAccepted:          "Accepted",
+	StatusRejected:          "Rejected",
+}
+
+// statusTransitions lists statuses allowed after a status. A paper without
+// status changes is received. Decisions can be reopened by moving the paper
+// back under review.
+var statusTransitions = map[string][]string{
+	StatusReceived:          {StatusUnderReview, StatusRejected},
+	StatusUnderReview:       {StatusAccepted, StatusRevisionRequested, StatusRejected},
+	StatusRevisionRequested: {StatusReceived, StatusUnderReview, StatusRejected},
+	StatusAccepted:          {StatusUnderReview},
+	StatusRejected:          {StatusUnderReview},
+}
+
+var ErrStatusTransition = errors.New("status transition is not allowed")
+
+func canTransition(from, to string) bool {
+	for _, s := range statusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// statusEmails are sent to the author on transitions. A received paper gets
+// the upload receipt instead.
+var statusEmails = map[string]Message{
+	StatusUnderReview:       StatusUnderReviewEmail,
+	StatusRevisionRequested: StatusRevisionRequestedEmail,
+	StatusAccepted:          StatusAcceptedEmail,
+	StatusRejected:          StatusRejectedEmail,
+}
+
+// paperStatus is the current status of a paper with its history.
+type paperStatus struct {
+	Status  string
+	History []StatusChange
+}
+
+func (s paperStatus) Label() string {
+	return StatusLabels[s.Status]
+}
+
+func (s paperStatus) Next() []string {
+	return statusTransitions[s.Status]
+}
+
+func uploadTypeLabel(t string) string {
+	for _, u := range uploadTypes {
+		if u.Type == t {
+			return u.Label
+		}
+	}
+	return t
+}
+
+// paperStatuses returns statuses of papers with status changes.
+func (a *App) paperStatuses(token string) (map[paperKey]paperStatus, error) {
+	query := a.db.Order("id")
+	if token != "" {
+		query = query.Where("participant_token = ?", token)
+	}
+
+	var changes []StatusChange
+	if err := query.Find(&changes).Error; err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[paperKey]paperStatus)
+	for _, change := range changes {
+		key := paperKey{change.ParticipantToken, change.Type}
+		status := statuses[key]
+		status.Status = change.To
+		status.History = append(status.History, change)
+		statuses[key] = status
+	}
+
+	return statuses, nil
+}
+
+func (a *App) statusOf(key paperKey) (paperStatus, error) {
+	statuses, err := a.paperStatuses(key.Token)
+	if err != nil {
+		return paperStatus{}, err
+	}
+
+	status, ok := statuses[key]
+	if !ok {
+		status.Status = StatusReceived
+	}
+
+	return status, nil
+}
+
+// changeStatus records the transition and notifies the author.
+func (a *App) changeStatus(key paperKey, to, actor, note string) error {
+	status, err := a.statusOf(key)
+	if err != nil {
+		return err
+	}
+
+	if !canTransition(status.Status, to) {
+		return fmt.Errorf("%w: %s to %s", ErrStatusTransition, status.Status, to)
+	}
+
+	change := StatusChange{
+		ParticipantToken: key.Token,
+		Type:             key.Type,
+		From:             status.Status,
+		To:               to,
+		Actor:            actor,
+		Note:             note,
+	}
+	if err := a.db.Create(&change).Error; err != nil {
+		return err
+	}
+
+	a.sendStatusEmail(key, to, note)
+
+	return nil
+}
+
+func (a *App) sendStatusEmail(key paperKey, status, note string) {
+	email, ok := statusEmails[status]
+	if !ok {
+		return
+	}
+
+	participant, err := a.participantByToken(key.Token)
+	if err != nil {
+		a.log.Errorf("Can't notify about status of %s: %v", key, err)
+		return
+	}
+
+	if note != "" {
+		note = "<p>" + strings.ReplaceAll(html.EscapeString(note), "\n", "<br>") + "</p>"
+	}
+
+	nameSurname := strings.Join([]string{participant.Name, participant.Surname}, " ")
+	if err := a.sendEmail(
+		To{nameSurname, participant.Email},
+		Message{email.Subject, fmt.Sprintf(email.Text, nameSurname, uploadTypeLabel(key.Type), html.EscapeString(participant.PresentationTitle), note, a.config.Domain, participant.Token)},
+	); err != nil {
+		a.log.Errorf("Can't send email to %s: %v", participant.Email, err)
+	}
+}
+
+// submissionReceived records the first upload of a paper and a new version
+// uploaded after a revision was requested.
+func (a *App) submissionReceived(submission Submission, actor string) {
+	key := paperKey{submission.Token, submission.Type}
+
+	status, err := a.statusOf(key)
+	if err != nil {
+		a.log.Error(err)
+		return
+	}
+
+	if len(status.History) > 0 && status.Status != StatusRevisionRequested {
+		return
+	}
+
+	from := status.Status
+	if len(status.History) == 0 {
+		from = ""
+	}
+
+	if err := a.db.Create(&StatusChange{
+		ParticipantToken: key.Token,
+		Type:             key.Type,
+		From:             from,
+		To:               StatusReceived,
+		Actor:            actor,
+		Note:             fmt.Sprintf("Version %d uploaded", submission.Version),
+	}).Error; err != nil {
+		a.log.Error(err)
+	}
+}
+
+// adminActor is the admin making the request.
+func adminActor(c *fiber.Ctx) string {
+	if username, ok := c.Locals("username").(string); ok && username != "" {
+		return username
+	}
+	return "admin"
+}
+
+func (a *App) changePaperStatus(c *fiber.Ctx) error {
+	key, ok := parsePaperKey(c.FormValue("paper"))
+	if !ok {
+		return c.Redirect("/admin/reviews")
+	}
+
+	before, err := a.statusOf(key)
+	if err != nil {
+		a.log.Error(err)
+		return c.Redirect("/admin/reviews?status=" + c.FormValue("filter"))
+	}
+
+	to, note := c.FormValue("status"), strings.TrimSpace(c.FormValue("note"))
+	if err := a.changeStatus(key, to, adminActor(c), note); err != nil {
+		a.log.Errorf("Can't change status of %s: %v", key, err)
+	} else {
+		a.audit(c, "paper.status", "paper "+key.Code(), map[string]string{"Status": before.Status}, map[string]string{"Status": to, "Note": note})
+	}
+
+	return c.Redirect("/admin/reviews?status=" + c.FormValue("filter"))
+}
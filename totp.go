@@ -0,0 +1,398 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"fmt"
+	"image/png"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer = "AMTC Admin"
+	totpPeriod = 30
+
+	recoveryCodeCount = 10
+
+	trustedDeviceCookie = "amtc_device"
+	// TrustedDeviceLifetime is how long a remembered browser skips the
+	// second factor.
+	TrustedDeviceLifetime = 30 * 24 * time.Hour
+
+	// mfaPendingLifetime is the time to enter the code after the password.
+	mfaPendingLifetime = 5 * time.Minute
+)
+
+func totpOpts() totp.ValidateOpts {
+	return totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+}
+
+// verifyTOTP returns the time step of the code, allowing a step of clock
+// skew either way.
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	step := now.Unix() / totpPeriod
+
+	for _, s := range []int64{step - 1, step, step + 1} {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(s*totpPeriod, 0), totpOpts())
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
+
+func totpURL(user AdminUser) string {
+	v := url.Values{}
+	v.Set("secret", user.TOTPSecret)
+	v.Set("issuer", totpIssuer)
+	v.Set("period", fmt.Sprint(totpPeriod))
+	v.Set("digits", "6")
+	v.Set("algorithm", "SHA1")
+
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+user.Username) + "?" + v.Encode()
+}
+
+// newRecoveryCode is 8 characters in two groups, like abcd-efgh.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return code[:4] + "-" + code[4:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
+
+// replaceRecoveryCodes invalidates the old codes and returns new ones to be
+// shown once.
+func (a *App) replaceRecoveryCodes(user AdminUser) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("admin_user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for i := 0; i < recoveryCodeCount; i++ {
+			code, err := newRecoveryCode()
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(&RecoveryCode{AdminUserID: user.ID, Hash: sha256Hex(code)}).Error; err != nil {
+				return err
+			}
+			codes = append(codes, code)
+		}
+		return nil
+	})
+
+	return codes, err
+}
+
+// checkSecondFactor accepts a one-time code not used before or an unused
+// recovery code.
+func (a *App) checkSecondFactor(user AdminUser, code string) (bool, error) {
+	if strings.Contains(code, "-") {
+		result := a.db.Model(&RecoveryCode{}).
+			Where("admin_user_id = ? AND hash = ? AND used_at IS NULL", user.ID, sha256Hex(normalizeRecoveryCode(code))).
+			Update("used_at", time.Now())
+		return result.RowsAffected == 1, result.Error
+	}
+
+	step, ok := verifyTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// A code is used once, concurrent requests with it race for the step.
+	result := a.db.Model(&AdminUser{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (a *App) deviceTrusted(cookie string, user AdminUser) bool {
+	if cookie == "" || user.ID == 0 {
+		return false
+	}
+
+	var count int64
+	if err := a.db.Model(&TrustedDevice{}).Where("id = ? AND admin_user_id = ? AND expires_at > ?", sha256Hex(cookie), user.ID, time.Now()).Count(&count).Error; err != nil {
+		a.log.Error(err)
+	}
+
+	return count > 0
+}
+
+func (a *App) trustDevice(c *fiber.Ctx, user AdminUser) error {
+	token, err := newSessionToken()
+	if err != nil {
+		return err
+	}
+
+	device := TrustedDevice{
+		ID:          sha256Hex(token),
+		ExpiresAt:   time.Now().Add(TrustedDeviceLifetime),
+		AdminUserID: user.ID,
+		UserAgent:   c.Get(fiber.HeaderUserAgent),
+	}
+	if err := a.db.Create(&device).Error; err != nil {
+		return err
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     trustedDeviceCookie,
+		Value:    token,
+		Path:     "/admin",
+		Expires:  device.ExpiresAt,
+		Secure:   strings.HasPrefix(a.config.Domain, "https://"),
+		HTTPOnly: true,
+		SameSite: "Strict",
+	})
+
+	return nil
+}
+
+// forgetDevices asks for the second factor again on every browser.
+func (a *App) forgetDevices(user AdminUser) {
+	if err := a.db.Where("admin_user_id = ?", user.ID).Delete(&TrustedDevice{}).Error; err != nil {
+		a.log.Error(err)
+	}
+}
+
+// resetTOTP turns the second factor off, for a lost phone.
+func (a *App) resetTOTP(user AdminUser) error {
+	if err := a.db.Model(&user).Updates(map[string]interface{}{"totp_secret": "", "totp_enabled": false, "totp_last_step": 0}).Error; err != nil {
+		return err
+	}
+	if err := a.db.Where("admin_user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	a.forgetDevices(user)
+	return nil
+}
+
+// pendingSession is the session waiting for the second factor.
+func (a *App) pendingSession(c *fiber.Ctx) (AdminSession, AdminUser, bool) {
+	var session AdminSession
+	var user AdminUser
+
+	if err := a.db.Where("id = ?", sha256Hex(c.Cookies(adminSessionCookie))).First(&session).Error; err != nil {
+		return session, user, false
+	}
+	if !session.MFAPending || session.RevokedAt != nil || time.Since(session.CreatedAt) > mfaPendingLifetime {
+		return session, user, false
+	}
+	if err := a.db.Where("username = ?", session.Username).First(&user).Error; err != nil || user.Disabled {
+		return session, user, false
+	}
+
+	return session, user, true
+}
+
+func (a *App) loginTOTPView(c *fiber.Ctx) error {
+	if _, _, ok := a.pendingSession(c); !ok {
+		return c.Redirect("/admin/login")
+	}
+
+	return c.Render("admin-login-totp", fiber.Map{
+		"Title": "Admin sign in",
+		"Next":  c.Query("next"),
+	})
+}
+
+func (a *App) loginTOTP(c *fiber.Ctx) error {
+	session, user, ok := a.pendingSession(c)
+	if !ok {
+		return c.Redirect("/admin/login")
+	}
+
+	render := func(message string) error {
+		c.Status(fiber.StatusUnauthorized)
+		return c.Render("admin-login-totp", fiber.Map{
+			"Title": "Admin sign in",
+			"Next":  c.FormValue("next"),
+			"Error": message,
+		})
+	}
+
+	locked, err := a.lockedOut(user.Username, c.IP())
+	if err != nil {
+		return err
+	}
+	if locked {
+		if err := a.db.Model(&session).Update("revoked_at", time.Now()).Error; err != nil {
+			a.log.Error(err)
+		}
+		return c.Redirect("/admin/login")
+	}
+
+	ok, err = a.checkSecondFactor(user, c.FormValue("code"))
+	if err != nil {
+		a.log.Error(err)
+		return render("Can't sign in, try again later.")
+	}
+	if err := a.db.Create(&LoginAttempt{Username: user.Username, IP: c.IP(), Success: ok}).Error; err != nil {
+		a.log.Error(err)
+	}
+	if !ok {
+		a.auditAs(user.Username, c.IP(), "login.failed", "user "+user.Username, nil, map[string]string{"method": "totp"})
+		return render("Wrong code.")
+	}
+
+	if err := a.db.Model(&session).Updates(map[string]interface{}{"mfa_pending": false, "last_seen_at": time.Now()}).Error; err != nil {
+		return err
+	}
+
+	if c.FormValue("remember") == "on" {
+		if err := a.trustDevice(c, user); err != nil {
+			a.log.Error(err)
+		}
+	}
+	method := "password, totp"
+	if session.SSO {
+		method = "sso, totp"
+	}
+	a.auditAs(user.Username, c.IP(), "login", "user "+user.Username, nil, map[string]string{"method": method})
+
+	return c.Redirect(adminNext(c.FormValue("next")))
+}
+
+func (a *App) renderTOTP(c *fiber.Ctx, user AdminUser, data fiber.Map) error {
+	var left int64
+	if err := a.db.Model(&RecoveryCode{}).Where("admin_user_id = ? AND used_at IS NULL", user.ID).Count(&left).Error; err != nil {
+		a.log.Error(err)
+	}
+
+	data["Title"] = "Two-factor authentication"
+	data["User"] = user
+	data["Required"] = a.config.TOTPRequired(user.Role)
+	data["RecoveryLeft"] = left
+
+	return c.Render("admin-totp", data)
+}
+
+// totpView shows the state of the second factor, or starts enrollment with
+// a new secret.
+func (a *App) totpView(c *fiber.Ctx) error {
+	user, _ := c.Locals("admin").(AdminUser)
+	if user.ID == 0 {
+		return c.Redirect("/admin")
+	}
+
+	if !user.TOTPEnabled && user.TOTPSecret == "" {
+		key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: user.Username, Period: totpPeriod})
+		if err != nil {
+			return err
+		}
+		user.TOTPSecret = key.Secret()
+		if err := a.db.Model(&user).Update("totp_secret", user.TOTPSecret).Error; err != nil {
+			return err
+		}
+	}
+
+	return a.renderTOTP(c, user, fiber.Map{})
+}
+
+// totpQR is the QR code of the secret, only while enrolling.
+func (a *App) totpQR(c *fiber.Ctx) error {
+	user, _ := c.Locals("admin").(AdminUser)
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		return fiber.ErrNotFound
+	}
+
+	key, err := otp.NewKeyFromURL(totpURL(user))
+	if err != nil {
+		return err
+	}
+	img, err := key.Image(240, 240)
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, "image/png")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return png.Encode(c, img)
+}
+
+func (a *App) enableTOTP(c *fiber.Ctx) error {
+	user, _ := c.Locals("admin").(AdminUser)
+	if user.ID == 0 || user.TOTPEnabled || user.TOTPSecret == "" {
+		return c.Redirect("/admin/totp")
+	}
+
+	step, ok := verifyTOTP(user.TOTPSecret, c.FormValue("code"), time.Now())
+	if !ok {
+		return a.renderTOTP(c, user, fiber.Map{"Error": "Wrong code, check the time on your phone and try again."})
+	}
+
+	if err := a.db.Model(&user).Updates(map[string]interface{}{"totp_enabled": true, "totp_last_step": step}).Error; err != nil {
+		return err
+	}
+	user.TOTPEnabled = true
+	a.audit(c, "totp.enable", "user "+user.Username, nil, nil)
+
+	codes, err := a.replaceRecoveryCodes(user)
+	if err != nil {
+		return err
+	}
+
+	return a.renderTOTP(c, user, fiber.Map{"Codes": codes})
+}
+
+func (a *App) disableTOTP(c *fiber.Ctx) error {
+	user, _ := c.Locals("admin").(AdminUser)
+	if !user.TOTPEnabled {
+		return c.Redirect("/admin/totp")
+	}
+	if a.config.TOTPRequired(user.Role) {
+		return a.renderTOTP(c, user, fiber.Map{"Error": "Two-factor authentication is required for your role."})
+	}
+
+	ok, err := a.checkSecondFactor(user, c.FormValue("code"))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return a.renderTOTP(c, user, fiber.Map{"Error": "Wrong code."})
+	}
+
+	if err := a.resetTOTP(user); err != nil {
+		return err
+	}
+	a.audit(c, "totp.disable", "user "+user.Username, nil, nil)
+
+	return c.Redirect("/admin/totp")
+}
+
+func (a *App) regenerateRecoveryCodes(c *fiber.Ctx) error {
+	user, _ := c.Locals("admin").(AdminUser)
+	if !user.TOTPEnabled {
+		return c.Redirect("/admin/totp")
+	}
+
+	ok, err := a.checkSecondFactor(user, c.FormValue("code"))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return a.renderTOTP(c, user, fiber.Map{"Error": "Wrong code."})
+	}
+
+	codes, err := a.replaceRecoveryCodes(user)
+	if err != nil {
+		return err
+	}
+	a.audit(c, "totp.recovery-codes", "user "+user.Username, nil, nil)
+
+	return a.renderTOTP(c, user, fiber.Map{"Codes": codes})
+}
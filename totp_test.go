@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestCheckSecondFactorOnce(t *testing.T) {
+	a := newTestApp(t)
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: "olga", Period: totpPeriod})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := AdminUser{Username: "olga", Role: RoleOrganizer, TOTPSecret: key.Secret(), TOTPEnabled: true}
+	if err := a.db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.GenerateCodeCustom(key.Secret(), time.Now(), totpOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests loaded the account before any of them used the code.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := a.checkSecondFactor(user, code)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted != 1 {
+		t.Errorf("code accepted %d times, want once", accepted)
+	}
+	if ok, _ := a.checkSecondFactor(user, "000000"); ok {
+		t.Error("wrong code accepted")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"embed"
 	"fmt"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -19,13 +20,32 @@ func (a *App) mainView(c *fiber.Ctx) error {
 }
 
 func (a *App) programOverviewView(c *fiber.Ctx) error {
+	days, err := a.programme()
+	if err != nil {
+		a.log.Error(err)
+	}
+
 	c.Bind(fiber.Map{
 		"Title": "Programme Overview",
 	})
-	return c.Render("programm-overview", fiber.Map{})
+	return c.Render("programm-overview", fiber.Map{
+		"Dates": a.importantDates(days),
+		"Days":  days,
+	})
 }
 
 func (a *App) keynoteSpeakersView(c *fiber.Ctx) error {
+	keynotes, err := a.keynotes()
+	if err != nil {
+		a.log.Error(err)
+	}
+	if len(keynotes) > 0 {
+		c.Bind(fiber.Map{
+			"Title": "Keynote Speakers",
+		})
+		return c.Render("keynote-speakers", fiber.Map{"Keynotes": keynotes})
+	}
+
 	c.Bind(fiber.Map{
 		"Title":   "Keynote Speakers",
 		"Content": "Key speakers to be determined later.",
@@ -56,17 +76,92 @@ func (a *App) registrationView(c *fiber.Ctx) error {
 }
 
 func (a *App) adminView(c *fiber.Ctx) error {
-	var participants []Participant
-	if err := a.db.Find(&participants).Error; err != nil {
+	if admin, _ := c.Locals("admin").(AdminUser); !admin.Can(PermView) {
+		c.Bind(fiber.Map{
+			"Title":   "Admin panel",
+			"Content": "This account has no role that opens the admin panel, ask a superadmin to give it one.",
+		})
+		return c.Render("basic", fiber.Map{})
+	}
+
+	if err := a.bindParticipantTable(c); err != nil {
 		a.log.Error(err)
 		c.Bind(fiber.Map{"Errors": map[string]string{"getParticipants": "Can't fetch participants"}})
-	} else {
-		c.Bind(fiber.Map{"Users": participants})
 	}
 
 	return c.Render("admin", fiber.Map{})
 }
 
+const (
+	uploadsPerPage     = 50
+	uploadsQuarantined = 50
+	uploadsFiles       = 200
+)
+
+// uploadsFolders are the folders stored files are listed by.
+func uploadsFolders() []string {
+	folders := make([]string, 0, len(uploadTypes)+2)
+	for _, t := range uploadTypes {
+		folders = append(folders, t.Type)
+	}
+	return append(folders, CameraReadyDeadline, "quarantine")
+}
+
+// uploadsAdminView lists a page of submissions, the latest quarantined
+// uploads and stored files of one folder, listing the whole disk is slow.
+func (a *App) uploadsAdminView(c *fiber.Ctx) error {
+	folders := uploadsFolders()
+	folder := c.Query("folder")
+	if !contains(folders, folder) {
+		folder = folders[0]
+	}
+	page, _ := strconv.Atoi(c.Query("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	var total int64
+	if err := a.db.Model(&Submission{}).Count(&total).Error; err != nil {
+		return err
+	}
+	pages := paginate(page, uploadsPerPage, total)
+
+	var submissions []Submission
+	if err := a.db.Order("created_at desc").Offset((pages.Page - 1) * uploadsPerPage).Limit(uploadsPerPage).Find(&submissions).Error; err != nil {
+		return err
+	}
+
+	var quarantinedTotal int64
+	if err := a.db.Model(&QuarantinedFile{}).Count(&quarantinedTotal).Error; err != nil {
+		return err
+	}
+	var quarantined []QuarantinedFile
+	if err := a.db.Order("created_at desc").Limit(uploadsQuarantined).Find(&quarantined).Error; err != nil {
+		return err
+	}
+
+	files, err := a.disk.List(folder + "/")
+	if err != nil {
+		a.log.Error(err)
+	}
+	filesTotal := len(files)
+	if len(files) > uploadsFiles {
+		files = files[:uploadsFiles]
+	}
+
+	return c.Render("admin-uploads", fiber.Map{
+		"Title":            "Uploads",
+		"Submissions":      submissions,
+		"Pagination":       pages,
+		"Quarantined":      quarantined,
+		"QuarantinedTotal": quarantinedTotal,
+		"Folders":          folders,
+		"Folder":           folder,
+		"Files":            files,
+		"FilesTotal":       filesTotal,
+	})
+}
+
 func (a *App) notFoundView(c *fiber.Ctx) error {
 	c.Bind(fiber.Map{
 		"Title":   "Page Not Found",
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// listingDisk records the prefixes listed.
+type listingDisk struct {
+	Disk
+	listed []string
+}
+
+func (d *listingDisk) List(prefix string) ([]FileInfo, error) {
+	d.listed = append(d.listed, prefix)
+	return d.Disk.List(prefix)
+}
+
+func TestUploadsAdminView(t *testing.T) {
+	a := newTestApp(t)
+	disk := &listingDisk{Disk: a.disk}
+	a.disk = disk
+	server := newServer(a.config)
+	server.Use(func(c *fiber.Ctx) error {
+		c.Locals("admin", AdminUser{Username: "olga", Role: RoleOrganizer})
+		return c.Next()
+	})
+	server.Get("/admin", a.adminView)
+	server.Get("/admin/uploads", a.uploadsAdminView)
+
+	for i := 1; i <= uploadsPerPage+1; i++ {
+		name := fmt.Sprintf("article/paper-%d.docx", i)
+		if err := a.disk.Save(strings.NewReader("paper"), name); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.db.Create(&Submission{Token: fmt.Sprint(i), Type: "article", Version: 1, Path: name, OriginalName: name}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.disk.Save(strings.NewReader("paper"), "tezis/abstract.docx"); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(target string) string {
+		resp, err := server.Test(httptest.NewRequest(http.MethodGet, target, nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	get("/admin")
+	if len(disk.listed) > 0 {
+		t.Errorf("admin panel listed the disk: %q", disk.listed)
+	}
+
+	body := get("/admin/uploads?folder=article")
+	if len(disk.listed) != 1 || disk.listed[0] != "article/" {
+		t.Errorf("uploads listed %q, want the article folder", disk.listed)
+	}
+	if n := strings.Count(body, `href="/admin/files/article/`); n != uploadsPerPage+uploadsPerPage+1 {
+		t.Errorf("page has %d article links, want %d submissions and %d files", n, uploadsPerPage, uploadsPerPage+1)
+	}
+	if strings.Contains(body, "tezis/abstract.docx") {
+		t.Error("files of other folders are listed")
+	}
+
+	body = get("/admin/uploads?folder=article&page=2")
+	if !strings.Contains(body, "51&ndash;51 of 51") {
+		t.Errorf("second page of submissions is not 51 of 51")
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const YandexDiskAPIURL = "https://cloud-api.yandex.net/v1/disk"
+
+// YandexDisk stores files in a folder of Yandex Disk through its REST API.
+type YandexDisk struct {
+	// BaseURL is the API endpoint, YandexDiskAPIURL unless overridden.
+	BaseURL string
+	Token   string
+	// Root is the folder all file names are resolved against, e.g. "AMTC".
+	Root string
+
+	Retries int
+	Backoff time.Duration
+
+	client *http.Client
+}
+
+type YandexDiskError struct {
+	Status      int
+	Err         string `json:"error"`
+	Description string `json:"description"`
+}
+
+func (e *YandexDiskError) Error() string {
+	return fmt.Sprintf("yandex disk: %d %s: %s", e.Status, e.Err, e.Description)
+}
+
+// YandexDiskLink is returned by the upload and download endpoints.
+type YandexDiskLink struct {
+	OperationId string `json:"operation_id"`
+	URL         string `json:"href"`
+	Method      string `json:"method"`
+	Templated   bool   `json:"templated"`
+}
+
+type YandexDiskResource struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Type     string    `json:"type"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+}
+
+func NewYandexDisk(config YandexDiskConfig) (*YandexDisk, error) {
+	if config.Token == "" {
+		return nil, fmt.Errorf("yandex disk: oauth token is empty")
+	}
+
+	d := &YandexDisk{
+		BaseURL: YandexDiskAPIURL,
+		Token:   config.Token,
+		Root:    strings.Trim(config.Root, "/"),
+		Retries: 3,
+		Backoff: 500 * time.Millisecond,
+		client:  &http.Client{Timeout: 60 * time.Second},
+	}
+
+	if d.Root != "" {
+		if err := d.mkdirAll(d.Root); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// rootPath is the folder of the files on the disk.
+func (d *YandexDisk) rootPath() string {
+	return "disk:/" + d.Root
+}
+
+// remotePath rejects names escaping Root like the other disks do.
+func (d *YandexDisk) remotePath(fileName string) (string, error) {
+	name, err := cleanFileName(fileName)
+	if err != nil {
+		return "", err
+	}
+	return "disk:/" + path.Join(d.Root, name), nil
+}
+
+// fileName is the inverse of remotePath.
+func (d *YandexDisk) fileName(remotePath string) string {
+	name := strings.TrimPrefix(remotePath, "disk:/")
+	if d.Root != "" {
+		name = strings.TrimPrefix(name, d.Root+"/")
+	}
+	return name
+}
+
+func (r YandexDiskResource) fileInfo(name string) FileInfo {
+	return FileInfo{Name: name, Size: r.Size, ModTime: r.Modified}
+}
+
+// do sends a request to the API, retrying on network errors, 429 and 5xx
+// responses. body is replayed on every attempt.
+func (d *YandexDisk) do(method, rawURL string, body []byte, auth bool) (*http.Response, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= d.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(d.Backoff * time.Duration(1<<(attempt-1)))
+		}
+
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+
+		req, err := http.NewRequest(method, rawURL, reader)
+		if err != nil {
+			return nil, err
+		}
+		if auth {
+			req.Header.Set("Authorization", "OAuth "+d.Token)
+		}
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := d.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = decodeYandexDiskError(resp)
+			continue
+		}
+
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("yandex disk: %s %s failed after %d attempts: %w", method, rawURL, d.Retries+1, lastErr)
+}
+
+func decodeYandexDiskError(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	e := &YandexDiskError{Status: resp.StatusCode}
+	_ = json.NewDecoder(resp.Body).Decode(e)
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("yandex disk: %w: %s", ErrFileNotFound, e.Description)
+	}
+
+	return e
+}
+
+// api calls an endpoint relative to BaseURL and decodes a JSON response into v.
+func (d *YandexDisk) api(method, endpoint string, query url.Values, v any) error {
+	resp, err := d.do(method, d.BaseURL+endpoint+"?"+query.Encode(), nil, true)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		return decodeYandexDiskError(resp)
+	}
+	defer resp.Body.Close()
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (d *YandexDisk) mkdirAll(dir string) error {
+	current := ""
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		current = path.Join(current, part)
+
+		err := d.api(http.MethodPut, "/resources", url.Values{"path": {"disk:/" + current}}, nil)
+
+		var e *YandexDiskError
+		if errors.As(err, &e) && e.Status == http.StatusConflict {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("can't create folder '%s': %w", current, err)
+		}
+	}
+
+	return nil
+}
+
+// Save uploads a file, overwriting an existing one. Folders on the way are
+// created when missing.
+func (d *YandexDisk) Save(file io.Reader, fileName string) error {
+	remotePath, err := d.remotePath(fileName)
+	if err != nil {
+		return err
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	if dir := path.Dir(strings.TrimPrefix(remotePath, "disk:/")); dir != "." && dir != d.Root {
+		if err := d.mkdirAll(dir); err != nil {
+			return err
+		}
+	}
+
+	var link YandexDiskLink
+	query := url.Values{"path": {remotePath}, "overwrite": {"true"}}
+	if err := d.api(http.MethodGet, "/resources/upload", query, &link); err != nil {
+		return fmt.Errorf("can't get upload url for '%s': %w", fileName, err)
+	}
+
+	method := link.Method
+	if method == "" {
+		method = http.MethodPut
+	}
+
+	// The upload URL is pre-signed and must not receive the OAuth token.
+	resp, err := d.do(method, link.URL, content, false)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("yandex disk: upload of '%s' failed with status %d", fileName, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// List walks the folders under Root, folders outside the prefix are skipped.
+func (d *YandexDisk) List(prefix string) ([]FileInfo, error) {
+	files := make([]FileInfo, 0)
+
+	if err := d.walk(d.rootPath(), prefix, &files); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	return files, nil
+}
+
+// walk appends files of the folder and its subfolders named with the prefix.
+func (d *YandexDisk) walk(dir, prefix string, files *[]FileInfo) error {
+	const limit = 1000
+
+	for offset := 0; ; offset += limit {
+		var folder struct {
+			Embedded struct {
+				Items []YandexDiskResource `json:"items"`
+			} `json:"_embedded"`
+		}
+		query := url.Values{
+			"path":   {dir},
+			"limit":  {fmt.Sprint(limit)},
+			"offset": {fmt.Sprint(offset)},
+			"fields": {"_embedded.items.name,_embedded.items.path,_embedded.items.type,_embedded.items.size,_embedded.items.modified"},
+		}
+		if err := d.api(http.MethodGet, "/resources", query, &folder); err != nil {
+			return err
+		}
+
+		for _, item := range folder.Embedded.Items {
+			name := d.fileName(item.Path)
+			switch item.Type {
+			case "dir":
+				if strings.HasPrefix(name+"/", prefix) || strings.HasPrefix(prefix, name+"/") {
+					if err := d.walk(item.Path, prefix, files); err != nil {
+						return err
+					}
+				}
+			case "file":
+				if strings.HasPrefix(name, prefix) {
+					*files = append(*files, item.fileInfo(name))
+				}
+			}
+		}
+
+		if len(folder.Embedded.Items) < limit {
+			return nil
+		}
+	}
+}
+
+func (d *YandexDisk) Stat(fileName string) (FileInfo, error) {
+	remotePath, err := d.remotePath(fileName)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	var resource YandexDiskResource
+	query := url.Values{"path": {remotePath}, "fields": {"name,path,type,size,modified"}}
+	if err := d.api(http.MethodGet, "/resources", query, &resource); err != nil {
+		return FileInfo{}, err
+	}
+
+	if resource.Type != "file" {
+		return FileInfo{}, fmt.Errorf("%w: %s", ErrFileNotFound, fileName)
+	}
+
+	return resource.fileInfo(fileName), nil
+}
+
+func (d *YandexDisk) Delete(fileName string) error {
+	remotePath, err := d.remotePath(fileName)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"path": {remotePath}, "permanently": {"true"}}
+	return d.api(http.MethodDelete, "/resources", query, nil)
+}
+
+// Open downloads a file. The caller must close the returned reader.
+func (d *YandexDisk) Open(fileName string) (io.ReadCloser, error) {
+	remotePath, err := d.remotePath(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var link YandexDiskLink
+	if err := d.api(http.MethodGet, "/resources/download", url.Values{"path": {remotePath}}, &link); err != nil {
+		return nil, fmt.Errorf("can't get download url for '%s': %w", fileName, err)
+	}
+
+	resp, err := d.do(http.MethodGet, link.URL, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeYandexDiskError(resp)
+	}
+
+	return resp.Body, nil
+}
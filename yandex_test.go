@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const fakeYandexToken = "test-token"
+
+// fakeYandexDisk is an in-memory Yandex Disk REST API.
+type fakeYandexDisk struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	folders map[string]bool
+	files   map[string][]byte
+	// failures is the number of next API requests answered with 503.
+	failures int
+	requests int
+
+	server *httptest.Server
+}
+
+func newFakeYandexDisk(t *testing.T) *fakeYandexDisk {
+	f := &fakeYandexDisk{t: t, folders: map[string]bool{"disk:": true}, files: map[string][]byte{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/disk/resources", f.api(f.resources))
+	mux.HandleFunc("/v1/disk/resources/upload", f.api(f.uploadLink))
+	mux.HandleFunc("/v1/disk/resources/download", f.api(f.downloadLink))
+	mux.HandleFunc("/v1/disk/resources/files", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("listed every file of the account")
+	})
+	mux.HandleFunc("/upload", f.upload)
+	mux.HandleFunc("/download", f.download)
+
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeYandexDisk) disk(root string) *YandexDisk {
+	return &YandexDisk{
+		BaseURL: f.server.URL + "/v1/disk",
+		Token:   fakeYandexToken,
+		Root:    root,
+		Retries: 2,
+		Backoff: time.Millisecond,
+		client:  f.server.Client(),
+	}
+}
+
+func (f *fakeYandexDisk) fail(w http.ResponseWriter, status int, err string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err, "description": err})
+}
+
+// api checks the OAuth token of API requests and injects failures.
+func (f *fakeYandexDisk) api(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.requests++
+		if f.failures > 0 {
+			f.failures--
+			f.fail(w, http.StatusServiceUnavailable, "ServiceUnavailable")
+			return
+		}
+		if r.Header.Get("Authorization") != "OAuth "+fakeYandexToken {
+			f.fail(w, http.StatusUnauthorized, "UnauthorizedError")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (f *fakeYandexDisk) parent(p string) string {
+	return p[:strings.LastIndex(p, "/")]
+}
+
+func (f *fakeYandexDisk) resources(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+
+	switch r.Method {
+	case http.MethodPut:
+		if f.folders[p] {
+			f.fail(w, http.StatusConflict, "DiskPathPointsToExistentDirectoryError")
+			return
+		}
+		if !f.folders[f.parent(p)] {
+			f.fail(w, http.StatusConflict, "DiskPathDoesntExistsError")
+			return
+		}
+		f.folders[p] = true
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		if folder := strings.TrimSuffix(p, "/"); f.folders[folder] {
+			f.list(w, r, folder)
+			return
+		}
+		content, ok := f.files[p]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "DiskNotFoundError")
+			return
+		}
+		json.NewEncoder(w).Encode(YandexDiskResource{Name: p[strings.LastIndex(p, "/")+1:], Path: p, Type: "file", Size: int64(len(content))})
+	case http.MethodDelete:
+		if _, ok := f.files[p]; !ok {
+			f.fail(w, http.StatusNotFound, "DiskNotFoundError")
+			return
+		}
+		delete(f.files, p)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeYandexDisk) uploadLink(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if !f.folders[f.parent(p)] {
+		f.fail(w, http.StatusConflict, "DiskPathDoesntExistsError")
+		return
+	}
+	json.NewEncoder(w).Encode(YandexDiskLink{URL: f.server.URL + "/upload?path=" + url.QueryEscape(p), Method: http.MethodPut})
+}
+
+func (f *fakeYandexDisk) downloadLink(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if _, ok := f.files[p]; !ok {
+		f.fail(w, http.StatusNotFound, "DiskNotFoundError")
+		return
+	}
+	json.NewEncoder(w).Encode(YandexDiskLink{URL: f.server.URL + "/download?path=" + url.QueryEscape(p), Method: http.MethodGet})
+}
+
+// list answers with a page of the folder contents.
+func (f *fakeYandexDisk) list(w http.ResponseWriter, r *http.Request, folder string) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+
+	// The order is not by name, map iteration shuffles it.
+	items := make([]YandexDiskResource, 0)
+	for p := range f.folders {
+		if p != "disk:" && f.parent(p) == folder {
+			items = append(items, YandexDiskResource{Name: p[strings.LastIndex(p, "/")+1:], Path: p, Type: "dir"})
+		}
+	}
+	for p, content := range f.files {
+		if f.parent(p) == folder {
+			items = append(items, YandexDiskResource{Name: p[strings.LastIndex(p, "/")+1:], Path: p, Type: "file", Size: int64(len(content))})
+		}
+	}
+	if offset > len(items) {
+		offset = len(items)
+	}
+	items = items[offset:]
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"path": folder, "type": "dir", "_embedded": map[string]interface{}{"items": items, "limit": limit, "offset": offset}})
+}
+
+// upload and download are pre-signed links, they must not get the token.
+func (f *fakeYandexDisk) upload(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "" {
+		f.t.Errorf("upload link got the OAuth token")
+	}
+	content, _ := io.ReadAll(r.Body)
+	f.files[r.URL.Query().Get("path")] = content
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (f *fakeYandexDisk) download(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "" {
+		f.t.Errorf("download link got the OAuth token")
+	}
+	w.Write(f.files[r.URL.Query().Get("path")])
+}
+
+func TestYandexDiskSaveOpen(t *testing.T) {
+	fake := newFakeYandexDisk(t)
+	d := fake.disk("AMTC")
+	if err := d.mkdirAll(d.Root); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Save(strings.NewReader("paper"), "tezis/a.docx"); err != nil {
+		t.Fatal(err)
+	}
+	if !fake.folders["disk:/AMTC/tezis"] {
+		t.Errorf("folder of the file isn't created: %v", fake.folders)
+	}
+	if got := string(fake.files["disk:/AMTC/tezis/a.docx"]); got != "paper" {
+		t.Errorf("stored %q, want %q", got, "paper")
+	}
+
+	// Overwrites.
+	if err := d.Save(strings.NewReader("paper v2"), "tezis/a.docx"); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := d.Open("tezis/a.docx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	content, _ := io.ReadAll(file)
+	if string(content) != "paper v2" {
+		t.Errorf("opened %q, want %q", content, "paper v2")
+	}
+
+	info, err := d.Stat("tezis/a.docx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "tezis/a.docx" || info.Size != int64(len("paper v2")) {
+		t.Errorf("stat is %+v", info)
+	}
+}
+
+func TestYandexDiskNotFound(t *testing.T) {
+	d := newFakeYandexDisk(t).disk("")
+
+	if _, err := d.Open("missing.docx"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Open error is %v, want ErrFileNotFound", err)
+	}
+	if _, err := d.Stat("missing.docx"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Stat error is %v, want ErrFileNotFound", err)
+	}
+	if err := d.Delete("missing.docx"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("Delete error is %v, want ErrFileNotFound", err)
+	}
+}
+
+func TestYandexDiskListDelete(t *testing.T) {
+	fake := newFakeYandexDisk(t)
+	d := fake.disk("AMTC")
+	if err := d.mkdirAll(d.Root); err != nil {
+		t.Fatal(err)
+	}
+	// Files outside the root are never listed.
+	fake.folders["disk:/other"], fake.folders["disk:/other/tezis"] = true, true
+	fake.files["disk:/other/tezis/x.docx"] = []byte("x")
+	fake.files["disk:/AMTC.docx"] = []byte("x")
+
+	for _, name := range []string{"tezis/c.docx", "article/b.pdf", "tezis/a.docx"} {
+		if err := d.Save(strings.NewReader(name), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := d.List("tezis/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name != "tezis/a.docx" || files[1].Name != "tezis/c.docx" {
+		t.Errorf("listed %+v, want tezis/a.docx and tezis/c.docx", files)
+	}
+
+	if err := d.Delete("tezis/a.docx"); err != nil {
+		t.Fatal(err)
+	}
+	files, err = d.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name != "article/b.pdf" || files[1].Name != "tezis/c.docx" {
+		t.Errorf("listed %+v after delete", files)
+	}
+}
+
+func TestYandexDiskRetries(t *testing.T) {
+	fake := newFakeYandexDisk(t)
+	d := fake.disk("")
+
+	fake.failures = d.Retries
+	if err := d.Save(strings.NewReader("paper"), "a.docx"); err != nil {
+		t.Fatalf("Save after %d failures: %v", d.Retries, err)
+	}
+
+	fake.failures = d.Retries + 1
+	fake.requests = 0
+	_, err := d.Stat("a.docx")
+	var e *YandexDiskError
+	if !errors.As(err, &e) || e.Status != http.StatusServiceUnavailable {
+		t.Errorf("Stat error is %v, want 503 after retries", err)
+	}
+	if fake.requests != d.Retries+1 {
+		t.Errorf("sent %d requests, want %d", fake.requests, d.Retries+1)
+	}
+}
+
+func TestYandexDiskUnauthorized(t *testing.T) {
+	d := newFakeYandexDisk(t).disk("")
+	d.Token = "wrong"
+
+	err := d.Save(strings.NewReader("paper"), "a.docx")
+	var e *YandexDiskError
+	if !errors.As(err, &e) || e.Status != http.StatusUnauthorized {
+		t.Errorf("Save error is %v, want 401", err)
+	}
+}
+
+func TestYandexDiskEscapingNames(t *testing.T) {
+	fake := newFakeYandexDisk(t)
+	d := fake.disk("AMTC")
+	fake.files["disk:/secret.txt"] = []byte("secret")
+
+	for _, name := range []string{"../secret.txt", "tezis/../../secret.txt", `..\secret.txt`, ""} {
+		if err := d.Save(strings.NewReader("x"), name); err == nil {
+			t.Errorf("Save(%q) succeeded", name)
+		}
+		if _, err := d.Open(name); err == nil {
+			t.Errorf("Open(%q) succeeded", name)
+		}
+		if _, err := d.Stat(name); err == nil {
+			t.Errorf("Stat(%q) succeeded", name)
+		}
+		if err := d.Delete(name); err == nil {
+			t.Errorf("Delete(%q) succeeded", name)
+		}
+	}
+	if fake.requests != 0 || string(fake.files["disk:/secret.txt"]) != "secret" {
+		t.Errorf("escaping names reached the API: %d requests", fake.requests)
+	}
+}
+
+func TestYandexDiskListPages(t *testing.T) {
+	fake := newFakeYandexDisk(t)
+	d := fake.disk("")
+	fake.folders["disk:/tezis"] = true
+	for i := 0; i < 1001; i++ {
+		fake.files["disk:/tezis/"+strconv.Itoa(i)+".docx"] = []byte("x")
+	}
+	fake.folders["disk:/article"] = true
+	fake.files["disk:/article/a.pdf"] = []byte("x")
+
+	files, err := d.List("tezis/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1001 {
+		t.Errorf("listed %d files, want 1001", len(files))
+	}
+}